@@ -26,8 +26,24 @@ func main() {
 	log.Info("config",
 		log.StringAttr("address", string(cfg.Producer.Address)),
 		log.IntAttr("poll interval", cfg.Producer.PollInterval),
+		log.StringAttr("collectors", cfg.Producer.Collectors.String()),
+		log.StringAttr("proc root", cfg.Producer.ProcRoot),
+		log.StringAttr("process pids", cfg.Producer.ProcessPIDs.String()),
+		log.StringAttr("process pidfiles", cfg.Producer.ProcessPIDFiles.String()),
+		log.StringAttr("process pattern", cfg.Producer.ProcessPattern),
+		log.StringAttr("cgroup root", cfg.Producer.CgroupRoot),
 		log.IntAttr("report interval", cfg.Producer.ReportInterval),
+		log.IntAttr("rate limit", cfg.Producer.RateLimit),
+		log.IntAttr("retry count", cfg.Producer.RetryCount),
+		log.StringAttr("spool dir", cfg.Producer.SpoolDir),
+		log.BoolAttr("signing", cfg.Producer.Key != ""),
+		log.BoolAttr("encryption", cfg.Producer.CryptoKey != ""),
+		log.BoolAttr("tls", cfg.Producer.TLS),
 	)
 
-	producer.Run(cfg)
+	err = producer.Run(cfg)
+	if err != nil {
+		log.Fatal("producer run error",
+			log.ErrAttr(err))
+	}
 }
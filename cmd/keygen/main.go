@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"metrics/internal/hybrid"
+	"metrics/internal/log"
+)
+
+func main() {
+	log.Prepare()
+
+	bits := flag.Int("bits", 4096, "RSA key size in bits")
+	privatePath := flag.String("private", "private.pem", "path to write the server private key")
+	publicPath := flag.String("public", "public.pem", "path to write the agent public key")
+	flag.Parse()
+
+	key, err := hybrid.GenerateKey(*bits)
+	if err != nil {
+		log.Fatal("generate key",
+			log.ErrAttr(err))
+	}
+
+	privatePEM, err := hybrid.EncodePrivateKey(key)
+	if err != nil {
+		log.Fatal("encode private key",
+			log.ErrAttr(err))
+	}
+
+	publicPEM, err := hybrid.EncodePublicKey(&key.PublicKey)
+	if err != nil {
+		log.Fatal("encode public key",
+			log.ErrAttr(err))
+	}
+
+	if err = os.WriteFile(*privatePath, privatePEM, 0o600); err != nil {
+		log.Fatal("write private key",
+			log.ErrAttr(err))
+	}
+
+	if err = os.WriteFile(*publicPath, publicPEM, 0o644); err != nil { //nolint:gosec // public key is public
+		log.Fatal("write public key",
+			log.ErrAttr(err))
+	}
+
+	log.Info("keys generated",
+		log.StringAttr("private", *privatePath),
+		log.StringAttr("public", *publicPath))
+}
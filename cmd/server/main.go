@@ -27,7 +27,18 @@ func main() {
 		log.StringAttr("address", string(cfg.Consumer.Address)),
 		log.Uint64Attr("store interval", cfg.Store.StoreInterval),
 		log.StringAttr("filepath", cfg.Store.FileStoragePath),
-		log.BoolAttr("should restore", cfg.Store.ShouldRestore))
+		log.BoolAttr("should restore", cfg.Store.ShouldRestore),
+		log.StringAttr("wal sync", cfg.Store.WALSync),
+		log.BoolAttr("database", cfg.Store.DatabaseDSN != ""),
+		log.StringAttr("storage", cfg.Store.Storage),
+		log.DurationAttr("history retention", cfg.Store.HistoryRetention),
+		log.DurationAttr("1m rollup retention", cfg.Store.RollupMinuteRetention),
+		log.DurationAttr("1h rollup retention", cfg.Store.RollupHourRetention),
+		log.BoolAttr("signing", cfg.Consumer.Key != ""),
+		log.BoolAttr("encryption", cfg.Consumer.CryptoKey != ""),
+		log.BoolAttr("tls", cfg.Consumer.TLSCert != ""),
+		log.StringAttr("trusted subnet", cfg.Consumer.TrustedSubnet.String()),
+		log.StringAttr("statsd", cfg.Consumer.StatsD))
 
 	err = consumer.Run(cfg)
 	if err != nil {
@@ -3,6 +3,8 @@ package config
 import (
 	"flag"
 	"fmt"
+	"net/netip"
+	"time"
 
 	"github.com/caarlos0/env/v11"
 	"github.com/go-playground/validator/v10"
@@ -17,24 +19,101 @@ const (
 type (
 	Address string
 
+	// Subnets is a list of CIDRs, empty means any address.
+	Subnets []netip.Prefix
+
+	// Buckets are increasing histogram bucket upper bounds, the +Inf bucket is implied.
+	Buckets []float64
+
+	// Quantiles are the summary quantiles reported on reads, each in [0, 1].
+	Quantiles []float64
+
+	// IntegerRules tell the type of the integer fields of the InfluxDB line protocol by the metric name,
+	// the first rule whose pattern matches wins.
+	IntegerRules []IntegerRule
+
+	IntegerRule struct {
+		Pattern    string
+		MetricType string
+	}
+
+	// PIDs are process ids, as watched by the process collector.
+	PIDs []int
+
+	// Paths are file paths, as the pidfiles of the process collector.
+	Paths []string
+
+	// Collectors are the agent collectors to run, each polled with its interval,
+	// a zero interval polls with the poll interval of the agent.
+	Collectors []CollectorSchedule
+
+	CollectorSchedule struct {
+		Name     string
+		Interval time.Duration
+	}
+
 	App struct {
 		Mode string `env:"APP_MODE" validate:"required,oneof=development production test"`
 	}
 
 	Consumer struct {
-		Address Address `env:"ADDRESS" validate:"url"`
+		Address          Address       `env:"ADDRESS"               validate:"url"`
+		Key              string        `env:"KEY"`
+		CryptoKey        string        `env:"CRYPTO_KEY"            validate:"omitempty,file"`
+		TLSCert          string        `env:"TLS_CERT"              validate:"required_with=TLSKey,omitempty,file"`
+		TLSKey           string        `env:"TLS_KEY"               validate:"required_with=TLSCert,omitempty,file"`
+		TLSClientCA      string        `env:"TLS_CLIENT_CA"         validate:"excluded_without=TLSCert,omitempty,file"`
+		TrustedSubnet    Subnets       `env:"TRUSTED_SUBNET"`
+		Buckets          Buckets       `env:"HISTOGRAM_BUCKETS"`
+		SummaryQuantiles Quantiles     `env:"SUMMARY_QUANTILES"`
+		StatsD           string        `env:"STATSD"                validate:"omitempty,startswith=udp://|startswith=unixgram://"`
+		StatsDFlush      time.Duration `env:"STATSD_FLUSH_INTERVAL" validate:"required_with=StatsD,min=0"`
+		InfluxRules      IntegerRules  `env:"INFLUX_INTEGER_RULES"`
+		RemoteWrite      bool          `env:"REMOTE_WRITE"`
+		InfluxWrite      bool          `env:"INFLUX_WRITE"`
+		WriteToken       string        `env:"WRITE_TOKEN"`
 	}
 
 	Producer struct {
-		Address        Address `env:"ADDRESS"         validate:"url"`
-		ReportInterval int     `env:"REPORT_INTERVAL" validate:"min=1"`
-		PollInterval   int     `env:"POLL_INTERVAL"   validate:"min=1"`
+		Address         Address       `env:"ADDRESS"         validate:"url"`
+		ReportInterval  int           `env:"REPORT_INTERVAL" validate:"min=1"`
+		PollInterval    int           `env:"POLL_INTERVAL"   validate:"min=1"`
+		RateLimit       int           `env:"RATE_LIMIT"      validate:"min=1"`
+		RetryCount      int           `env:"RETRY_COUNT"     validate:"min=0"`
+		RetryDelay      time.Duration `env:"RETRY_DELAY"     validate:"min=0"`
+		RetryMaxDelay   time.Duration `env:"RETRY_MAX_DELAY" validate:"gtefield=RetryDelay"`
+		SpoolDir        string        `env:"SPOOL_DIR"`
+		SpoolLimit      int           `env:"SPOOL_LIMIT"     validate:"min=1"`
+		Key             string        `env:"KEY"`
+		CryptoKey       string        `env:"CRYPTO_KEY"      validate:"omitempty,file"`
+		TLS             bool          `env:"TLS"`
+		TLSCA           string        `env:"TLS_CA"          validate:"omitempty,file"`
+		TLSCert         string        `env:"TLS_CERT"        validate:"required_with=TLSKey,omitempty,file"`
+		TLSKey          string        `env:"TLS_KEY"         validate:"required_with=TLSCert,omitempty,file"`
+		Collectors      Collectors    `env:"COLLECTORS"`
+		ProcRoot        string        `env:"PROC_ROOT"       validate:"required"`
+		ProcessPIDs     PIDs          `env:"PROCESS_PIDS"`
+		ProcessPIDFiles Paths         `env:"PROCESS_PIDFILES"`
+		ProcessPattern  string        `env:"PROCESS_PATTERN"`
+		CgroupRoot      string        `env:"CGROUP_ROOT"     validate:"required"`
 	}
 
 	Store struct {
-		StoreInterval   uint64 `env:"STORE_INTERVAL"`
-		FileStoragePath string `env:"FILE_STORAGE_PATH"`
-		ShouldRestore   bool   `env:"RESTORE"`
+		StoreInterval         uint64        `env:"STORE_INTERVAL"`
+		FileStoragePath       string        `env:"FILE_STORAGE_PATH"`
+		ShouldRestore         bool          `env:"RESTORE"`
+		SnapshotBackups       int           `env:"SNAPSHOT_BACKUPS"     validate:"min=0"`
+		WALSync               string        `env:"WAL_SYNC"             validate:"omitempty,oneof=always interval never"`
+		WALSyncInterval       time.Duration `env:"WAL_SYNC_INTERVAL"    validate:"min=0"`
+		WALCompactSize        int64         `env:"WAL_COMPACT_SIZE"     validate:"min=0"`
+		WALCompactRatio       float64       `env:"WAL_COMPACT_RATIO"    validate:"min=0"`
+		DatabaseDSN           string        `env:"DATABASE_DSN"`
+		Storage               string        `env:"STORAGE"              validate:"omitempty,startswith=sqlite://|startswith=chunks://"`
+		ChunkFlushInterval    time.Duration `env:"CHUNK_FLUSH_INTERVAL" validate:"min=0"`
+		HistoryRetention      time.Duration `env:"HISTORY_RETENTION"    validate:"min=0"`
+		HistoryPoints         int           `env:"HISTORY_POINTS"       validate:"min=1"`
+		RollupMinuteRetention time.Duration `env:"ROLLUP_1M_RETENTION"  validate:"min=0"`
+		RollupHourRetention   time.Duration `env:"ROLLUP_1H_RETENTION"  validate:"min=0"`
 	}
 
 	ConsumerConfig struct {
@@ -61,6 +140,32 @@ func NewConsumerConfig() (ConsumerConfig, error) {
 	flag.Uint64Var(&config.Store.StoreInterval, "i", 300, "store interval in seconds")
 	flag.StringVar(&config.Store.FileStoragePath, "f", "/tmp/metrics-db.json", "file storage path")
 	flag.BoolVar(&config.Store.ShouldRestore, "r", true, "restore storage or not")
+	flag.IntVar(&config.Store.SnapshotBackups, "snapshot-backups", 3, "number of previous snapshots kept as backups for the restore")
+	flag.StringVar(&config.Store.WALSync, "wal-sync", "interval", "when the file storage log is synced to disk: always, interval or never")
+	flag.DurationVar(&config.Store.WALSyncInterval, "wal-sync-interval", time.Second, "sync period of the file storage log for -wal-sync=interval")
+	flag.Int64Var(&config.Store.WALCompactSize, "wal-compact-size", 4<<20, "log size in bytes that triggers compaction into the snapshot, 0 disables it")
+	flag.Float64Var(&config.Store.WALCompactRatio, "wal-compact-ratio", 2, "log to snapshot size ratio that triggers compaction, 0 disables it")
+	flag.StringVar(&config.Store.DatabaseDSN, "d", "", "PostgreSQL DSN, enables the database storage")
+	flag.StringVar(&config.Store.Storage, "storage", "", "storage URL, sqlite:///path/to/metrics.db enables the embedded SQLite storage, chunks:///path/to/dir keeps every sample in compressed chunks")
+	flag.DurationVar(&config.Store.ChunkFlushInterval, "chunk-flush-interval", 10*time.Second, "how often the chunks being filled and the histograms and summaries of the chunks storage are written to disk")
+	flag.DurationVar(&config.Store.HistoryRetention, "history-retention", 0, "how long the values of every metric are kept for /range/, 0 disables the history")
+	flag.IntVar(&config.Store.HistoryPoints, "history-points", 1024, "most values kept per metric for /range/")
+	flag.DurationVar(&config.Store.RollupMinuteRetention, "rollup-1m-retention", 0, "how long the per minute rollups of every metric are kept for /rollup/, 0 disables them")
+	flag.DurationVar(&config.Store.RollupHourRetention, "rollup-1h-retention", 0, "how long the per hour rollups of every metric are kept for /rollup/, 0 disables them")
+	flag.StringVar(&config.Consumer.Key, "k", "", "key for HMAC-SHA256 signatures, empty disables signing")
+	flag.StringVar(&config.Consumer.CryptoKey, "crypto-key", "", "path to the RSA private key decrypting agent payloads")
+	flag.StringVar(&config.Consumer.TLSCert, "tls-cert", "", "path to the TLS certificate, enables https")
+	flag.StringVar(&config.Consumer.TLSKey, "tls-key", "", "path to the TLS private key")
+	flag.StringVar(&config.Consumer.TLSClientCA, "tls-client-ca", "", "path to the CA bundle verifying client certificates, enables mTLS")
+	flag.Var(&config.Consumer.TrustedSubnet, "t", "trusted subnets in CIDR notation, comma separated, empty allows any agent")
+	flag.Var(&config.Consumer.Buckets, "histogram-buckets", "histogram bucket upper bounds for raw observations, comma separated, empty uses the defaults")
+	flag.Var(&config.Consumer.SummaryQuantiles, "summary-quantiles", "summary quantiles reported on reads, comma separated, empty uses 0.5,0.9,0.99")
+	flag.StringVar(&config.Consumer.StatsD, "statsd", "", "StatsD listener URL, udp://:8125 or unixgram:///path/to/statsd.sock, empty disables it")
+	flag.DurationVar(&config.Consumer.StatsDFlush, "statsd-flush-interval", 10*time.Second, "how long StatsD timers and sets are aggregated before they are stored")
+	flag.Var(&config.Consumer.InfluxRules, "influx-integer-rules", "types of the integer fields written to /write, as in net_bytes_*=counter,*=gauge, the first match wins, integers are gauges by default")
+	flag.BoolVar(&config.Consumer.RemoteWrite, "remote-write", false, "accept Prometheus remote write on /api/v1/write")
+	flag.BoolVar(&config.Consumer.InfluxWrite, "influx-write", false, "accept InfluxDB line protocol on /write")
+	flag.StringVar(&config.Consumer.WriteToken, "write-token", "", "token Prometheus and Telegraf send as a bearer token or the basic auth password, required by the write routes with -k or -crypto-key unless -t is set")
 	flag.Parse()
 
 	if err = env.Parse(&config); err != nil {
@@ -93,9 +198,32 @@ func NewProducerConfig() (ProducerConfig, error) {
 		return ProducerConfig{}, fmt.Errorf("failed to set default value: %w", err)
 	}
 
+	err = config.Producer.Collectors.Set("runtime")
+	if err != nil {
+		return ProducerConfig{}, fmt.Errorf("failed to set default value: %w", err)
+	}
+
 	flag.Var(&config.Producer.Address, "a", "Server address host:port")
 	flag.IntVar(&config.Producer.PollInterval, "p", 2, "Polling interval in seconds")
 	flag.IntVar(&config.Producer.ReportInterval, "r", 10, "Reporting interval in seconds")
+	flag.IntVar(&config.Producer.RateLimit, "l", 1, "Reports sent at the same time at most")
+	flag.IntVar(&config.Producer.RetryCount, "retry-count", 3, "Retries of a failed report, 0 disables retrying")
+	flag.DurationVar(&config.Producer.RetryDelay, "retry-delay", time.Second, "Delay before the first retry, doubled for every next one")
+	flag.DurationVar(&config.Producer.RetryMaxDelay, "retry-max-delay", 30*time.Second, "Maximum delay between retries")
+	flag.StringVar(&config.Producer.SpoolDir, "spool-dir", "", "Directory for undelivered reports, empty disables spooling")
+	flag.IntVar(&config.Producer.SpoolLimit, "spool-limit", 1000, "Maximum number of spooled reports, the oldest are merged into the next ones")
+	flag.StringVar(&config.Producer.Key, "k", "", "Key for HMAC-SHA256 signatures, empty disables signing")
+	flag.StringVar(&config.Producer.CryptoKey, "crypto-key", "", "Path to the RSA public key encrypting payloads")
+	flag.BoolVar(&config.Producer.TLS, "tls", false, "Send reports over https")
+	flag.StringVar(&config.Producer.TLSCA, "tls-ca", "", "Path to the CA bundle trusted for the server certificate")
+	flag.StringVar(&config.Producer.TLSCert, "tls-cert", "", "Path to the client TLS certificate for mTLS")
+	flag.StringVar(&config.Producer.TLSKey, "tls-key", "", "Path to the client TLS private key")
+	flag.Var(&config.Producer.Collectors, "collectors", "Collectors to run, comma separated, each with an optional interval, as in runtime,host=5s")
+	flag.StringVar(&config.Producer.ProcRoot, "proc-root", "/proc", "Mount point of the proc filesystem read by the host and process collectors, as /host/proc in a container")
+	flag.Var(&config.Producer.ProcessPIDs, "process-pids", "Process ids watched by the process collector, comma separated")
+	flag.Var(&config.Producer.ProcessPIDFiles, "process-pidfiles", "Pidfiles of the processes watched by the process collector, comma separated")
+	flag.StringVar(&config.Producer.ProcessPattern, "process-pattern", "", "Regexp of the command lines of the processes watched by the process collector, as nginx|postgres")
+	flag.StringVar(&config.Producer.CgroupRoot, "cgroup-root", "/sys/fs/cgroup", "Mount point of the cgroup v2 hierarchy read by the cgroup collector")
 
 	flag.Parse()
 
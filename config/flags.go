@@ -3,11 +3,22 @@ package config
 import (
 	"errors"
 	"fmt"
+	"math"
+	"net/netip"
+	"path"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 )
 
 var (
-	ErrInvalidAddress = errors.New("invalid address")
+	ErrInvalidAddress   = errors.New("invalid address")
+	ErrInvalidBuckets   = errors.New("invalid buckets")
+	ErrInvalidQuantiles = errors.New("invalid quantiles")
+	ErrInvalidRules     = errors.New("invalid integer rules")
+	ErrInvalidCollector = errors.New("invalid collector")
+	ErrInvalidPID       = errors.New("invalid pid")
 )
 
 type Value interface {
@@ -32,3 +43,320 @@ func (a *Address) Set(flagValue string) error {
 
 	return nil
 }
+
+func (s *Subnets) String() string {
+	subnets := make([]string, 0, len(*s))
+
+	for _, subnet := range *s {
+		subnets = append(subnets, subnet.String())
+	}
+
+	return strings.Join(subnets, ",")
+}
+
+// Set parses a comma separated list of CIDRs.
+func (s *Subnets) Set(flagValue string) error {
+	subnets := Subnets{}
+
+	for _, value := range strings.Split(flagValue, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		subnet, err := netip.ParsePrefix(value)
+		if err != nil {
+			return fmt.Errorf("parsing subnet error - %s: %w", value, err)
+		}
+
+		subnets = append(subnets, subnet.Masked())
+	}
+
+	*s = subnets
+
+	return nil
+}
+
+func (s *Subnets) UnmarshalText(text []byte) error {
+	return s.Set(string(text))
+}
+
+func (s Subnets) Contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	for _, subnet := range s {
+		if subnet.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (b *Buckets) String() string {
+	bounds := make([]string, 0, len(*b))
+
+	for _, bound := range *b {
+		bounds = append(bounds, strconv.FormatFloat(bound, 'g', -1, 64))
+	}
+
+	return strings.Join(bounds, ",")
+}
+
+// Set parses a comma separated list of increasing bucket upper bounds.
+func (b *Buckets) Set(flagValue string) error {
+	buckets := Buckets{}
+
+	for _, value := range strings.Split(flagValue, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		bound, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("parsing bucket error - %s: %w", value, err)
+		}
+
+		if math.IsNaN(bound) || math.IsInf(bound, 0) || (len(buckets) != 0 && bound <= buckets[len(buckets)-1]) {
+			return fmt.Errorf("parsing bucket error - %s: %w", value, ErrInvalidBuckets)
+		}
+
+		buckets = append(buckets, bound)
+	}
+
+	*b = buckets
+
+	return nil
+}
+
+func (b *Buckets) UnmarshalText(text []byte) error {
+	return b.Set(string(text))
+}
+
+func (q *Quantiles) String() string {
+	quantiles := make([]string, 0, len(*q))
+
+	for _, quantile := range *q {
+		quantiles = append(quantiles, strconv.FormatFloat(quantile, 'g', -1, 64))
+	}
+
+	return strings.Join(quantiles, ",")
+}
+
+// Set parses a comma separated list of increasing quantiles between 0 and 1.
+func (q *Quantiles) Set(flagValue string) error {
+	quantiles := Quantiles{}
+
+	for _, value := range strings.Split(flagValue, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		quantile, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("parsing quantile error - %s: %w", value, err)
+		}
+
+		if !(quantile >= 0 && quantile <= 1) || (len(quantiles) != 0 && quantile <= quantiles[len(quantiles)-1]) {
+			return fmt.Errorf("parsing quantile error - %s: %w", value, ErrInvalidQuantiles)
+		}
+
+		quantiles = append(quantiles, quantile)
+	}
+
+	*q = quantiles
+
+	return nil
+}
+
+func (q *Quantiles) UnmarshalText(text []byte) error {
+	return q.Set(string(text))
+}
+
+func (r *IntegerRules) String() string {
+	rules := make([]string, 0, len(*r))
+
+	for _, rule := range *r {
+		rules = append(rules, rule.Pattern+"="+rule.MetricType)
+	}
+
+	return strings.Join(rules, ",")
+}
+
+// Set parses a comma separated list of pattern=type rules, the type is counter or gauge
+// and the pattern is matched as by path.Match.
+func (r *IntegerRules) Set(flagValue string) error {
+	rules := IntegerRules{}
+
+	for _, value := range strings.Split(flagValue, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		pattern, metricType, found := strings.Cut(value, "=")
+		if !found || (metricType != "counter" && metricType != "gauge") {
+			return fmt.Errorf("parsing rule error - %s: %w", value, ErrInvalidRules)
+		}
+
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("parsing rule error - %s: %w", value, errors.Join(ErrInvalidRules, err))
+		}
+
+		rules = append(rules, IntegerRule{Pattern: pattern, MetricType: metricType})
+	}
+
+	*r = rules
+
+	return nil
+}
+
+func (r *IntegerRules) UnmarshalText(text []byte) error {
+	return r.Set(string(text))
+}
+
+func (p *PIDs) String() string {
+	pids := make([]string, 0, len(*p))
+
+	for _, pid := range *p {
+		pids = append(pids, strconv.Itoa(pid))
+	}
+
+	return strings.Join(pids, ",")
+}
+
+// Set parses a comma separated list of process ids.
+func (p *PIDs) Set(flagValue string) error {
+	pids := PIDs{}
+
+	for _, value := range strings.Split(flagValue, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		pid, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("parsing pid error - %s: %w", value, err)
+		}
+
+		if pid <= 0 {
+			return fmt.Errorf("parsing pid error - %s: %w", value, ErrInvalidPID)
+		}
+
+		pids = append(pids, pid)
+	}
+
+	*p = pids
+
+	return nil
+}
+
+func (p *PIDs) UnmarshalText(text []byte) error {
+	return p.Set(string(text))
+}
+
+func (p *Paths) String() string {
+	return strings.Join(*p, ",")
+}
+
+// Set parses a comma separated list of paths.
+func (p *Paths) Set(flagValue string) error {
+	paths := Paths{}
+
+	for _, value := range strings.Split(flagValue, ",") {
+		value = strings.TrimSpace(value)
+		if value != "" {
+			paths = append(paths, value)
+		}
+	}
+
+	*p = paths
+
+	return nil
+}
+
+func (p *Paths) UnmarshalText(text []byte) error {
+	return p.Set(string(text))
+}
+
+func (c *Collectors) String() string {
+	collectors := make([]string, 0, len(*c))
+
+	for _, collector := range *c {
+		if collector.Interval == 0 {
+			collectors = append(collectors, collector.Name)
+
+			continue
+		}
+
+		collectors = append(collectors, collector.Name+"="+collector.Interval.String())
+	}
+
+	return strings.Join(collectors, ",")
+}
+
+// Set parses a comma separated list of collector names, each with an optional interval, as in host=5s.
+func (c *Collectors) Set(flagValue string) error {
+	collectors := Collectors{}
+
+	for _, value := range strings.Split(flagValue, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		name, interval, found := strings.Cut(value, "=")
+
+		collector := CollectorSchedule{Name: name, Interval: 0}
+
+		if found {
+			duration, err := time.ParseDuration(interval)
+			if err != nil {
+				return fmt.Errorf("parsing collector error - %s: %w", value, errors.Join(ErrInvalidCollector, err))
+			}
+
+			if duration <= 0 {
+				return fmt.Errorf("parsing collector error - %s: %w", value, ErrInvalidCollector)
+			}
+
+			collector.Interval = duration
+		}
+
+		if name == "" || slices.ContainsFunc(collectors, func(other CollectorSchedule) bool { return other.Name == name }) {
+			return fmt.Errorf("parsing collector error - %s: %w", value, ErrInvalidCollector)
+		}
+
+		collectors = append(collectors, collector)
+	}
+
+	*c = collectors
+
+	return nil
+}
+
+func (c *Collectors) UnmarshalText(text []byte) error {
+	return c.Set(string(text))
+}
+
+// StorageURL splits the storage option, as in sqlite:///var/lib/metrics.db, into the scheme and the path.
+func (s Store) StorageURL() (string, string) {
+	scheme, path, found := strings.Cut(s.Storage, "://")
+	if !found {
+		return "", ""
+	}
+
+	return scheme, path
+}
+
+// StatsDURL splits the StatsD option, as in udp://:8125, into the network and the address.
+func (c Consumer) StatsDURL() (string, string) {
+	network, address, found := strings.Cut(c.StatsD, "://")
+	if !found {
+		return "", ""
+	}
+
+	return network, address
+}
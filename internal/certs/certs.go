@@ -0,0 +1,142 @@
+// Package certs loads TLS material for the agent and the server.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"metrics/internal/log"
+)
+
+// reloadCheckInterval limits how often the files are stat'ed during handshakes.
+const reloadCheckInterval = time.Second
+
+var ErrNoCertificates = errors.New("no certificates found")
+
+// Reloader holds a certificate pair and reloads it when the files change,
+// so certificates can be rotated without a restart. A broken pair is ignored
+// and the previous one is kept in service.
+type Reloader struct {
+	certPath  string
+	keyPath   string
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+func NewReloader(certPath, keyPath string) (*Reloader, error) {
+	reloader := &Reloader{
+		certPath:  certPath,
+		keyPath:   keyPath,
+		mu:        sync.Mutex{},
+		cert:      nil,
+		modTime:   time.Time{},
+		checkedAt: time.Time{},
+	}
+
+	modTime, err := reloader.lastModified()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = reloader.load(modTime); err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+func (r *Reloader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.certificate(), nil
+}
+
+func (r *Reloader) GetClientCertificate(_ *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.certificate(), nil
+}
+
+func (r *Reloader) certificate() *tls.Certificate {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.checkedAt) < reloadCheckInterval {
+		return r.cert
+	}
+
+	r.checkedAt = now
+
+	modTime, err := r.lastModified()
+	if err != nil {
+		log.Error("stat certificate",
+			log.ErrAttr(err))
+
+		return r.cert
+	}
+
+	if modTime.Equal(r.modTime) {
+		return r.cert
+	}
+
+	if err = r.load(modTime); err != nil {
+		log.Error("reload certificate, keeping the previous one",
+			log.ErrAttr(err))
+
+		return r.cert
+	}
+
+	log.Info("certificate reloaded",
+		log.StringAttr("cert", r.certPath))
+
+	return r.cert
+}
+
+func (r *Reloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+	if err != nil {
+		return fmt.Errorf("load key pair %s, %s: %w", r.certPath, r.keyPath, err)
+	}
+
+	r.cert = &cert
+	r.modTime = modTime
+
+	return nil
+}
+
+func (r *Reloader) lastModified() (time.Time, error) {
+	certInfo, err := os.Stat(r.certPath)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("stat %s: %w", r.certPath, err)
+	}
+
+	keyInfo, err := os.Stat(r.keyPath)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("stat %s: %w", r.keyPath, err)
+	}
+
+	if keyInfo.ModTime().After(certInfo.ModTime()) {
+		return keyInfo.ModTime(), nil
+	}
+
+	return certInfo.ModTime(), nil
+}
+
+// LoadCertPool reads a PEM bundle of CA certificates.
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read ca bundle %s: %w", path, err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("ca bundle %s: %w", path, ErrNoCertificates)
+	}
+
+	return pool, nil
+}
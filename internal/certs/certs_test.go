@@ -0,0 +1,68 @@
+package certs_test
+
+import (
+	"crypto/x509"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"metrics/internal/certs"
+	"metrics/internal/certs/certstest"
+)
+
+func TestReloader(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	ca := certstest.NewCA(t, dir)
+	certPath, keyPath := ca.Issue(t, dir, "server")
+
+	reloader, err := certs.NewReloader(certPath, keyPath)
+	require.NoError(t, err)
+
+	first, err := reloader.GetCertificate(nil)
+	require.NoError(t, err)
+
+	rotatedDir := t.TempDir()
+	rotatedCert, rotatedKey := ca.Issue(t, rotatedDir, "server")
+
+	for from, to := range map[string]string{rotatedCert: certPath, rotatedKey: keyPath} {
+		data, err := os.ReadFile(from)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(to, data, 0o600))
+
+		future := time.Now().Add(time.Minute)
+		require.NoError(t, os.Chtimes(to, future, future))
+	}
+
+	assert.Eventually(t, func() bool {
+		current, err := reloader.GetCertificate(nil)
+
+		return err == nil && current != first
+	}, 3*time.Second, 100*time.Millisecond)
+
+	current, err := reloader.GetCertificate(nil)
+	require.NoError(t, err)
+
+	leaf, err := x509.ParseCertificate(current.Certificate[0])
+	require.NoError(t, err)
+	assert.Equal(t, "server", leaf.Subject.CommonName)
+}
+
+func TestLoadCertPool(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	ca := certstest.NewCA(t, dir)
+
+	_, err := certs.LoadCertPool(ca.CertPath)
+	require.NoError(t, err)
+
+	_, keyPath := ca.Issue(t, dir, "client")
+
+	_, err = certs.LoadCertPool(keyPath)
+	require.ErrorIs(t, err, certs.ErrNoCertificates)
+}
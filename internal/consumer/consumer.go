@@ -18,11 +18,16 @@ const (
 )
 
 func RunServer(ctx context.Context, handler Handler, cfg config.ConsumerConfig) error {
+	tlsConfig, err := newTLSConfig(cfg.Consumer)
+	if err != nil {
+		return fmt.Errorf("tls config: %w", err)
+	}
+
 	server := &http.Server{
 		Addr:                         string(cfg.Consumer.Address),
 		Handler:                      handler.InitRoutes(),
 		DisableGeneralOptionsHandler: false,
-		TLSConfig:                    nil,
+		TLSConfig:                    tlsConfig,
 		ReadTimeout:                  ReadTimeout,
 		ReadHeaderTimeout:            0,
 		WriteTimeout:                 WriteTimeout,
@@ -45,13 +50,21 @@ func RunServer(ctx context.Context, handler Handler, cfg config.ConsumerConfig)
 	}()
 
 	log.Info("server starting", //nolint:contextcheck // no ctx
-		log.StringAttr("host:port", string(cfg.Consumer.Address)))
+		log.StringAttr("host:port", string(cfg.Consumer.Address)),
+		log.BoolAttr("tls", tlsConfig != nil),
+		log.BoolAttr("mtls", cfg.Consumer.TLSClientCA != ""))
+
+	if tlsConfig != nil {
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
 
-	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+	if !errors.Is(err, http.ErrServerClosed) {
 		return fmt.Errorf("server error: %w", err)
 	}
 
-	err := server.Close()
+	err = server.Close()
 	if err != nil {
 		return fmt.Errorf("could not close server: %w", err)
 	}
@@ -0,0 +1,71 @@
+package consumer
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"io"
+	"net/http"
+
+	"metrics/internal/hybrid"
+	"metrics/internal/log"
+)
+
+// WithDecrypt opens hybrid encrypted request bodies. It must run before WithGzipCompress,
+// as the agent compresses the payload before encrypting it. When a key is set,
+// POST requests with a plain body are rejected. A nil key disables the middleware.
+func WithDecrypt(key *rsa.PrivateKey) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if key == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get(hybrid.Header) == "" {
+				if r.Method == http.MethodPost && r.Body != http.NoBody && r.ContentLength != 0 {
+					log.Debug("plain request body rejected", //nolint:contextcheck // no ctx
+						log.StringAttr("uri", r.RequestURI))
+
+					http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+					return
+				}
+
+				next.ServeHTTP(w, r)
+
+				return
+			}
+
+			if r.Header.Get(hybrid.Header) != hybrid.Scheme {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+				return
+			}
+
+			envelope, err := io.ReadAll(r.Body)
+			if err != nil {
+				log.Error("Failed to read request body", //nolint:contextcheck // no ctx
+					log.ErrAttr(err))
+
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+				return
+			}
+
+			body, err := hybrid.Decrypt(key, envelope)
+			if err != nil {
+				log.Debug("Failed to decrypt request body", //nolint:contextcheck // no ctx
+					log.ErrAttr(err))
+
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+				return
+			}
+
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.ContentLength = int64(len(body))
+			r.Header.Del(hybrid.Header)
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package consumer
+
+import (
+	"mime"
+	"slices"
+	"strconv"
+	"strings"
+
+	"metrics/internal/consumer/internal/service"
+	"metrics/internal/log"
+)
+
+const (
+	contentTypePrometheus  = "text/plain; version=0.0.4; charset=utf-8"
+	contentTypeOpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+	mediaTypeOpenMetrics = "application/openmetrics-text"
+)
+
+// family is the metrics sharing a name and a type, exposed under a single # TYPE line.
+type family struct {
+	name       string
+	metricType string
+	metrics    []service.Metric
+}
+
+// acceptsOpenMetrics reports whether the Accept header prefers OpenMetrics to the Prometheus text format,
+// as Prometheus asks with application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5.
+func acceptsOpenMetrics(accept string) bool {
+	qualityOpenMetrics, qualityText := 0.0, 0.0
+
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+
+		quality := 1.0
+		if q, errParse := strconv.ParseFloat(params["q"], 64); errParse == nil {
+			quality = q
+		}
+
+		switch mediaType {
+		case mediaTypeOpenMetrics:
+			qualityOpenMetrics = max(qualityOpenMetrics, quality)
+		case "text/plain", "text/*", "*/*":
+			qualityText = max(qualityText, quality)
+		}
+	}
+
+	return qualityOpenMetrics > 0 && qualityOpenMetrics >= qualityText
+}
+
+// appendExposition renders the metrics in the Prometheus text format or in OpenMetrics.
+// Names are sanitized, and counters get the _total suffix. A metric whose sanitized name is taken
+// by a metric of another type is left out, as one name has a single type.
+func appendExposition(out []byte, metrics []service.Metric, openMetrics bool) []byte {
+	for _, f := range groupFamilies(metrics) {
+		name := f.name
+
+		// OpenMetrics types the family and suffixes the samples, the text format types the samples
+		if f.metricType == service.MetricCounter && !openMetrics {
+			name += "_total"
+		}
+
+		out = append(out, "# TYPE "+name+" "+f.metricType+"\n"...)
+
+		for _, metric := range f.metrics {
+			out = appendSamples(out, f.name, metric)
+		}
+	}
+
+	if openMetrics {
+		out = append(out, "# EOF\n"...)
+	}
+
+	return out
+}
+
+func groupFamilies(metrics []service.Metric) []family {
+	slices.SortFunc(metrics, func(a, b service.Metric) int {
+		return strings.Compare(a.Key(), b.Key())
+	})
+
+	families := map[string]*family{}
+
+	for _, metric := range metrics {
+		name := sanitizeName(metric.ID)
+		if metric.MetricType == service.MetricCounter {
+			name = strings.TrimSuffix(name, "_total")
+		}
+
+		f, ok := families[name]
+		if !ok {
+			f = &family{name: name, metricType: metric.MetricType, metrics: nil}
+			families[name] = f
+		}
+
+		if f.metricType != metric.MetricType {
+			log.Debug("metric skipped in exposition",
+				log.StringAttr("name", metric.Key()),
+				log.StringAttr("type", metric.MetricType),
+				log.StringAttr("family type", f.metricType))
+
+			continue
+		}
+
+		f.metrics = append(f.metrics, metric)
+	}
+
+	sorted := make([]family, 0, len(families))
+	for _, f := range families {
+		sorted = append(sorted, *f)
+	}
+
+	slices.SortFunc(sorted, func(a, b family) int {
+		return strings.Compare(a.name, b.name)
+	})
+
+	return sorted
+}
+
+func appendSamples(out []byte, name string, metric service.Metric) []byte {
+	switch metric.MetricType {
+	case service.MetricGauge:
+		out = appendSample(out, name, metric.Labels, "", "", formatFloat(*metric.Value))
+	case service.MetricCounter:
+		out = appendSample(out, name+"_total", metric.Labels, "", "", strconv.FormatInt(*metric.Delta, 10))
+	case service.MetricHistogram:
+		histogram := metric.Histogram
+
+		var cumulative uint64
+
+		for i, bound := range histogram.Bounds {
+			cumulative += histogram.Counts[i]
+			out = appendSample(out, name+"_bucket", metric.Labels, "le", formatFloat(bound), strconv.FormatUint(cumulative, 10))
+		}
+
+		out = appendSample(out, name+"_bucket", metric.Labels, "le", "+Inf", strconv.FormatUint(histogram.Count, 10))
+		out = appendSample(out, name+"_sum", metric.Labels, "", "", formatFloat(histogram.Sum))
+		out = appendSample(out, name+"_count", metric.Labels, "", "", strconv.FormatUint(histogram.Count, 10))
+	case service.MetricSummary:
+		summary := metric.Summary
+
+		quantiles := make([]float64, 0, len(summary.Quantiles))
+
+		for quantile := range summary.Quantiles {
+			if q, err := strconv.ParseFloat(quantile, 64); err == nil {
+				quantiles = append(quantiles, q)
+			}
+		}
+
+		slices.Sort(quantiles)
+
+		for _, q := range quantiles {
+			value := summary.Quantiles[strconv.FormatFloat(q, 'f', -1, 64)]
+			out = appendSample(out, name, metric.Labels, "quantile", formatFloat(q), formatFloat(value))
+		}
+
+		out = appendSample(out, name+"_sum", metric.Labels, "", "", formatFloat(summary.Sum))
+		out = appendSample(out, name+"_count", metric.Labels, "", "", strconv.FormatUint(summary.Count, 10))
+	}
+
+	return out
+}
+
+// appendSample adds a sample line with the labels sorted by name and the extra label, as le or quantile, last.
+func appendSample(out []byte, name string, labels service.Labels, extraName, extraValue, value string) []byte {
+	out = append(out, name...)
+
+	names := make([]string, 0, len(labels))
+	for labelName := range labels {
+		names = append(names, labelName)
+	}
+
+	slices.Sort(names)
+
+	if len(names) != 0 || extraName != "" {
+		out = append(out, '{')
+
+		for i, labelName := range names {
+			if i != 0 {
+				out = append(out, ',')
+			}
+
+			out = append(out, labelName+`="`+escapeLabelValue(labels[labelName])+`"`...)
+		}
+
+		if extraName != "" {
+			if len(names) != 0 {
+				out = append(out, ',')
+			}
+
+			out = append(out, extraName+`="`+extraValue+`"`...)
+		}
+
+		out = append(out, '}')
+	}
+
+	return append(out, " "+value+"\n"...)
+}
+
+// sanitizeName replaces the characters a Prometheus metric name cannot have with underscores,
+// as in go.gc-pause to go_gc_pause, and prefixes a name starting with a digit.
+func sanitizeName(name string) string {
+	sanitized := make([]byte, 0, len(name)+1)
+
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == ':':
+			sanitized = append(sanitized, byte(r))
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				sanitized = append(sanitized, '_')
+			}
+
+			sanitized = append(sanitized, byte(r))
+		default:
+			sanitized = append(sanitized, '_')
+		}
+	}
+
+	if len(sanitized) == 0 {
+		return "_"
+	}
+
+	return string(sanitized)
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`) //nolint:gochecknoglobals // read only
+
+func escapeLabelValue(value string) string {
+	return labelValueReplacer.Replace(value)
+}
+
+// formatFloat writes +Inf, -Inf and NaN as the exposition formats spell them.
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -1,7 +1,10 @@
 package consumer
 
 import (
+	"bytes"
+	"crypto/rsa"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
@@ -11,29 +14,100 @@ import (
 
 	"github.com/go-playground/validator/v10"
 
+	"metrics/config"
 	"metrics/internal/consumer/internal/mux"
 	"metrics/internal/consumer/internal/service"
+	"metrics/internal/hybrid"
 	"metrics/internal/log"
 )
 
-type Handler struct {
-	service service.Consumer
+const (
+	updateStatusOK      = "ok"
+	updateStatusInvalid = "invalid"
+	updateStatusSkipped = "skipped"
+)
+
+// ErrUnprotectedWrite is returned for a write route that would let anyone around the agent keys.
+var ErrUnprotectedWrite = errors.New("write route needs a write token or a trusted subnet with the agent keys")
+
+type (
+	Handler struct {
+		service    service.Consumer
+		config     config.ConsumerConfig
+		privateKey *rsa.PrivateKey
+	}
+
+	// UpdateResult is the per-item answer of the batch update route.
+	UpdateResult struct {
+		service.Metric
+		Status string `json:"status"`
+		Error  string `json:"error,omitempty"`
+	}
+)
+
+func NewHandler(service service.Consumer, config config.ConsumerConfig) (Handler, error) {
+	handler := Handler{service: service, config: config, privateKey: nil}
+
+	if config.Consumer.RemoteWrite && !writeProtected(config.Consumer) {
+		return Handler{}, fmt.Errorf("remote write: %w", ErrUnprotectedWrite)
+	}
+
+	if config.Consumer.InfluxWrite && !writeProtected(config.Consumer) {
+		return Handler{}, fmt.Errorf("influx write: %w", ErrUnprotectedWrite)
+	}
+
+	if config.Consumer.CryptoKey != "" {
+		privateKey, err := hybrid.LoadPrivateKey(config.Consumer.CryptoKey)
+		if err != nil {
+			return Handler{}, fmt.Errorf("load private key: %w", err)
+		}
+
+		handler.privateKey = privateKey
+	}
+
+	return handler, nil
 }
 
-func NewHandler(service service.Consumer) Handler {
-	return Handler{service: service}
+// writeProtected tells whether the write routes, which skip the agent keys, are guarded as well as the agent routes.
+func writeProtected(consumer config.Consumer) bool {
+	keyed := consumer.Key != "" || consumer.CryptoKey != ""
+
+	return !keyed || consumer.WriteToken != "" || len(consumer.TrustedSubnet) > 0
 }
 
 func (h Handler) InitRoutes() http.Handler {
 	router := mux.NewRouter()
 
 	router.Use(WithLogging)
+
+	trusted := WithTrustedSubnet(h.config.Consumer.TrustedSubnet)
+
+	// Prometheus and Telegraf can neither sign nor encrypt, their writes are checked by the write token instead
+	router.Group(func(router *mux.Router) {
+		router.Use(WithWriteToken(h.config.Consumer.WriteToken))
+		router.Use(WithGzipCompress)
+
+		if h.config.Consumer.RemoteWrite {
+			router.Post("/api/v1/write", h.RemoteWrite, trusted)
+		}
+
+		if h.config.Consumer.InfluxWrite {
+			router.Post("/write", h.InfluxWrite, trusted)
+		}
+	})
+
+	router.Use(WithDecrypt(h.privateKey))
 	router.Use(WithGzipCompress)
+	router.Use(WithHash(h.config.Consumer.Key))
 
-	router.Post("/update/{$}", h.AddMetricJSON)
-	router.Post("/update/{type}/{id}/{value}", h.AddMetric)
+	router.Post("/update/{$}", h.AddMetricJSON, trusted)
+	router.Post("/update/{type}/{id}/{value}", h.AddMetric, trusted)
+	router.Post("/updates/{$}", h.AddMetricsJSON, trusted)
 	router.Post("/value/{$}", h.GetMetricJSON)
 	router.Get("/value/{type}/{id}", h.GetMetric)
+	router.Get("/range/{type}/{id}", h.GetRange)
+	router.Get("/rollup/{type}/{id}", h.GetRollups)
+	router.Get("/metrics", h.GetExposition)
 	router.Get("/", h.GetAllMetrics)
 
 	router.Post("/", func(w http.ResponseWriter, _ *http.Request) {
@@ -74,21 +148,69 @@ func (h Handler) AddMetricJSON(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err = metric.Labels.Validate(); err != nil {
+		log.Debug("invalid labels", //nolint:contextcheck // false positive
+			log.ErrAttr(err))
+
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
 	switch metric.MetricType {
 	case service.MetricCounter:
-		_, err = h.service.AddCounter(metric.ID, *metric.Delta)
+		_, err = h.service.AddCounter(metric.ID, *metric.Delta, metric.Labels)
 		if err != nil {
 			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 
 			return
 		}
 	case service.MetricGauge:
-		_, err = h.service.AddGauge(metric.ID, *metric.Value)
+		_, err = h.service.AddGauge(metric.ID, *metric.Value, metric.Labels)
 		if err != nil {
 			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 
 			return
 		}
+	case service.MetricHistogram:
+		if metric.Histogram == nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+			return
+		}
+
+		// answer with the merged histogram, the observations are bucketed by now
+		var histogram service.Metric
+		histogram, err = h.service.AddHistogram(metric.ID, *metric.Histogram, metric.Labels)
+		if err != nil {
+			log.Debug("error add histogram", //nolint:contextcheck // false positive
+				log.ErrAttr(err))
+
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+			return
+		}
+
+		metric.Histogram = histogram.Histogram
+	case service.MetricSummary:
+		if metric.Summary == nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+			return
+		}
+
+		var summary service.Metric
+		summary, err = h.service.AddSummary(metric.ID, *metric.Summary, metric.Labels)
+		if err != nil {
+			log.Debug("error add summary", //nolint:contextcheck // false positive
+				log.ErrAttr(err))
+
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+			return
+		}
+
+		metric.Summary = summary.Summary
 	default:
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 
@@ -110,6 +232,111 @@ func (h Handler) AddMetricJSON(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// AddMetricsJSON stores a JSON array of metrics. The batch is applied only if every item is valid,
+// otherwise nothing is stored and the answer marks the invalid items.
+func (h Handler) AddMetricsJSON(w http.ResponseWriter, r *http.Request) { //nolint:funlen // agree
+	var metrics []service.Metric
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Error("error reading request body", //nolint:contextcheck // false positive
+			log.ErrAttr(err))
+
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+		return
+	}
+
+	if len(body) == 0 {
+		log.Debug("empty body") //nolint:contextcheck // false positive
+
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	err = json.Unmarshal(body, &metrics)
+	if err != nil {
+		log.Debug("error decode to json", //nolint:contextcheck // false positive
+			log.ErrAttr(err))
+
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	if len(metrics) == 0 {
+		log.Debug("empty batch") //nolint:contextcheck // false positive
+
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	results := make([]UpdateResult, len(metrics))
+	isValidBatch := true
+
+	for i, metric := range metrics {
+		results[i] = UpdateResult{Metric: metric, Status: updateStatusSkipped, Error: ""}
+
+		if !h.isValidUpdate(metric) {
+			results[i].Status = updateStatusInvalid
+			results[i].Error = "invalid metric"
+			isValidBatch = false
+		}
+	}
+
+	if !isValidBatch {
+		log.Debug("invalid batch", //nolint:contextcheck // false positive
+			log.IntAttr("count", len(metrics)))
+
+		h.writeUpdateResults(w, http.StatusBadRequest, results) //nolint:contextcheck // false positive
+
+		return
+	}
+
+	stored, err := h.service.AddMetrics(metrics)
+	if errors.Is(err, service.ErrInvalidHistogram) || errors.Is(err, service.ErrBucketsMismatch) || errors.Is(err, service.ErrInvalidSummary) {
+		log.Debug("invalid histogram or summary in batch", //nolint:contextcheck // false positive
+			log.ErrAttr(err))
+
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	if err != nil {
+		log.Error("error add metrics", //nolint:contextcheck // false positive
+			log.ErrAttr(err))
+
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+		return
+	}
+
+	for i, metric := range stored {
+		results[i] = UpdateResult{Metric: metric, Status: updateStatusOK, Error: ""}
+	}
+
+	h.writeUpdateResults(w, http.StatusOK, results) //nolint:contextcheck // false positive
+}
+
+func (Handler) writeUpdateResults(w http.ResponseWriter, statusCode int, results []UpdateResult) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if statusCode == http.StatusOK {
+		w.Header().Set("Content-Encoding", "gzip") //TODO: костыль
+	}
+
+	w.WriteHeader(statusCode)
+
+	err := json.NewEncoder(w).Encode(results)
+	if err != nil {
+		log.Error("error encode to json",
+			log.ErrAttr(err))
+	}
+}
+
 func (h Handler) AddMetric(w http.ResponseWriter, r *http.Request) {
 	metricType := r.PathValue("type")
 
@@ -127,6 +354,13 @@ func (h Handler) AddMetric(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	labels, err := labelsFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
 	switch metricType {
 	case service.MetricCounter:
 		value, err := strconv.ParseInt(valueString, 10, 64)
@@ -136,7 +370,7 @@ func (h Handler) AddMetric(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		_, err = h.service.AddCounter(id, value)
+		_, err = h.service.AddCounter(id, value, labels)
 		if err != nil {
 			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 
@@ -150,7 +384,37 @@ func (h Handler) AddMetric(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		_, err = h.service.AddGauge(id, value)
+		_, err = h.service.AddGauge(id, value, labels)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+			return
+		}
+	case service.MetricHistogram:
+		// the value is a single observation, bucketed by the configured bounds
+		value, err := strconv.ParseFloat(valueString, 64)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+			return
+		}
+
+		_, err = h.service.AddHistogram(id, service.Histogram{Observations: []float64{value}}, labels) //nolint:exhaustruct // observations only
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+			return
+		}
+	case service.MetricSummary:
+		// the value is a single observation, as for a histogram
+		value, err := strconv.ParseFloat(valueString, 64)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+			return
+		}
+
+		_, err = h.service.AddSummary(id, service.Summary{Observations: []float64{value}}, labels) //nolint:exhaustruct // observations only
 		if err != nil {
 			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 
@@ -176,10 +440,23 @@ func (h Handler) GetMetric(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	matchers, err := matchersFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
 	switch metricType {
 	case service.MetricCounter:
-		counter, err := h.service.GetMetric(id)
+		counter, err := h.service.GetMetric(id, matchers...)
 		if err != nil {
+			writeGetMetricError(w, err)
+
+			return
+		}
+
+		if counter.Delta == nil {
 			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 
 			return
@@ -198,8 +475,14 @@ func (h Handler) GetMetric(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	case service.MetricGauge:
-		gauge, err := h.service.GetMetric(id)
+		gauge, err := h.service.GetMetric(id, matchers...)
 		if err != nil {
+			writeGetMetricError(w, err)
+
+			return
+		}
+
+		if gauge.Value == nil {
 			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 
 			return
@@ -218,6 +501,36 @@ func (h Handler) GetMetric(w http.ResponseWriter, r *http.Request) {
 
 			return
 		}
+	case service.MetricHistogram:
+		histogram, err := h.service.GetMetric(id, matchers...)
+		if err != nil {
+			writeGetMetricError(w, err)
+
+			return
+		}
+
+		if histogram.Histogram == nil {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+
+			return
+		}
+
+		writeJSON(w, r, histogram.Histogram)
+	case service.MetricSummary:
+		summary, err := h.service.GetMetric(id, matchers...)
+		if err != nil {
+			writeGetMetricError(w, err)
+
+			return
+		}
+
+		if summary.Summary == nil {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+
+			return
+		}
+
+		writeJSON(w, r, summary.Summary)
 	default:
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 
@@ -276,9 +589,9 @@ func (h Handler) GetMetricJSON(w http.ResponseWriter, r *http.Request) { //nolin
 	switch metric.MetricType {
 	case service.MetricCounter:
 		var counter service.Metric
-		counter, err = h.service.GetMetric(metric.ID)
+		counter, err = h.service.GetMetric(metric.ID, matchersFromLabels(metric.Labels)...)
 		if err != nil {
-			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			writeGetMetricError(w, err) //nolint:contextcheck // false positive
 
 			return
 		}
@@ -300,9 +613,9 @@ func (h Handler) GetMetricJSON(w http.ResponseWriter, r *http.Request) { //nolin
 		}
 	case service.MetricGauge:
 		var gauge service.Metric
-		gauge, err = h.service.GetMetric(metric.ID)
+		gauge, err = h.service.GetMetric(metric.ID, matchersFromLabels(metric.Labels)...)
 		if err != nil {
-			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			writeGetMetricError(w, err) //nolint:contextcheck // false positive
 
 			return
 		}
@@ -322,6 +635,42 @@ func (h Handler) GetMetricJSON(w http.ResponseWriter, r *http.Request) { //nolin
 
 			return
 		}
+	case service.MetricHistogram:
+		var histogram service.Metric
+		histogram, err = h.service.GetMetric(metric.ID, matchersFromLabels(metric.Labels)...)
+		if err != nil {
+			writeGetMetricError(w, err) //nolint:contextcheck // false positive
+
+			return
+		}
+
+		if histogram.Histogram == nil {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+
+			return
+		}
+
+		metric.Histogram = histogram.Histogram
+
+		writeJSON(w, r, metric) //nolint:contextcheck // false positive
+	case service.MetricSummary:
+		var summary service.Metric
+		summary, err = h.service.GetMetric(metric.ID, matchersFromLabels(metric.Labels)...)
+		if err != nil {
+			writeGetMetricError(w, err) //nolint:contextcheck // false positive
+
+			return
+		}
+
+		if summary.Summary == nil {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+
+			return
+		}
+
+		metric.Summary = summary.Summary
+
+		writeJSON(w, r, metric) //nolint:contextcheck // false positive
 	default:
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 
@@ -329,8 +678,61 @@ func (h Handler) GetMetricJSON(w http.ResponseWriter, r *http.Request) { //nolin
 	}
 }
 
-func (h Handler) GetAllMetrics(w http.ResponseWriter, _ *http.Request) {
-	metrics := h.service.GetAllMetrics()
+// GetRange returns the recorded points of a gauge or a counter as JSON, see service.Consumer.GetRange.
+func (h Handler) GetRange(w http.ResponseWriter, r *http.Request) {
+	metricType := r.PathValue("type")
+
+	id := r.PathValue("id")
+	if id == "" {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	from, to, step, query, err := rangeFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	matchers, err := matchersFromQuery(query)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	points, err := h.service.GetRange(metricType, id, from, to, step, matchers...)
+
+	switch {
+	case errors.Is(err, service.ErrHistoryDisabled):
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+
+		return
+	case errors.Is(err, service.ErrInvalidRange), errors.Is(err, service.ErrUnknownMetricType):
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	case err != nil:
+		writeGetMetricError(w, err)
+
+		return
+	}
+
+	writeJSON(w, r, points)
+}
+
+// GetAllMetrics lists the metrics, filtered by the label matchers in the query as in GetMetric.
+func (h Handler) GetAllMetrics(w http.ResponseWriter, r *http.Request) {
+	matchers, err := matchersFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	metrics := h.service.GetAllMetrics(matchers...)
 	if len(metrics) == 0 {
 		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 
@@ -385,14 +787,20 @@ func (Handler) prepareAllMetrics(metrics []service.Metric) (string, error) {
 	var answer strings.Builder
 
 	for _, metric := range metrics {
-		_, _ = answer.WriteString(metric.ID) // always returns nil error
-		_, _ = answer.WriteString(" ")       // always returns nil error
+		_, _ = answer.WriteString(metric.Key()) // always returns nil error
+		_, _ = answer.WriteString(" ")          // always returns nil error
 
 		switch metric.MetricType {
 		case service.MetricCounter:
 			_, _ = answer.WriteString(strconv.FormatInt(*metric.Delta, 10)) // always returns nil error
 		case service.MetricGauge:
 			_, _ = answer.WriteString(strconv.FormatFloat(*metric.Value, 'f', -1, 64)) // always returns nil error
+		case service.MetricHistogram:
+			_, _ = fmt.Fprintf(&answer, "count=%d sum=%s", metric.Histogram.Count, // always returns nil error
+				strconv.FormatFloat(metric.Histogram.Sum, 'f', -1, 64))
+		case service.MetricSummary:
+			_, _ = fmt.Fprintf(&answer, "count=%d sum=%s", metric.Summary.Count, // always returns nil error
+				strconv.FormatFloat(metric.Summary.Sum, 'f', -1, 64))
 		default:
 			return "", service.ErrUnknownMetricType
 		}
@@ -403,10 +811,277 @@ func (Handler) prepareAllMetrics(metrics []service.Metric) (string, error) {
 	return answer.String(), nil
 }
 
+func (h Handler) isValidUpdate(metric service.Metric) bool {
+	if !h.IsValidRequest(metric) {
+		return false
+	}
+
+	switch metric.MetricType {
+	case service.MetricCounter:
+		return metric.Delta != nil
+	case service.MetricGauge:
+		return metric.Value != nil
+	case service.MetricHistogram:
+		return metric.Histogram != nil
+	case service.MetricSummary:
+		return metric.Summary != nil
+	default:
+		return false
+	}
+}
+
 func (Handler) IsValidRequest(metric service.Metric) bool {
 	validate := validator.New(validator.WithRequiredStructEnabled())
 
-	err := validate.Struct(metric)
+	if err := validate.Struct(metric); err != nil {
+		return false
+	}
+
+	return metric.Labels.Validate() == nil
+}
+
+// writeJSON answers 200 with v as JSON. WithGzipCompress decides on compression after the header is written,
+// so Content-Encoding is set here when it will compress.
+func writeJSON(w http.ResponseWriter, r *http.Request, v any) {
+	var body bytes.Buffer
+
+	if err := json.NewEncoder(&body).Encode(v); err != nil {
+		log.Error("error encode to json", //nolint:contextcheck // no ctx
+			log.ErrAttr(err))
+
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if shouldCompress(r, methodCompressGzip, http.StatusOK, body.Len(), w.Header().Values("Content-Type")) {
+		w.Header().Set("Content-Encoding", "gzip") //TODO: костыль
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := w.Write(body.Bytes()); err != nil {
+		log.Error("error writing response", //nolint:contextcheck // no ctx
+			log.ErrAttr(err))
+	}
+}
+
+// writeGetMetricError answers 400 when the label matchers select several metrics and 404 otherwise.
+func writeGetMetricError(w http.ResponseWriter, err error) {
+	if errors.Is(err, service.ErrAmbiguousMetric) {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+}
+
+// GetRollups returns the rollups of a gauge or a counter as JSON, see service.Consumer.GetRollups.
+// The step parameter is the requested resolution.
+func (h Handler) GetRollups(w http.ResponseWriter, r *http.Request) {
+	metricType := r.PathValue("type")
+
+	id := r.PathValue("id")
+	if id == "" {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	from, to, resolution, query, err := rangeFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	matchers, err := matchersFromQuery(query)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	rollups, err := h.service.GetRollups(metricType, id, from, to, resolution, matchers...)
+
+	switch {
+	case errors.Is(err, service.ErrRollupsDisabled):
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+
+		return
+	case errors.Is(err, service.ErrInvalidRange), errors.Is(err, service.ErrUnknownMetricType):
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	case err != nil:
+		writeGetMetricError(w, err)
+
+		return
+	}
+
+	writeJSON(w, r, rollups)
+}
+
+// GetExposition renders all the metrics, or the ones the label matchers select, for Prometheus to scrape:
+// in OpenMetrics when the Accept header prefers it, otherwise in the Prometheus text format.
+func (h Handler) GetExposition(w http.ResponseWriter, r *http.Request) {
+	matchers, err := matchersFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	openMetrics := acceptsOpenMetrics(r.Header.Get("Accept"))
+	body := appendExposition(nil, h.service.GetAllMetrics(matchers...), openMetrics)
+
+	w.Header().Set("Vary", "Accept")
+
+	if openMetrics {
+		w.Header().Set("Content-Type", contentTypeOpenMetrics)
+
+		// the text format is never compressed, OpenMetrics is
+		if shouldCompress(r, methodCompressGzip, http.StatusOK, len(body), w.Header().Values("Content-Type")) {
+			w.Header().Set("Content-Encoding", "gzip") //TODO: костыль
+		}
+	} else {
+		w.Header().Set("Content-Type", contentTypePrometheus)
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	if _, err = w.Write(body); err != nil {
+		log.Error("error writing response", //nolint:contextcheck // no ctx
+			log.ErrAttr(err))
+	}
+}
+
+// RemoteWrite stores the samples of a Prometheus remote write request, see remoteWriteMetrics.
+// Counters carry running totals and add the difference from the stored ones. As Prometheus expects,
+// the answer is 204 on success, 400 for a request it must not retry and 500 for one it may retry.
+func (h Handler) RemoteWrite(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Error("error reading request", //nolint:contextcheck // false positive
+			log.ErrAttr(err))
 
-	return err == nil
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+		return
+	}
+
+	series, err := decodeWriteRequest(body)
+	if err != nil {
+		log.Debug("error decode remote write", //nolint:contextcheck // false positive
+			log.ErrAttr(err))
+
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	metrics, err := remoteWriteMetrics(series)
+	if err != nil {
+		log.Debug("invalid remote write", //nolint:contextcheck // false positive
+			log.ErrAttr(err))
+
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	if _, err = h.service.AddCumulativeMetrics(metrics); err != nil {
+		log.Error("error adding remote write", //nolint:contextcheck // false positive
+			log.ErrAttr(err))
+
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// InfluxWrite stores the metrics of a body in the InfluxDB line protocol, see influxLineMetrics.
+// A malformed line is skipped and the other lines are stored; the answer is 204 when every line is,
+// otherwise 400 with the result of every line, as InfluxDB answers a partial write.
+func (h Handler) InfluxWrite(w http.ResponseWriter, r *http.Request) {
+	precision := r.URL.Query().Get("precision")
+
+	unit, ok := influxPrecisions[precision]
+	if !ok {
+		log.Debug("invalid precision", //nolint:contextcheck // false positive
+			log.StringAttr("precision", precision))
+
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Error("error reading request", //nolint:contextcheck // false positive
+			log.ErrAttr(err))
+
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+		return
+	}
+
+	var (
+		metrics []service.Metric
+		results []LineResult
+	)
+
+	isValidBatch := true
+
+	for i, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		lineMetrics, errLine := influxLineMetrics(line, unit, h.config.Consumer.InfluxRules)
+		if errLine != nil {
+			results = append(results, LineResult{Line: i + 1, Status: updateStatusInvalid, Error: errLine.Error()})
+			isValidBatch = false
+
+			continue
+		}
+
+		results = append(results, LineResult{Line: i + 1, Status: updateStatusOK, Error: ""})
+		metrics = append(metrics, lineMetrics...)
+	}
+
+	if len(metrics) != 0 {
+		if _, err = h.service.AddCumulativeMetrics(metrics); err != nil {
+			log.Error("error adding line protocol", //nolint:contextcheck // false positive
+				log.ErrAttr(err))
+
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+			return
+		}
+	}
+
+	if isValidBatch {
+		w.WriteHeader(http.StatusNoContent)
+
+		return
+	}
+
+	log.Debug("partial line protocol write", //nolint:contextcheck // false positive
+		log.IntAttr("lines", len(results)),
+		log.IntAttr("metrics", len(metrics)))
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusBadRequest)
+
+	if err = json.NewEncoder(w).Encode(results); err != nil {
+		log.Error("error encode to json", //nolint:contextcheck // false positive
+			log.ErrAttr(err))
+	}
 }
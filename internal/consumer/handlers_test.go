@@ -4,6 +4,7 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -97,7 +98,8 @@ func TestPostAddMetric(t *testing.T) {
 	var cfg config.ConsumerConfig
 	db := store.NewDummyStore()
 	consumerService := service.NewConsumerService(db, cfg)
-	handler := consumer.NewHandler(consumerService)
+	handler, err := consumer.NewHandler(consumerService, cfg)
+	require.NoError(t, err)
 
 	for _, tt := range testCases {
 		t.Run(tt.name, func(t *testing.T) {
@@ -126,3 +128,86 @@ func TestPostAddMetric(t *testing.T) {
 		})
 	}
 }
+
+func TestPostAddMetricsJSON(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	type want struct {
+		code     int
+		response string
+	}
+
+	testCases := []struct {
+		name string
+		body string
+		want want
+	}{
+		{
+			name: "Add empty batch",
+			body: "",
+			want: want{
+				code:     400,
+				response: "Bad Request\n",
+			},
+		},
+		{
+			name: "Add empty array",
+			body: `[]`,
+			want: want{
+				code:     400,
+				response: "Bad Request\n",
+			},
+		},
+		{
+			name: "Add batch with invalid metric",
+			body: `[{"id":"Test","type":"gauge","value":1},{"id":"Test","type":"counter"}]`,
+			want: want{
+				code: 400,
+				response: `[{"id":"Test","type":"gauge","value":1,"status":"skipped"},` +
+					`{"id":"Test","type":"counter","status":"invalid","error":"invalid metric"}]` + "\n",
+			},
+		},
+		{
+			name: "Add valid batch",
+			body: `[{"id":"Gauge","type":"gauge","value":1.5},{"id":"Counter","type":"counter","delta":2},{"id":"Counter","type":"counter","delta":3}]`,
+			want: want{
+				code: 200,
+				response: `[{"id":"Gauge","type":"gauge","value":1.5,"status":"ok"},` +
+					`{"id":"Counter","type":"counter","delta":2,"status":"ok"},` +
+					`{"id":"Counter","type":"counter","delta":5,"status":"ok"}]` + "\n",
+			},
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var cfg config.ConsumerConfig
+			db, err := store.NewMemoryStore(cfg.Store)
+			require.NoError(t, err)
+
+			consumerService := service.NewConsumerService(db, cfg)
+			handler, err := consumer.NewHandler(consumerService, cfg)
+			require.NoError(t, err)
+
+			request := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(tt.body))
+			responseRecorder := httptest.NewRecorder()
+
+			handler.AddMetricsJSON(responseRecorder, request)
+
+			response := responseRecorder.Result()
+
+			assert.Equal(t, tt.want.code, response.StatusCode)
+			responseBody, err := io.ReadAll(response.Body)
+			require.NoError(t, err)
+
+			err = response.Body.Close()
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.want.response, string(responseBody))
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package consumer
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"metrics/internal/log"
+	"metrics/internal/sign"
+)
+
+type hashResponseWriter struct {
+	http.ResponseWriter
+	*responseData
+}
+
+func (h *hashResponseWriter) Write(data []byte) (int, error) {
+	h.body = append(h.body, data...)
+	h.bodySize += len(data)
+
+	return len(data), nil
+}
+
+func (h *hashResponseWriter) WriteHeader(statusCode int) {
+	h.statusCode = statusCode
+}
+
+// WithHash checks the HashSHA256 signature of POST requests and signs every response.
+// It must run after WithGzipCompress, as signatures cover the uncompressed body.
+// An empty key disables the middleware.
+func WithHash(key string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if key == "" {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			interceptor := &hashResponseWriter{
+				ResponseWriter: w,
+				responseData:   new(responseData),
+			}
+
+			if isValidSignature(key, r) { //nolint:contextcheck // no ctx
+				next.ServeHTTP(interceptor, r)
+			} else {
+				http.Error(interceptor, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			}
+
+			if interceptor.statusCode == 0 {
+				interceptor.statusCode = http.StatusOK
+			}
+
+			w.Header().Set(sign.Header, sign.Sign(key, interceptor.body))
+			w.WriteHeader(interceptor.statusCode)
+
+			_, err := w.Write(interceptor.body)
+			if err != nil {
+				log.Error("Error writing response", //nolint:contextcheck // no ctx
+					log.ErrAttr(err))
+			}
+		})
+	}
+}
+
+// isValidSignature checks POST requests only and puts the read body back for the next handlers.
+func isValidSignature(key string, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		return true
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Error("Failed to read request body",
+			log.ErrAttr(err))
+
+		return false
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	if !sign.Verify(key, body, r.Header.Get(sign.Header)) {
+		log.Debug("invalid request signature",
+			log.StringAttr("uri", r.RequestURI))
+
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,249 @@
+package consumer
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"metrics/config"
+	"metrics/internal/consumer/internal/service"
+)
+
+// lineSections are the series, the fields and the timestamp of a line, the timestamp is optional.
+const lineSections = 3
+
+var ErrInvalidLine = errors.New("invalid line protocol")
+
+// influxPrecisions are the units of the timestamp a write may ask for, nanoseconds by default.
+var influxPrecisions = map[string]time.Duration{ //nolint:gochecknoglobals // read only
+	"": time.Nanosecond, "ns": time.Nanosecond, "n": time.Nanosecond, "us": time.Microsecond, "u": time.Microsecond,
+	"ms": time.Millisecond, "s": time.Second, "m": time.Minute, "h": time.Hour,
+}
+
+// LineResult is the per-line answer of the line protocol write route, lines are numbered from 1.
+type LineResult struct {
+	Line   int    `json:"line"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// influxLineMetrics maps a line, as in cpu,host=a usage_idle=92.5,processes=12i 1700000000000000000,
+// onto a metric per field named measurement_field, with the tags as labels. Float and boolean fields
+// are gauges, true being 1; integer fields are counters carrying the running total or gauges,
+// as the rules tell. String fields are skipped. The timestamp, in the unit of the precision,
+// is the Timestamp of every metric; without it the stores keep the time of the update.
+func influxLineMetrics(line string, unit time.Duration, rules config.IntegerRules) ([]service.Metric, error) {
+	sections := splitUnescaped(line, ' ', true)
+	if len(sections) != lineSections-1 && len(sections) != lineSections {
+		return nil, fmt.Errorf("%d sections: %w", len(sections), ErrInvalidLine)
+	}
+
+	var timestamp time.Time
+
+	if len(sections) == lineSections {
+		units, err := strconv.ParseInt(sections[lineSections-1], 10, 64)
+		if err != nil || units > math.MaxInt64/int64(unit) || units < math.MinInt64/int64(unit) {
+			return nil, fmt.Errorf("timestamp %q: %w", sections[lineSections-1], ErrInvalidLine)
+		}
+
+		timestamp = time.Unix(0, units*int64(unit))
+	}
+
+	series := splitUnescaped(sections[0], ',', false)
+
+	measurement := unescapeLine(series[0])
+	if measurement == "" {
+		return nil, fmt.Errorf("no measurement: %w", ErrInvalidLine)
+	}
+
+	var labels service.Labels
+
+	for _, tag := range series[1:] {
+		name, value, err := splitKeyValue(tag)
+		if err != nil {
+			return nil, fmt.Errorf("tag: %w", err)
+		}
+
+		if labels == nil {
+			labels = service.Labels{}
+		}
+
+		labels[name] = value
+	}
+
+	if err := labels.Validate(); err != nil {
+		return nil, fmt.Errorf("tags: %w", err)
+	}
+
+	var metrics []service.Metric
+
+	for _, field := range splitUnescaped(sections[1], ',', true) {
+		key, value, err := splitKeyValue(field)
+		if err != nil {
+			return nil, fmt.Errorf("field: %w", err)
+		}
+
+		metric, ok, err := influxFieldMetric(measurement+"_"+key, value, rules)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			metric.Labels = labels
+			metric.Timestamp = timestamp
+			metrics = append(metrics, metric)
+		}
+	}
+
+	if len(metrics) == 0 {
+		return nil, fmt.Errorf("no numeric fields: %w", ErrInvalidLine)
+	}
+
+	return metrics, nil
+}
+
+// influxFieldMetric returns the metric of a field value, ok is false for a string.
+func influxFieldMetric(name, value string, rules config.IntegerRules) (service.Metric, bool, error) {
+	metric := service.Metric{
+		ID:         name,
+		MetricType: service.MetricGauge,
+		Delta:      nil,
+		Value:      nil,
+		Histogram:  nil,
+		Summary:    nil,
+		Labels:     nil,
+		Timestamp:  time.Time{},
+	}
+
+	var gauge float64
+
+	switch {
+	case strings.HasPrefix(value, `"`):
+		return service.Metric{}, false, nil
+	case strings.HasSuffix(value, "i"), strings.HasSuffix(value, "u"):
+		var integer int64
+
+		var err error
+
+		if strings.HasSuffix(value, "i") {
+			integer, err = strconv.ParseInt(value[:len(value)-1], 10, 64)
+		} else {
+			integer, err = parseUnsigned(value[:len(value)-1])
+		}
+
+		if err != nil {
+			return service.Metric{}, false, fmt.Errorf("field %s integer %q: %w", name, value, ErrInvalidLine)
+		}
+
+		if integerType(name, rules) == service.MetricCounter {
+			metric.MetricType = service.MetricCounter
+			metric.Delta = &integer
+
+			return metric, true, nil
+		}
+
+		gauge = float64(integer)
+	default:
+		if boolean, err := strconv.ParseBool(value); err == nil {
+			if boolean {
+				gauge = 1
+			}
+
+			break
+		}
+
+		float, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(float) || math.IsInf(float, 0) {
+			return service.Metric{}, false, fmt.Errorf("field %s value %q: %w", name, value, ErrInvalidLine)
+		}
+
+		gauge = float
+	}
+
+	metric.Value = &gauge
+
+	return metric, true, nil
+}
+
+// parseUnsigned parses an unsigned field, the counters and gauges keep int64 ones.
+func parseUnsigned(value string) (int64, error) {
+	unsigned, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unsigned %q: %w", value, err)
+	}
+
+	if unsigned > math.MaxInt64 {
+		return 0, fmt.Errorf("unsigned %q: %w", value, strconv.ErrRange)
+	}
+
+	return int64(unsigned), nil
+}
+
+func integerType(name string, rules config.IntegerRules) string {
+	for _, rule := range rules {
+		if matched, _ := path.Match(rule.Pattern, name); matched {
+			return rule.MetricType
+		}
+	}
+
+	return service.MetricGauge
+}
+
+// splitKeyValue splits key=value at the first unescaped equals sign, unescaping the key.
+// The value of a tag is unescaped as well, a field value is parsed as it is.
+func splitKeyValue(pair string) (string, string, error) {
+	parts := splitUnescaped(pair, '=', true)
+
+	value := strings.Join(parts[1:], "=")
+	if parts[0] == "" || value == "" {
+		return "", "", fmt.Errorf("%q: %w", pair, ErrInvalidLine)
+	}
+
+	if !strings.HasPrefix(value, `"`) {
+		value = unescapeLine(value)
+	}
+
+	return unescapeLine(parts[0]), value, nil
+}
+
+// splitUnescaped splits s at every sep not escaped with a backslash and, with quotes,
+// not inside a double quoted string value.
+func splitUnescaped(s string, sep byte, quotes bool) []string {
+	var (
+		parts    []string
+		start    int
+		quoted   bool
+		previous byte
+	)
+
+	for i := range len(s) {
+		switch {
+		case previous == '\\':
+			// the escaped character, an escaped backslash escapes nothing after it
+			previous = 0
+
+			continue
+		case quoted && s[i] == '"':
+			quoted = false
+		case quotes && s[i] == '"' && previous == '=':
+			quoted = true
+		case !quoted && s[i] == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+
+		previous = s[i]
+	}
+
+	return append(parts, s[start:])
+}
+
+var lineUnescaper = strings.NewReplacer(`\,`, `,`, `\=`, `=`, `\ `, ` `, `\\`, `\`) //nolint:gochecknoglobals // read only
+
+func unescapeLine(s string) string {
+	return lineUnescaper.Replace(s)
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+)
+
+type (
+	// Histogram counts observations in buckets. Counts has a count per bucket: the i-th bucket
+	// holds observations up to Bounds[i], the last one the observations above the last bound.
+	// An update may carry raw Observations instead of counts; they are bucketed on arrival.
+	// Quantiles are estimated from the buckets on reads only.
+	Histogram struct {
+		Bounds       []float64          `json:"bounds"`
+		Counts       []uint64           `json:"counts"`
+		Sum          float64            `json:"sum"`
+		Count        uint64             `json:"count"`
+		Observations []float64          `json:"observations,omitempty"`
+		Quantiles    map[string]float64 `json:"quantiles,omitempty"`
+	}
+)
+
+var (
+	ErrInvalidHistogram = errors.New("invalid histogram")
+	ErrBucketsMismatch  = errors.New("histogram buckets mismatch")
+
+	// DefaultBuckets bucket the raw observations when neither the update nor the config has bounds.
+	DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10} //nolint:gochecknoglobals // read only
+
+	// estimatedQuantiles are returned with every histogram read.
+	estimatedQuantiles = []float64{0.5, 0.9, 0.95, 0.99} //nolint:gochecknoglobals // read only
+)
+
+// normalize buckets the raw observations and checks the histogram is consistent.
+func (h *Histogram) normalize(defaultBounds []float64) error {
+	if len(h.Observations) != 0 {
+		if len(h.Counts) != 0 || h.Count != 0 || h.Sum != 0 {
+			return fmt.Errorf("observations with counts: %w", ErrInvalidHistogram)
+		}
+
+		if len(h.Bounds) == 0 {
+			h.Bounds = slices.Clone(defaultBounds)
+		}
+
+		h.Counts = make([]uint64, len(h.Bounds)+1)
+
+		for _, observation := range h.Observations {
+			if math.IsNaN(observation) || math.IsInf(observation, 0) {
+				return fmt.Errorf("observation %v: %w", observation, ErrInvalidHistogram)
+			}
+
+			bucket, _ := slices.BinarySearch(h.Bounds, observation)
+			h.Counts[bucket]++
+			h.Sum += observation
+			h.Count++
+		}
+
+		h.Observations = nil
+	}
+
+	h.Quantiles = nil
+
+	for i, bound := range h.Bounds {
+		if math.IsNaN(bound) || math.IsInf(bound, 0) || (i != 0 && bound <= h.Bounds[i-1]) {
+			return fmt.Errorf("bound %v: %w", bound, ErrInvalidHistogram)
+		}
+	}
+
+	if len(h.Counts) != len(h.Bounds)+1 {
+		return fmt.Errorf("%d counts for %d bounds: %w", len(h.Counts), len(h.Bounds), ErrInvalidHistogram)
+	}
+
+	var count uint64
+	for _, bucketCount := range h.Counts {
+		count += bucketCount
+	}
+
+	if h.Count == 0 {
+		h.Count = count
+	}
+
+	if h.Count != count {
+		return fmt.Errorf("count %d, bucket counts %d: %w", h.Count, count, ErrInvalidHistogram)
+	}
+
+	return nil
+}
+
+// Merge adds the counts of other, which must have the same buckets.
+func (h *Histogram) Merge(other Histogram) error {
+	if !slices.Equal(h.Bounds, other.Bounds) {
+		return fmt.Errorf("bounds %v and %v: %w", h.Bounds, other.Bounds, ErrBucketsMismatch)
+	}
+
+	counts := slices.Clone(h.Counts)
+	for i := range counts {
+		counts[i] += other.Counts[i]
+	}
+
+	h.Counts = counts
+	h.Sum += other.Sum
+	h.Count += other.Count
+
+	return nil
+}
+
+// Clone copies the histogram, so the copy can be changed while the store keeps the original.
+func (h Histogram) Clone() Histogram {
+	h.Bounds = slices.Clone(h.Bounds)
+	h.Counts = slices.Clone(h.Counts)
+	h.Observations = slices.Clone(h.Observations)
+	h.Quantiles = nil
+
+	return h
+}
+
+// Quantile estimates the q-quantile by linear interpolation inside the bucket it falls in,
+// as Prometheus histogram_quantile does. The first bucket starts at zero, or at its bound when it is negative,
+// and a quantile in the last bucket is the last bound.
+func (h Histogram) Quantile(q float64) float64 {
+	if h.Count == 0 || len(h.Bounds) == 0 || math.IsNaN(q) {
+		return math.NaN()
+	}
+
+	rank := q * float64(h.Count)
+
+	var cumulative uint64
+
+	for i, bucketCount := range h.Counts {
+		if float64(cumulative+bucketCount) < rank || bucketCount == 0 {
+			cumulative += bucketCount
+
+			continue
+		}
+
+		if i == len(h.Bounds) {
+			return h.Bounds[len(h.Bounds)-1]
+		}
+
+		upper := h.Bounds[i]
+
+		lower := min(0, upper)
+		if i != 0 {
+			lower = h.Bounds[i-1]
+		}
+
+		return lower + (upper-lower)*(rank-float64(cumulative))/float64(bucketCount)
+	}
+
+	return h.Bounds[len(h.Bounds)-1]
+}
+
+// withQuantiles returns a copy with the estimated quantiles, keyed as "0.5", "0.9" and so on.
+func (h Histogram) withQuantiles() Histogram {
+	h = h.Clone()
+
+	if h.Count == 0 || len(h.Bounds) == 0 {
+		return h
+	}
+
+	h.Quantiles = make(map[string]float64, len(estimatedQuantiles))
+
+	for _, q := range estimatedQuantiles {
+		h.Quantiles[strconv.FormatFloat(q, 'f', -1, 64)] = h.Quantile(q)
+	}
+
+	return h
+}
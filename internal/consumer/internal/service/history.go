@@ -0,0 +1,105 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// maxRangePoints bounds the points of a downsampled range, as Prometheus does.
+const maxRangePoints = 11_000
+
+type (
+	// Point is the value of a gauge or the total of a counter at the time of an update.
+	Point struct {
+		Time  time.Time `json:"time"`
+		Delta *int64    `json:"delta,omitempty"`
+		Value *float64  `json:"value,omitempty"`
+	}
+
+	// RangeStore is a Store that also keeps the recent points of every gauge and counter.
+	RangeStore interface {
+		Store
+		// Range returns the points of the metric with the key from the from to the to time,
+		// both included, oldest first.
+		Range(key string, from, to time.Time) []Point
+	}
+)
+
+var (
+	ErrHistoryDisabled = errors.New("metric history is disabled")
+	ErrInvalidRange    = errors.New("invalid range")
+)
+
+// GetRange returns the points of the metric selected as in GetMetric. With a step the points are
+// downsampled: every step from the from time there is the last point of the step before it, if any.
+// A zero from starts at the oldest point and a zero to is now.
+func (c Consumer) GetRange(metricType string, id string, from, to time.Time, step time.Duration, matchers ...Matcher) ([]Point, error) {
+	rangeStore, ok := findStore[RangeStore](c.store)
+	if !ok {
+		return nil, ErrHistoryDisabled
+	}
+
+	if metricType != MetricGauge && metricType != MetricCounter {
+		return nil, fmt.Errorf("metric type %s has no history: %w", metricType, ErrUnknownMetricType)
+	}
+
+	if to.IsZero() {
+		to = time.Now()
+	}
+
+	if step < 0 || (!from.IsZero() && from.After(to)) {
+		return nil, fmt.Errorf("from %v, to %v, step %v: %w", from, to, step, ErrInvalidRange)
+	}
+
+	metric, err := c.findMetric(id, matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	if metric.MetricType != metricType {
+		return nil, ErrMetricNotFound
+	}
+
+	points := rangeStore.Range(metric.Key(), from, to)
+	if step == 0 || len(points) == 0 {
+		return points, nil
+	}
+
+	if from.IsZero() {
+		from = points[0].Time
+	}
+
+	if to.Sub(from)/step >= maxRangePoints {
+		return nil, fmt.Errorf("more than %d points of %v: %w", maxRangePoints, step, ErrInvalidRange)
+	}
+
+	return downsample(points, from, to, step), nil
+}
+
+// downsample takes the last point in every step ending at from, from+step and so on up to to,
+// timestamped with the end of the step. Steps without points are left out.
+func downsample(points []Point, from, to time.Time, step time.Duration) []Point {
+	downsampled := make([]Point, 0, min(len(points), int(to.Sub(from)/step)+1))
+
+	next := 0
+
+	for at := from; !at.After(to); at = at.Add(step) {
+		last := -1
+
+		for next < len(points) && !points[next].Time.After(at) {
+			last = next
+			next++
+		}
+
+		if last == -1 || !points[last].Time.After(at.Add(-step)) {
+			continue
+		}
+
+		point := points[last]
+		point.Time = at
+		downsampled = append(downsampled, point)
+	}
+
+	return downsampled
+}
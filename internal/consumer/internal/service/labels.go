@@ -0,0 +1,174 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	MatchEqual     = "="
+	MatchNotEqual  = "!="
+	MatchRegexp    = "=~"
+	MatchNotRegexp = "!~"
+)
+
+type (
+	// Labels are optional dimensions of a metric, such as host or region.
+	// A metric is identified by its name together with its labels, see Metric.Key.
+	Labels map[string]string
+
+	// Matcher selects metrics by a label, as in host="a" or region=~"eu-.*".
+	// A missing label matches as the empty value.
+	Matcher struct {
+		Name  string
+		Type  string
+		Value string
+		re    *regexp.Regexp
+	}
+)
+
+var (
+	ErrInvalidLabel    = errors.New("invalid label")
+	ErrInvalidMatcher  = errors.New("invalid matcher")
+	ErrAmbiguousMetric = errors.New("ambiguous metric")
+
+	labelNameRe     = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	matcherOperator = regexp.MustCompile(`^([a-zA-Z_][a-zA-Z0-9_]*)\s*(=~|!~|!=|=)\s*(.*)$`)
+)
+
+// Key is the store identity of the metric: the name alone without labels,
+// otherwise the name followed by the labels sorted by name, as in cpu{host="a",region="eu"}.
+func (m Metric) Key() string {
+	if len(m.Labels) == 0 {
+		return m.ID
+	}
+
+	var key strings.Builder
+
+	_, _ = key.WriteString(m.ID) // always returns nil error
+	_ = key.WriteByte('{')       // always returns nil error
+
+	names := make([]string, 0, len(m.Labels))
+	for name := range m.Labels {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	for i, name := range names {
+		if i != 0 {
+			_ = key.WriteByte(',') // always returns nil error
+		}
+
+		_, _ = key.WriteString(name)                          // always returns nil error
+		_ = key.WriteByte('=')                                // always returns nil error
+		_, _ = key.WriteString(strconv.Quote(m.Labels[name])) // always returns nil error
+	}
+
+	_ = key.WriteByte('}') // always returns nil error
+
+	return key.String()
+}
+
+func (l Labels) Validate() error {
+	for name := range l {
+		if !labelNameRe.MatchString(name) {
+			return fmt.Errorf("label %q: %w", name, ErrInvalidLabel)
+		}
+	}
+
+	return nil
+}
+
+func NewMatcher(matchType, name, value string) (Matcher, error) {
+	matcher := Matcher{Name: name, Type: matchType, Value: value, re: nil}
+
+	if !labelNameRe.MatchString(name) {
+		return Matcher{}, fmt.Errorf("label %q: %w", name, ErrInvalidMatcher)
+	}
+
+	switch matchType {
+	case MatchEqual, MatchNotEqual:
+	case MatchRegexp, MatchNotRegexp:
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return Matcher{}, fmt.Errorf("label %q regexp: %w", name, errors.Join(ErrInvalidMatcher, err))
+		}
+
+		matcher.re = re
+	default:
+		return Matcher{}, fmt.Errorf("match type %q: %w", matchType, ErrInvalidMatcher)
+	}
+
+	return matcher, nil
+}
+
+// ParseMatcher parses a matcher written as name, operator and value, as in host!="a".
+// The value may be a Go quoted string or bare.
+func ParseMatcher(expr string) (Matcher, error) {
+	parts := matcherOperator.FindStringSubmatch(strings.TrimSpace(expr))
+	if parts == nil {
+		return Matcher{}, fmt.Errorf("matcher %q: %w", expr, ErrInvalidMatcher)
+	}
+
+	value := parts[3]
+
+	if strings.HasPrefix(value, `"`) {
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return Matcher{}, fmt.Errorf("matcher %q: %w", expr, errors.Join(ErrInvalidMatcher, err))
+		}
+
+		value = unquoted
+	}
+
+	return NewMatcher(parts[2], parts[1], value)
+}
+
+func (m Matcher) Matches(labels Labels) bool {
+	value := labels[m.Name]
+
+	switch m.Type {
+	case MatchEqual:
+		return value == m.Value
+	case MatchNotEqual:
+		return value != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(value)
+	case MatchNotRegexp:
+		return !m.re.MatchString(value)
+	default:
+		return false
+	}
+}
+
+func matchLabels(labels Labels, matchers []Matcher) bool {
+	for _, matcher := range matchers {
+		if !matcher.Matches(labels) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// equalLabels returns the labels a set of equality matchers asks for, so the metric can be looked up by its key.
+func equalLabels(matchers []Matcher) (Labels, bool) {
+	labels := Labels{}
+
+	for _, matcher := range matchers {
+		if matcher.Type != MatchEqual {
+			return nil, false
+		}
+
+		if matcher.Value != "" {
+			labels[matcher.Name] = matcher.Value
+		}
+	}
+
+	return labels, true
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+type (
+	// Rollup sums up the updates of a metric from Start for the step of its tier: the count of updates,
+	// the min, max, sum and last value of a gauge or the increase of a counter.
+	Rollup struct {
+		Start    time.Time `json:"start"`
+		Count    uint64    `json:"count"`
+		Min      *float64  `json:"min,omitempty"`
+		Max      *float64  `json:"max,omitempty"`
+		Sum      *float64  `json:"sum,omitempty"`
+		Last     *float64  `json:"last,omitempty"`
+		Increase *int64    `json:"increase,omitempty"`
+	}
+
+	// RollupStore is a Store that also keeps rollups of every gauge and counter in tiers of a step each.
+	RollupStore interface {
+		Store
+		// RollupSteps returns the steps of the tiers, the finest first.
+		RollupSteps() []time.Duration
+		// Rollups returns the rollups of the metric with the key in the tier with the step
+		// that start from the from to the to time, both included, oldest first.
+		Rollups(key string, step time.Duration, from, to time.Time) []Rollup
+	}
+
+	// unwrapper is a Store wrapping another one, as the history and the rollups do.
+	unwrapper interface {
+		Unwrap() Store
+	}
+)
+
+var ErrRollupsDisabled = errors.New("metric rollups are disabled")
+
+// GetRollups returns the rollups of the metric selected as in GetMetric from the tier with
+// the largest step up to the resolution, the finest tier for a smaller one. When the resolution is
+// larger than the step, the rollups are merged into rollups of the resolution.
+// A zero from starts at the oldest rollup and a zero to is now.
+func (c Consumer) GetRollups(metricType string, id string, from, to time.Time, resolution time.Duration, matchers ...Matcher) ([]Rollup, error) {
+	rollupStore, ok := findStore[RollupStore](c.store)
+	if !ok {
+		return nil, ErrRollupsDisabled
+	}
+
+	if metricType != MetricGauge && metricType != MetricCounter {
+		return nil, fmt.Errorf("metric type %s has no rollups: %w", metricType, ErrUnknownMetricType)
+	}
+
+	if to.IsZero() {
+		to = time.Now()
+	}
+
+	if resolution < 0 || (!from.IsZero() && from.After(to)) {
+		return nil, fmt.Errorf("from %v, to %v, resolution %v: %w", from, to, resolution, ErrInvalidRange)
+	}
+
+	metric, err := c.findMetric(id, matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	if metric.MetricType != metricType {
+		return nil, ErrMetricNotFound
+	}
+
+	steps := rollupStore.RollupSteps()
+	if len(steps) == 0 {
+		return nil, ErrRollupsDisabled
+	}
+
+	step := steps[0]
+
+	for _, tierStep := range steps {
+		if tierStep <= resolution {
+			step = tierStep
+		}
+	}
+
+	if !from.IsZero() && to.Sub(from)/max(resolution, step) >= maxRangePoints {
+		return nil, fmt.Errorf("more than %d rollups of %v: %w", maxRangePoints, max(resolution, step), ErrInvalidRange)
+	}
+
+	// a counter that was a gauge before has rollups of both
+	rollups := slices.DeleteFunc(rollupStore.Rollups(metric.Key(), step, from, to), func(rollup Rollup) bool {
+		return (rollup.Increase != nil) != (metricType == MetricCounter)
+	})
+
+	if resolution <= step {
+		return rollups, nil
+	}
+
+	return mergeRollups(rollups, resolution), nil
+}
+
+// mergeRollups merges the rollups starting within the same multiple of the resolution.
+func mergeRollups(rollups []Rollup, resolution time.Duration) []Rollup {
+	merged := make([]Rollup, 0, len(rollups))
+
+	for _, rollup := range rollups {
+		start := rollup.Start.Truncate(resolution)
+
+		if len(merged) == 0 || !merged[len(merged)-1].Start.Equal(start) {
+			rollup.Start = start
+			merged = append(merged, rollup)
+
+			continue
+		}
+
+		merged[len(merged)-1] = merged[len(merged)-1].merge(rollup)
+	}
+
+	return merged
+}
+
+// merge adds a later rollup of the same metric.
+func (r Rollup) merge(later Rollup) Rollup {
+	r.Count += later.Count
+
+	if r.Increase != nil && later.Increase != nil {
+		increase := *r.Increase + *later.Increase
+		r.Increase = &increase
+	}
+
+	if r.Min != nil && later.Min != nil {
+		minimum, maximum, sum := min(*r.Min, *later.Min), max(*r.Max, *later.Max), *r.Sum+*later.Sum
+		r.Min, r.Max, r.Sum, r.Last = &minimum, &maximum, &sum, later.Last
+	}
+
+	return r
+}
+
+// findStore looks for a store of type T among the wrapped ones.
+func findStore[T Store](store Store) (T, bool) {
+	for {
+		if found, ok := store.(T); ok {
+			return found, true
+		}
+
+		wrapper, ok := store.(unwrapper)
+		if !ok {
+			var zero T
+
+			return zero, false
+		}
+
+		store = wrapper.Unwrap()
+	}
+}
@@ -3,22 +3,32 @@ package service
 import (
 	"errors"
 	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
 
 	"metrics/config"
 	"metrics/internal/log"
 )
 
 const (
-	MetricCounter = "counter"
-	MetricGauge   = "gauge"
+	MetricCounter   = "counter"
+	MetricGauge     = "gauge"
+	MetricHistogram = "histogram"
+	MetricSummary   = "summary"
 )
 
 type (
 	Metric struct {
-		ID         string   `json:"id"              validate:"required"`
-		MetricType string   `json:"type"            validate:"required,oneof=gauge counter"`
-		Delta      *int64   `json:"delta,omitempty"`
-		Value      *float64 `json:"value,omitempty"`
+		ID         string     `json:"id"                  validate:"required"`
+		MetricType string     `json:"type"                validate:"required,oneof=gauge counter histogram summary"`
+		Delta      *int64     `json:"delta,omitempty"`
+		Value      *float64   `json:"value,omitempty"`
+		Histogram  *Histogram `json:"histogram,omitempty"`
+		Summary    *Summary   `json:"summary,omitempty"`
+		Labels     Labels     `json:"labels,omitempty"`
+		Timestamp  time.Time  `json:"-"`
 	}
 )
 
@@ -28,32 +38,62 @@ var (
 	ErrUnknownDBType     = errors.New("unknown db type")
 )
 
+// ObservedAt returns the time the value was observed, as a write of the line protocol tells it in Timestamp.
+// The zero Timestamp is the time of the update, now.
+func (m Metric) ObservedAt(now time.Time) time.Time {
+	if m.Timestamp.IsZero() {
+		return now
+	}
+
+	return m.Timestamp
+}
+
+// Store keeps metrics by Metric.Key.
 type Store interface {
 	AddGauge(gauge Metric) error
 	AddCounter(counter Metric, increment bool) error
-	GetMetric(id string) (Metric, error)
+	// AddHistogram merges the histogram into the stored one when increment is set
+	// and leaves the result in histogram.Histogram, as AddCounter does with the delta.
+	AddHistogram(histogram Metric, increment bool) error
+	// AddSummary merges the summary into the stored one when increment is set
+	// and leaves the result in summary.Summary.
+	AddSummary(summary Metric, increment bool) error
+	AddMetrics(metrics []Metric) error
+	GetMetric(key string) (Metric, error)
 	GetAllMetrics() []Metric
 	Close()
 }
 
 type Consumer struct {
-	store  Store
-	config config.ConsumerConfig
+	store      Store
+	config     config.ConsumerConfig
+	cumulative *cumulativeSeries
+}
+
+// cumulativeSeries keeps when the total of every cumulative counter was observed last.
+type cumulativeSeries struct {
+	mu       sync.Mutex
+	observed map[string]time.Time
 }
 
 func NewConsumerService(store Store, config config.ConsumerConfig) Consumer {
 	return Consumer{
-		store:  store,
-		config: config,
+		store:      store,
+		config:     config,
+		cumulative: &cumulativeSeries{mu: sync.Mutex{}, observed: map[string]time.Time{}},
 	}
 }
 
-func (c Consumer) AddGauge(gaugeName string, gaugeValue float64) (Metric, error) {
+func (c Consumer) AddGauge(gaugeName string, gaugeValue float64, labels Labels) (Metric, error) {
 	gauge := Metric{
 		ID:         gaugeName,
 		MetricType: MetricGauge,
 		Value:      &gaugeValue,
 		Delta:      nil,
+		Histogram:  nil,
+		Summary:    nil,
+		Labels:     labels,
+		Timestamp:  time.Time{},
 	}
 
 	if err := c.store.AddGauge(gauge); err != nil {
@@ -61,18 +101,22 @@ func (c Consumer) AddGauge(gaugeName string, gaugeValue float64) (Metric, error)
 	}
 
 	log.Debug("gauge added",
-		log.StringAttr("name", gauge.ID),
+		log.StringAttr("name", gauge.Key()),
 		log.Float64Attr("gauge", *gauge.Value))
 
 	return gauge, nil
 }
 
-func (c Consumer) AddCounter(counterName string, counterValue int64) (Metric, error) {
+func (c Consumer) AddCounter(counterName string, counterValue int64, labels Labels) (Metric, error) {
 	counter := Metric{
 		ID:         counterName,
 		MetricType: MetricCounter,
 		Value:      nil,
 		Delta:      &counterValue,
+		Histogram:  nil,
+		Summary:    nil,
+		Labels:     labels,
+		Timestamp:  time.Time{},
 	}
 
 	if err := c.store.AddCounter(counter, true); err != nil {
@@ -80,39 +124,330 @@ func (c Consumer) AddCounter(counterName string, counterValue int64) (Metric, er
 	}
 
 	log.Debug("counter added",
-		log.StringAttr("name", counter.ID),
+		log.StringAttr("name", counter.Key()),
 		log.Int64Attr("counter", *counter.Delta))
 
 	return counter, nil
 }
 
-func (c Consumer) GetMetric(id string) (Metric, error) {
-	metric, err := c.store.GetMetric(id)
+// AddHistogram buckets the observations, if the histogram has them, and merges it into the stored one.
+// The result is the merged histogram.
+func (c Consumer) AddHistogram(histogramName string, histogram Histogram, labels Labels) (Metric, error) {
+	histogram = histogram.Clone()
+
+	if err := histogram.normalize(c.buckets()); err != nil {
+		return Metric{}, fmt.Errorf("histogram %s: %w", histogramName, err)
+	}
+
+	metric := Metric{
+		ID:         histogramName,
+		MetricType: MetricHistogram,
+		Value:      nil,
+		Delta:      nil,
+		Histogram:  &histogram,
+		Summary:    nil,
+		Labels:     labels,
+		Timestamp:  time.Time{},
+	}
+
+	if err := c.store.AddHistogram(metric, true); err != nil {
+		return Metric{}, fmt.Errorf("failed to add histogram %s: %w", histogramName, err)
+	}
+
+	log.Debug("histogram added",
+		log.StringAttr("name", metric.Key()),
+		log.Uint64Attr("count", histogram.Count))
+
+	return metric, nil
+}
+
+// AddSummary adds the observations to the sketch of the stored summary. The result is the merged summary.
+func (c Consumer) AddSummary(summaryName string, summary Summary, labels Labels) (Metric, error) {
+	summary = summary.Clone()
+
+	if err := summary.normalize(); err != nil {
+		return Metric{}, fmt.Errorf("summary %s: %w", summaryName, err)
+	}
+
+	metric := Metric{
+		ID:         summaryName,
+		MetricType: MetricSummary,
+		Value:      nil,
+		Delta:      nil,
+		Histogram:  nil,
+		Summary:    &summary,
+		Labels:     labels,
+		Timestamp:  time.Time{},
+	}
+
+	if err := c.store.AddSummary(metric, true); err != nil {
+		return Metric{}, fmt.Errorf("failed to add summary %s: %w", summaryName, err)
+	}
+
+	log.Debug("summary added",
+		log.StringAttr("name", metric.Key()),
+		log.Uint64Attr("count", summary.Count))
+
+	return metric, nil
+}
+
+func (c Consumer) buckets() []float64 {
+	if len(c.config.Consumer.Buckets) != 0 {
+		return c.config.Consumer.Buckets
+	}
+
+	return DefaultBuckets
+}
+
+// AddMetrics stores a batch of metrics atomically and returns them with the stored values,
+// so counters carry their accumulated delta.
+func (c Consumer) AddMetrics(metrics []Metric) ([]Metric, error) {
+	batch := make([]Metric, 0, len(metrics))
+
+	for _, metric := range metrics {
+		switch metric.MetricType {
+		case MetricCounter:
+			delta := *metric.Delta
+			metric.Delta = &delta
+		case MetricGauge:
+			value := *metric.Value
+			metric.Value = &value
+		case MetricHistogram:
+			histogram := metric.Histogram.Clone()
+			if err := histogram.normalize(c.buckets()); err != nil {
+				return nil, fmt.Errorf("histogram %s: %w", metric.ID, err)
+			}
+
+			metric.Histogram = &histogram
+		case MetricSummary:
+			summary := metric.Summary.Clone()
+			if err := summary.normalize(); err != nil {
+				return nil, fmt.Errorf("summary %s: %w", metric.ID, err)
+			}
+
+			metric.Summary = &summary
+		default:
+			return nil, fmt.Errorf("metric %s type %s: %w", metric.ID, metric.MetricType, ErrUnknownMetricType)
+		}
+
+		batch = append(batch, metric)
+	}
+
+	if err := c.store.AddMetrics(batch); err != nil {
+		return nil, fmt.Errorf("failed to add metrics batch: %w", err)
+	}
+
+	log.Debug("metrics batch added",
+		log.IntAttr("count", len(batch)))
+
+	return batch, nil
+}
+
+// AddCumulativeMetrics stores a batch whose counters carry running totals, as Prometheus sends them,
+// rather than increments: a counter adds the difference from the stored total, so the stored total
+// follows the sent one. A total below the stored one is a reset, the counter counted from zero again:
+// it is set to zero and adds the total, so the rollups see the increase since the reset. A reset splits
+// the batch, the part before it is stored first. A total observed before the last one of its counter
+// came late and is dropped, the observation times are kept in memory only. Batches are stored one at a time.
+func (c Consumer) AddCumulativeMetrics(metrics []Metric) ([]Metric, error) {
+	c.cumulative.mu.Lock()
+	defer c.cumulative.mu.Unlock()
+
+	now := time.Now()
+	totals := map[string]int64{}
+	observed := map[string]time.Time{}
+	result := make([]Metric, 0, len(metrics))
+	batch := make([]Metric, 0, len(metrics))
+
+	for _, metric := range metrics {
+		if metric.MetricType == MetricCounter {
+			key := metric.Key()
+
+			last, ok := observed[key]
+			if !ok {
+				last = c.cumulative.observed[key]
+			}
+
+			at := metric.ObservedAt(now)
+			if at.Before(last) {
+				log.Debug("late counter total dropped",
+					log.StringAttr("name", key))
+
+				continue
+			}
+
+			observed[key] = at
+
+			current, ok := totals[key]
+			if !ok {
+				if stored, err := c.store.GetMetric(key); err == nil && stored.MetricType == MetricCounter && stored.Delta != nil {
+					current = *stored.Delta
+				}
+			}
+
+			total := *metric.Delta
+			totals[key] = total
+
+			delta := total - current
+
+			if total < current {
+				added, err := c.resetCounter(metric, batch)
+				if err != nil {
+					return nil, err
+				}
+
+				result = append(result, added...)
+				batch = make([]Metric, 0, len(metrics))
+				delta = total
+			}
+
+			metric.Delta = &delta
+		}
+
+		batch = append(batch, metric)
+	}
+
+	if len(batch) != 0 {
+		added, err := c.AddMetrics(batch)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, added...)
+	}
+
+	maps.Copy(c.cumulative.observed, observed)
+
+	return result, nil
+}
+
+// resetCounter stores the batch before the reset of the counter and sets the counter to zero.
+func (c Consumer) resetCounter(counter Metric, batch []Metric) ([]Metric, error) {
+	var added []Metric
+
+	if len(batch) != 0 {
+		var err error
+		if added, err = c.AddMetrics(batch); err != nil {
+			return nil, err
+		}
+	}
+
+	zero := int64(0)
+	counter.Delta = &zero
+
+	if err := c.store.AddCounter(counter, false); err != nil {
+		return nil, fmt.Errorf("failed to reset counter %s: %w", counter.Key(), err)
+	}
+
+	log.Debug("counter reset",
+		log.StringAttr("name", counter.Key()))
+
+	return added, nil
+}
+
+// GetMetric returns the metric named id whose labels satisfy the matchers. Without matchers it is
+// the metric without labels. ErrAmbiguousMetric means the matchers select more than one metric.
+func (c Consumer) GetMetric(id string, matchers ...Matcher) (Metric, error) {
+	metric, err := c.findMetric(id, matchers)
 	if err != nil {
-		return Metric{}, ErrMetricNotFound
+		return Metric{}, err
 	}
 
 	switch metric.MetricType {
 	case MetricGauge:
 		log.Debug("gauge returned",
-			log.StringAttr("name", metric.ID),
+			log.StringAttr("name", metric.Key()),
 			log.Float64Attr("gauge", *metric.Value))
 	case MetricCounter:
 		log.Debug("counter returned",
-			log.StringAttr("name", metric.ID),
+			log.StringAttr("name", metric.Key()),
 			log.Int64Attr("counter", *metric.Delta))
+	case MetricHistogram:
+		log.Debug("histogram returned",
+			log.StringAttr("name", metric.Key()),
+			log.Uint64Attr("count", metric.Histogram.Count))
+	case MetricSummary:
+		log.Debug("summary returned",
+			log.StringAttr("name", metric.Key()),
+			log.Uint64Attr("count", metric.Summary.Count))
 	default:
 		return Metric{}, ErrUnknownMetricType
 	}
 
-	return metric, nil
+	return c.withQuantiles(metric), nil
 }
 
-func (c Consumer) GetAllMetrics() []Metric {
+func (c Consumer) findMetric(id string, matchers []Matcher) (Metric, error) {
+	labels, isExact := equalLabels(matchers)
+	if isExact {
+		metric, err := c.store.GetMetric(Metric{ID: id, Labels: labels}.Key()) //nolint:exhaustruct // key only
+		if err == nil {
+			return metric, nil
+		}
+
+		if len(matchers) == 0 {
+			return Metric{}, ErrMetricNotFound
+		}
+	}
+
+	var found []Metric
+
+	for _, metric := range c.store.GetAllMetrics() {
+		if metric.ID == id && matchLabels(metric.Labels, matchers) {
+			found = append(found, metric)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return Metric{}, ErrMetricNotFound
+	case 1:
+		return found[0], nil
+	default:
+		return Metric{}, fmt.Errorf("metric %s: %d matches: %w", id, len(found), ErrAmbiguousMetric)
+	}
+}
+
+// GetAllMetrics returns the metrics whose labels satisfy all the matchers.
+func (c Consumer) GetAllMetrics(matchers ...Matcher) []Metric {
 	metrics := c.store.GetAllMetrics()
 
+	if len(matchers) != 0 {
+		metrics = slices.DeleteFunc(metrics, func(metric Metric) bool {
+			return !matchLabels(metric.Labels, matchers)
+		})
+	}
+
+	for i, metric := range metrics {
+		metrics[i] = c.withQuantiles(metric)
+	}
+
 	log.Debug("all metrics returned",
 		log.StringAttr("metrics", fmt.Sprintf("%v", metrics)))
 
 	return metrics
 }
+
+// withQuantiles adds the quantile estimates to a histogram or a summary read from the store,
+// leaving the stored one intact.
+func (c Consumer) withQuantiles(metric Metric) Metric {
+	if metric.MetricType == MetricHistogram && metric.Histogram != nil {
+		histogram := metric.Histogram.withQuantiles()
+		metric.Histogram = &histogram
+	}
+
+	if metric.MetricType == MetricSummary && metric.Summary != nil {
+		summary := metric.Summary.withQuantiles(c.quantiles())
+		metric.Summary = &summary
+	}
+
+	return metric
+}
+
+func (c Consumer) quantiles() []float64 {
+	if len(c.config.Consumer.SummaryQuantiles) != 0 {
+		return c.config.Consumer.SummaryQuantiles
+	}
+
+	return DefaultQuantiles
+}
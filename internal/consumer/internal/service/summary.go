@@ -0,0 +1,248 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+)
+
+const (
+	// summaryAccuracy is the relative error of the summary quantiles.
+	summaryAccuracy = 0.01
+
+	// summaryGamma is the ratio of the bounds of a summary bin.
+	summaryGamma = (1 + summaryAccuracy) / (1 - summaryAccuracy)
+
+	// summaryMaxBins bounds the bins per sign; the bins closest to zero are collapsed beyond it.
+	summaryMaxBins = 2048
+
+	// summaryMinValue is the smallest magnitude kept apart from zero.
+	summaryMinValue = 1e-9
+)
+
+type (
+	// Summary is a DDSketch. An observation v is counted in the bin i with gamma^(i-1) < |v| <= gamma^i,
+	// so a quantile read from the bins is off by at most summaryAccuracy of its value. Sketches merge
+	// by adding the bins, which gives the same sketch as one stream of all the observations.
+	// An update carries raw Observations; Quantiles are estimated on reads only.
+	Summary struct {
+		Positive     map[int]uint64     `json:"positive,omitempty"`
+		Negative     map[int]uint64     `json:"negative,omitempty"`
+		Zero         uint64             `json:"zero,omitempty"`
+		Sum          float64            `json:"sum"`
+		Count        uint64             `json:"count"`
+		Min          float64            `json:"min"`
+		Max          float64            `json:"max"`
+		Observations []float64          `json:"observations,omitempty"`
+		Quantiles    map[string]float64 `json:"quantiles,omitempty"`
+	}
+)
+
+var (
+	ErrInvalidSummary = errors.New("invalid summary")
+
+	// DefaultQuantiles are returned with the summaries when the config has none.
+	DefaultQuantiles = []float64{0.5, 0.9, 0.99} //nolint:gochecknoglobals // read only
+
+	summaryLogGamma = math.Log(summaryGamma) //nolint:gochecknoglobals // read only
+)
+
+// normalize turns the raw observations of an update into a sketch.
+func (s *Summary) normalize() error {
+	if len(s.Positive) != 0 || len(s.Negative) != 0 || s.Zero != 0 || s.Count != 0 || s.Sum != 0 {
+		return fmt.Errorf("update with bins: %w", ErrInvalidSummary)
+	}
+
+	if len(s.Observations) == 0 {
+		return fmt.Errorf("no observations: %w", ErrInvalidSummary)
+	}
+
+	observations := s.Observations
+	*s = Summary{} //nolint:exhaustruct // empty
+
+	for _, observation := range observations {
+		if math.IsNaN(observation) || math.IsInf(observation, 0) {
+			return fmt.Errorf("observation %v: %w", observation, ErrInvalidSummary)
+		}
+
+		s.add(observation)
+	}
+
+	s.Positive = collapseBins(s.Positive)
+	s.Negative = collapseBins(s.Negative)
+
+	return nil
+}
+
+func (s *Summary) add(observation float64) {
+	if s.Count == 0 || observation < s.Min {
+		s.Min = observation
+	}
+
+	if s.Count == 0 || observation > s.Max {
+		s.Max = observation
+	}
+
+	s.Count++
+	s.Sum += observation
+
+	switch {
+	case observation > summaryMinValue:
+		s.Positive = addBin(s.Positive, summaryIndex(observation), 1)
+	case observation < -summaryMinValue:
+		s.Negative = addBin(s.Negative, summaryIndex(-observation), 1)
+	default:
+		s.Zero++
+	}
+}
+
+// Merge adds the observations of other. The bins of s are copied, not changed in place.
+func (s *Summary) Merge(other Summary) {
+	if other.Count == 0 {
+		return
+	}
+
+	if s.Count == 0 || other.Min < s.Min {
+		s.Min = other.Min
+	}
+
+	if s.Count == 0 || other.Max > s.Max {
+		s.Max = other.Max
+	}
+
+	s.Positive = mergeBins(s.Positive, other.Positive)
+	s.Negative = mergeBins(s.Negative, other.Negative)
+	s.Zero += other.Zero
+	s.Sum += other.Sum
+	s.Count += other.Count
+}
+
+// Clone copies the summary, so the copy can be changed while the store keeps the original.
+func (s Summary) Clone() Summary {
+	s.Positive = maps.Clone(s.Positive)
+	s.Negative = maps.Clone(s.Negative)
+	s.Observations = slices.Clone(s.Observations)
+	s.Quantiles = nil
+
+	return s
+}
+
+// Quantile estimates the q-quantile from the bin holding the observation of that rank.
+func (s Summary) Quantile(q float64) float64 {
+	if s.Count == 0 || !(q >= 0 && q <= 1) {
+		return math.NaN()
+	}
+
+	rank := uint64(q * float64(s.Count-1))
+
+	var cumulative uint64
+
+	// the negative bins go from the largest magnitude, the most negative value
+	negative := sortedBins(s.Negative)
+	for i := len(negative) - 1; i >= 0; i-- {
+		cumulative += s.Negative[negative[i]]
+		if cumulative > rank {
+			return s.clamp(-summaryValue(negative[i]))
+		}
+	}
+
+	cumulative += s.Zero
+	if cumulative > rank {
+		return s.clamp(0)
+	}
+
+	for _, index := range sortedBins(s.Positive) {
+		cumulative += s.Positive[index]
+		if cumulative > rank {
+			return s.clamp(summaryValue(index))
+		}
+	}
+
+	return s.Max
+}
+
+func (s Summary) clamp(value float64) float64 {
+	return min(max(value, s.Min), s.Max)
+}
+
+// withQuantiles returns a copy with the estimated quantiles, keyed as "0.5", "0.9" and so on.
+func (s Summary) withQuantiles(quantiles []float64) Summary {
+	s = s.Clone()
+
+	if s.Count == 0 {
+		return s
+	}
+
+	s.Quantiles = make(map[string]float64, len(quantiles))
+
+	for _, q := range quantiles {
+		s.Quantiles[strconv.FormatFloat(q, 'f', -1, 64)] = s.Quantile(q)
+	}
+
+	return s
+}
+
+// summaryIndex is the bin of a positive magnitude.
+func summaryIndex(value float64) int {
+	return int(math.Ceil(math.Log(value) / summaryLogGamma))
+}
+
+// summaryValue is the value of a bin, within summaryAccuracy of both of its bounds.
+func summaryValue(index int) float64 {
+	return 2 * math.Pow(summaryGamma, float64(index)) / (summaryGamma + 1) //nolint:mnd // DDSketch
+}
+
+func addBin(bins map[int]uint64, index int, count uint64) map[int]uint64 {
+	if bins == nil {
+		bins = map[int]uint64{}
+	}
+
+	bins[index] += count
+
+	return bins
+}
+
+func mergeBins(bins, other map[int]uint64) map[int]uint64 {
+	if len(other) == 0 {
+		return bins
+	}
+
+	merged := maps.Clone(bins)
+
+	for index, count := range other {
+		merged = addBin(merged, index, count)
+	}
+
+	return collapseBins(merged)
+}
+
+// collapseBins adds the bins closest to zero into one, when there are more than summaryMaxBins.
+func collapseBins(bins map[int]uint64) map[int]uint64 {
+	if len(bins) <= summaryMaxBins {
+		return bins
+	}
+
+	indexes := sortedBins(bins)
+	target := indexes[len(indexes)-summaryMaxBins]
+
+	for _, index := range indexes[:len(indexes)-summaryMaxBins] {
+		bins[target] += bins[index]
+		delete(bins, index)
+	}
+
+	return bins
+}
+
+func sortedBins(bins map[int]uint64) []int {
+	indexes := make([]int, 0, len(bins))
+	for index := range bins {
+		indexes = append(indexes, index)
+	}
+
+	slices.Sort(indexes)
+
+	return indexes
+}
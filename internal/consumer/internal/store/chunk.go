@@ -0,0 +1,530 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+)
+
+// ChunkEncoding tells how the values of a chunk are compressed.
+type ChunkEncoding byte
+
+const (
+	// EncodingXOR keeps gauges: every value is XORed with the previous one and only the meaningful bits are written.
+	EncodingXOR ChunkEncoding = 1
+	// EncodingVarint keeps counter totals as varints of the difference from the previous total.
+	EncodingVarint ChunkEncoding = 2
+)
+
+const (
+	// chunkSamples is the number of samples after which a chunk is closed, as in Prometheus:
+	// a longer chunk compresses only a little better and costs more to read from the start.
+	chunkSamples = 120
+
+	floatBits = 64
+
+	// an XOR window is written as its start, up to 31 leading zeros, and its length
+	windowStartBits  = 5
+	windowLengthBits = 6
+	maxWindowStart   = 1<<windowStartBits - 1
+)
+
+// dodBuckets are the Prometheus bucket sizes of the delta of deltas of millisecond timestamps:
+// a zero bit for no change, then 10, 110, 1110 and 1111 followed by 14, 17, 20 and all 64 bits.
+var dodBuckets = []struct { //nolint:gochecknoglobals // read only
+	prefix     uint64
+	prefixBits int
+	size       int
+}{
+	{prefix: 0b10, prefixBits: 2, size: 14},
+	{prefix: 0b110, prefixBits: 3, size: 17},
+	{prefix: 0b1110, prefixBits: 4, size: 20},
+	{prefix: 0b1111, prefixBits: 4, size: 64},
+}
+
+var ErrCorruptedChunk = errors.New("corrupted chunk")
+
+type (
+	// Chunk holds the samples of one series compressed as in Gorilla, the Facebook time series database:
+	// the first timestamp in full, the second as a delta and the next ones as the delta of deltas,
+	// which is zero for samples at a regular interval and takes a single bit then.
+	// Timestamps are unix milliseconds. A chunk is not safe for concurrent use.
+	Chunk struct {
+		encoding ChunkEncoding
+		stream   bstream
+		count    int
+
+		// appender state
+		t        int64
+		tDelta   int64
+		value    uint64 // float bits for EncodingXOR, the int64 total for EncodingVarint
+		leading  uint8
+		trailing uint8
+	}
+
+	// ChunkIterator reads the samples of a chunk in the order they were appended.
+	ChunkIterator struct {
+		encoding ChunkEncoding
+		reader   breader
+		count    int
+		read     int
+		err      error
+
+		t        int64
+		tDelta   int64
+		value    uint64
+		leading  uint8
+		trailing uint8
+	}
+)
+
+func NewChunk(encoding ChunkEncoding) *Chunk {
+	return &Chunk{encoding: encoding, stream: bstream{}, count: 0, t: 0, tDelta: 0, value: 0, leading: math.MaxUint8, trailing: 0}
+}
+
+// loadChunk wraps the bytes of a chunk written before. Nothing can be appended to it.
+func loadChunk(encoding ChunkEncoding, count int, data []byte) (*Chunk, error) {
+	if encoding != EncodingXOR && encoding != EncodingVarint {
+		return nil, fmt.Errorf("encoding %d: %w", encoding, ErrCorruptedChunk)
+	}
+
+	chunk := NewChunk(encoding)
+	chunk.stream = bstream{stream: data, free: 0}
+	chunk.count = count
+
+	return chunk, nil
+}
+
+func (c *Chunk) Encoding() ChunkEncoding {
+	return c.encoding
+}
+
+func (c *Chunk) NumSamples() int {
+	return c.count
+}
+
+// Bytes returns the compressed samples; the chunk keeps using them.
+func (c *Chunk) Bytes() []byte {
+	return c.stream.stream
+}
+
+func (c *Chunk) isFull() bool {
+	return c.count >= chunkSamples
+}
+
+// AppendFloat adds a sample to an EncodingXOR chunk.
+func (c *Chunk) AppendFloat(t int64, value float64) {
+	c.appendTime(t)
+
+	valueBits := math.Float64bits(value)
+
+	if c.count == 0 {
+		c.stream.writeBits(valueBits, floatBits)
+	} else {
+		c.writeXOR(valueBits)
+	}
+
+	c.value = valueBits
+	c.count++
+}
+
+// AppendInt adds a sample to an EncodingVarint chunk.
+func (c *Chunk) AppendInt(t int64, value int64) {
+	c.appendTime(t)
+
+	if c.count == 0 {
+		c.stream.writeVarint(value)
+	} else {
+		c.stream.writeVarint(value - int64(c.value))
+	}
+
+	c.value = uint64(value)
+	c.count++
+}
+
+func (c *Chunk) appendTime(t int64) {
+	switch c.count {
+	case 0:
+		c.stream.writeVarint(t)
+	case 1:
+		c.tDelta = t - c.t
+		c.stream.writeVarint(c.tDelta)
+	default:
+		tDelta := t - c.t
+		c.writeDeltaOfDelta(tDelta - c.tDelta)
+		c.tDelta = tDelta
+	}
+
+	c.t = t
+}
+
+func (c *Chunk) writeDeltaOfDelta(dod int64) {
+	if dod == 0 {
+		c.stream.writeBit(false)
+
+		return
+	}
+
+	for _, bucket := range dodBuckets {
+		if bucket.size == floatBits || fitsBits(dod, bucket.size) {
+			c.stream.writeBits(bucket.prefix, bucket.prefixBits)
+			c.stream.writeBits(uint64(dod), bucket.size)
+
+			return
+		}
+	}
+}
+
+// writeXOR writes 0 for an unchanged value, 10 and the meaningful bits when they fit
+// the previous window of leading and trailing zeros, or 11, the new window and the bits.
+func (c *Chunk) writeXOR(valueBits uint64) {
+	xor := valueBits ^ c.value
+
+	if xor == 0 {
+		c.stream.writeBit(false)
+
+		return
+	}
+
+	c.stream.writeBit(true)
+
+	leading := uint8(bits.LeadingZeros64(xor))
+	trailing := uint8(bits.TrailingZeros64(xor))
+
+	leading = min(leading, maxWindowStart)
+
+	if c.leading != math.MaxUint8 && leading >= c.leading && trailing >= c.trailing {
+		c.stream.writeBit(false)
+		c.stream.writeBits(xor>>c.trailing, floatBits-int(c.leading)-int(c.trailing))
+
+		return
+	}
+
+	c.leading, c.trailing = leading, trailing
+
+	significant := floatBits - leading - trailing
+
+	c.stream.writeBit(true)
+	c.stream.writeBits(uint64(leading), windowStartBits)
+	// 64 significant bits do not fit and are written as 0
+	c.stream.writeBits(uint64(significant), windowLengthBits)
+	c.stream.writeBits(xor>>trailing, int(significant))
+}
+
+// Iterator reads the chunk from the start. Appending to the chunk while iterating is not allowed.
+func (c *Chunk) Iterator() *ChunkIterator {
+	return &ChunkIterator{
+		encoding: c.encoding,
+		reader:   breader{stream: c.stream.stream, pos: 0},
+		count:    c.count,
+		read:     0,
+		err:      nil,
+		t:        0,
+		tDelta:   0,
+		value:    0,
+		leading:  0,
+		trailing: 0,
+	}
+}
+
+// Next reads the next sample and reports whether there was one.
+func (it *ChunkIterator) Next() bool {
+	if it.err != nil || it.read == it.count {
+		return false
+	}
+
+	if err := it.readTime(); err != nil {
+		it.err = fmt.Errorf("sample %d timestamp: %w", it.read, err)
+
+		return false
+	}
+
+	if err := it.readValue(); err != nil {
+		it.err = fmt.Errorf("sample %d value: %w", it.read, err)
+
+		return false
+	}
+
+	it.read++
+
+	return true
+}
+
+// At returns the current sample of an EncodingXOR chunk.
+func (it *ChunkIterator) At() (int64, float64) {
+	return it.t, math.Float64frombits(it.value)
+}
+
+// AtInt returns the current sample of an EncodingVarint chunk.
+func (it *ChunkIterator) AtInt() (int64, int64) {
+	return it.t, int64(it.value)
+}
+
+// Err is the error that stopped Next, if any.
+func (it *ChunkIterator) Err() error {
+	return it.err
+}
+
+func (it *ChunkIterator) readTime() error {
+	switch it.read {
+	case 0:
+		t, err := it.reader.readVarint()
+		if err != nil {
+			return err
+		}
+
+		it.t = t
+	case 1:
+		tDelta, err := it.reader.readVarint()
+		if err != nil {
+			return err
+		}
+
+		it.tDelta = tDelta
+		it.t += tDelta
+	default:
+		dod, err := it.readDeltaOfDelta()
+		if err != nil {
+			return err
+		}
+
+		it.tDelta += dod
+		it.t += it.tDelta
+	}
+
+	return nil
+}
+
+func (it *ChunkIterator) readDeltaOfDelta() (int64, error) {
+	// the prefix is a zero alone or up to four ones ended by a zero
+	ones := 0
+
+	for ones < len(dodBuckets) {
+		bit, err := it.reader.readBit()
+		if err != nil {
+			return 0, err
+		}
+
+		if !bit {
+			break
+		}
+
+		ones++
+	}
+
+	if ones == 0 {
+		return 0, nil
+	}
+
+	size := dodBuckets[min(ones, len(dodBuckets))-1].size
+
+	value, err := it.reader.readBits(size)
+	if err != nil {
+		return 0, err
+	}
+
+	// sign extension of the smaller buckets
+	if size != floatBits && value > 1<<(size-1) {
+		return int64(value) - 1<<size, nil
+	}
+
+	return int64(value), nil
+}
+
+func (it *ChunkIterator) readValue() error {
+	if it.encoding == EncodingVarint {
+		value, err := it.reader.readVarint()
+		if err != nil {
+			return err
+		}
+
+		if it.read == 0 {
+			it.value = uint64(value)
+		} else {
+			it.value = uint64(int64(it.value) + value)
+		}
+
+		return nil
+	}
+
+	if it.read == 0 {
+		value, err := it.reader.readBits(floatBits)
+		if err != nil {
+			return err
+		}
+
+		it.value = value
+
+		return nil
+	}
+
+	return it.readXOR()
+}
+
+func (it *ChunkIterator) readXOR() error {
+	changed, err := it.reader.readBit()
+	if err != nil || !changed {
+		return err
+	}
+
+	newWindow, err := it.reader.readBit()
+	if err != nil {
+		return err
+	}
+
+	if newWindow {
+		leading, err := it.reader.readBits(windowStartBits)
+		if err != nil {
+			return err
+		}
+
+		significant, err := it.reader.readBits(windowLengthBits)
+		if err != nil {
+			return err
+		}
+
+		if significant == 0 {
+			significant = floatBits
+		}
+
+		if leading+significant > floatBits {
+			return ErrCorruptedChunk
+		}
+
+		it.leading = uint8(leading)
+		it.trailing = uint8(floatBits - leading - significant)
+	}
+
+	xor, err := it.reader.readBits(floatBits - int(it.leading) - int(it.trailing))
+	if err != nil {
+		return err
+	}
+
+	it.value ^= xor << it.trailing
+
+	return nil
+}
+
+// fitsBits reports whether a delta of deltas fits a bucket of size bits. The range is shifted by one,
+// -(2^(size-1)-1) to 2^(size-1), as the reader sign-extends values above 2^(size-1) only.
+func fitsBits(value int64, size int) bool {
+	return -(1<<(size-1))+1 <= value && value <= 1<<(size-1)
+}
+
+// bstream is a stream of bits written from the most significant bit of every byte.
+type bstream struct {
+	stream []byte
+	free   uint8 // unused bits of the last byte
+}
+
+func (b *bstream) writeBit(bit bool) {
+	if b.free == 0 {
+		b.stream = append(b.stream, 0)
+		b.free = 8
+	}
+
+	if bit {
+		b.stream[len(b.stream)-1] |= 1 << (b.free - 1)
+	}
+
+	b.free--
+}
+
+func (b *bstream) writeByte(value byte) {
+	if b.free == 0 {
+		b.stream = append(b.stream, value)
+
+		return
+	}
+
+	// the high bits fill the last byte, the low ones start a new byte with as many bits free
+	b.stream[len(b.stream)-1] |= value >> (8 - b.free)
+	b.stream = append(b.stream, value<<b.free)
+}
+
+// writeBits writes the size low bits of value, the most significant first.
+func (b *bstream) writeBits(value uint64, size int) {
+	value <<= floatBits - size
+
+	for ; size >= 8; size -= 8 {
+		b.writeByte(byte(value >> (floatBits - 8)))
+		value <<= 8
+	}
+
+	for ; size > 0; size-- {
+		b.writeBit(value>>(floatBits-1) == 1)
+		value <<= 1
+	}
+}
+
+func (b *bstream) writeVarint(value int64) {
+	var buf [binary.MaxVarintLen64]byte
+
+	for _, byt := range buf[:binary.PutVarint(buf[:], value)] {
+		b.writeByte(byt)
+	}
+}
+
+type breader struct {
+	stream []byte
+	pos    int // bits read
+}
+
+func (r *breader) readBit() (bool, error) {
+	if r.pos >= len(r.stream)*8 {
+		return false, io.ErrUnexpectedEOF
+	}
+
+	bit := r.stream[r.pos/8]>>(7-r.pos%8)&1 == 1
+	r.pos++
+
+	return bit, nil
+}
+
+func (r *breader) readBits(size int) (uint64, error) {
+	if r.pos+size > len(r.stream)*8 {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	var value uint64
+
+	for size > 0 {
+		offset := r.pos % 8
+		take := min(8-offset, size)
+
+		byt := r.stream[r.pos/8] << offset >> (8 - take)
+		value = value<<take | uint64(byt)
+
+		r.pos += take
+		size -= take
+	}
+
+	return value, nil
+}
+
+func (r *breader) readVarint() (int64, error) {
+	var buf [binary.MaxVarintLen64]byte
+
+	for i := range buf {
+		byt, err := r.readBits(8)
+		if err != nil {
+			return 0, err
+		}
+
+		buf[i] = byte(byt)
+
+		if byt&0x80 == 0 { // no continuation bit
+			value, n := binary.Varint(buf[:i+1])
+			if n <= 0 {
+				return 0, ErrCorruptedChunk
+			}
+
+			return value, nil
+		}
+	}
+
+	return 0, ErrCorruptedChunk
+}
@@ -0,0 +1,192 @@
+package store_test
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"metrics/internal/consumer/internal/service"
+	"metrics/internal/consumer/internal/store"
+)
+
+type sample struct {
+	t     int64
+	value float64
+}
+
+func TestChunkFloat(t *testing.T) {
+	t.Parallel()
+
+	start := int64(1_700_000_000_000)
+	random := rand.New(rand.NewPCG(1, 2)) //nolint:gosec // test data
+
+	tests := []struct {
+		name    string
+		samples func(i int) sample
+	}{
+		{
+			name:    "regular interval, same value",
+			samples: func(i int) sample { return sample{t: start + int64(i)*2000, value: 42} },
+		},
+		{
+			name:    "random walk",
+			samples: func(i int) sample { return sample{t: start + int64(i)*2000, value: float64(i) + random.Float64()} },
+		},
+		{
+			name: "irregular timestamps",
+			samples: func(i int) sample {
+				return sample{t: start + int64(i)*2000 + random.Int64N(100), value: random.NormFloat64()}
+			},
+		},
+		{
+			name: "timestamps far apart and back in time",
+			samples: func(i int) sample {
+				gaps := []int64{0, 1, 1 << 20, -5, 1 << 40, 7}
+				return sample{t: start + gaps[i%len(gaps)]*int64(i), value: -float64(i)}
+			},
+		},
+		{
+			name: "special values",
+			samples: func(i int) sample {
+				values := []float64{0, math.Inf(1), math.Inf(-1), math.MaxFloat64, math.SmallestNonzeroFloat64, -0.5}
+				return sample{t: start + int64(i), value: values[i%len(values)]}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunk := store.NewChunk(store.EncodingXOR)
+
+			var samples []sample
+
+			for i := range 120 {
+				s := tt.samples(i)
+				samples = append(samples, s)
+				chunk.AppendFloat(s.t, s.value)
+			}
+
+			require.Equal(t, len(samples), chunk.NumSamples())
+
+			it := chunk.Iterator()
+
+			for _, want := range samples {
+				require.True(t, it.Next())
+
+				ts, value := it.At()
+				assert.Equal(t, want.t, ts)
+				assert.Equal(t, math.Float64bits(want.value), math.Float64bits(value))
+			}
+
+			assert.False(t, it.Next())
+			require.NoError(t, it.Err())
+		})
+	}
+}
+
+func TestChunkInt(t *testing.T) {
+	t.Parallel()
+
+	chunk := store.NewChunk(store.EncodingVarint)
+	values := []int64{5, 5, 6, 100, -3, math.MaxInt64, math.MinInt64, 0}
+
+	for i, value := range values {
+		chunk.AppendInt(int64(i)*1000, value)
+	}
+
+	it := chunk.Iterator()
+
+	for i, want := range values {
+		require.True(t, it.Next())
+
+		ts, value := it.AtInt()
+		assert.Equal(t, int64(i)*1000, ts)
+		assert.Equal(t, want, value)
+	}
+
+	assert.False(t, it.Next())
+	require.NoError(t, it.Err())
+}
+
+func TestChunkCompression(t *testing.T) {
+	t.Parallel()
+
+	// a gauge polled every 2 seconds that rarely changes takes a couple of bits per sample
+	chunk := store.NewChunk(store.EncodingXOR)
+	for i := range 120 {
+		chunk.AppendFloat(int64(i)*2000, float64(i/10))
+	}
+
+	assert.Less(t, len(chunk.Bytes()), 120)
+}
+
+// benchmarkChunk reports the bytes per sample of the chunks and of the JSON lines FileStore writes.
+func benchmarkChunk(b *testing.B, metricType string, next func(i int) float64) {
+	b.Helper()
+
+	const samples = 120
+
+	var chunkBytes, jsonBytes int
+
+	for range b.N {
+		chunkBytes, jsonBytes = 0, 0
+
+		encoding := store.EncodingXOR
+		if metricType == service.MetricCounter {
+			encoding = store.EncodingVarint
+		}
+
+		chunk := store.NewChunk(encoding)
+
+		for i := range samples {
+			t := 1_700_000_000_000 + int64(i)*2000
+			metric := service.Metric{ID: "Alloc", MetricType: metricType, Value: nil, Delta: nil}
+
+			if metricType == service.MetricCounter {
+				delta := int64(next(i))
+				chunk.AppendInt(t, delta)
+				metric.Delta = &delta
+			} else {
+				value := next(i)
+				chunk.AppendFloat(t, value)
+				metric.Value = &value
+			}
+
+			record, err := json.Marshal(metric)
+			require.NoError(b, err)
+
+			jsonBytes += len(record) + 1
+		}
+
+		chunkBytes = len(chunk.Bytes())
+	}
+
+	b.ReportMetric(float64(chunkBytes)/samples, "chunk-bytes/sample")
+	b.ReportMetric(float64(jsonBytes)/samples, "json-bytes/sample")
+}
+
+func BenchmarkChunkGauge(b *testing.B) {
+	random := rand.New(rand.NewPCG(1, 2)) //nolint:gosec // test data
+
+	benchmarkChunk(b, service.MetricGauge, func(_ int) float64 {
+		return float64(1<<20) + float64(random.IntN(1<<10))
+	})
+}
+
+func BenchmarkChunkGaugeRandom(b *testing.B) {
+	random := rand.New(rand.NewPCG(1, 2)) //nolint:gosec // test data
+
+	benchmarkChunk(b, service.MetricGauge, func(_ int) float64 {
+		return random.Float64()
+	})
+}
+
+func BenchmarkChunkCounter(b *testing.B) {
+	benchmarkChunk(b, service.MetricCounter, func(i int) float64 {
+		return float64(i * 29)
+	})
+}
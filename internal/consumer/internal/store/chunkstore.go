@@ -0,0 +1,780 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"metrics/internal/consumer/internal/service"
+	"metrics/internal/log"
+)
+
+const (
+	chunksFile   = "chunks"
+	headsFile    = "heads"
+	sketchesFile = "sketches.json"
+
+	// a chunk record is framed by its length before and its CRC-32 after
+	recordLengthSize = 4
+	recordCRCSize    = 4
+
+	// the heads file starts with the size of the chunks file when the heads were flushed
+	headsOffsetSize = 8
+
+	defaultChunkFlushInterval = 10 * time.Second
+)
+
+// errTornRecord is the last record of the chunks file cut short by a crash.
+var errTornRecord = errors.New("torn chunk record")
+
+// ChunkStore keeps every sample of the gauges and counters, compressed into chunks of chunkSamples samples.
+// A full chunk is appended to dir/chunks. Every flush interval the chunks still being filled are written
+// to dir/heads and the histograms and summaries, which keep their last value only, to dir/sketches.json,
+// so a crash loses the updates of the last interval at most. Close writes the chunks being filled to dir/chunks.
+// The chunks are neither compacted nor expired, dir/chunks keeps growing for as long as the store is used.
+type ChunkStore struct {
+	dir           string
+	file          *os.File
+	size          int64 // of the chunks file
+	headsDirty    bool
+	sketchesDirty bool
+	mu            sync.Mutex
+	series        map[string]*series
+	sketches      *MemoryStore // used under mu only
+	interval      time.Duration
+	done          chan struct{}
+	wg            sync.WaitGroup
+}
+
+// series holds the samples of a gauge or a counter. The head chunk takes the new samples,
+// a metric changing its type starts a new one.
+type series struct {
+	metric service.Metric // the last sample
+	stale  bool           // the metric is a histogram or a summary now
+	chunks []*Chunk
+	head   *Chunk
+}
+
+// NewChunkStore opens the chunk store in dir, flushing it every flushInterval, a zero one flushes every 10 seconds.
+func NewChunkStore(dir string, flushInterval time.Duration) (*ChunkStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create dir %s error: %w", dir, err)
+	}
+
+	if flushInterval <= 0 {
+		flushInterval = defaultChunkFlushInterval
+	}
+
+	chunkStore := &ChunkStore{
+		dir:           dir,
+		file:          nil,
+		size:          0,
+		headsDirty:    false,
+		sketchesDirty: false,
+		mu:            sync.Mutex{},
+		series:        map[string]*series{},
+		sketches:      &MemoryStore{memory: map[string]service.Metric{}, mu: sync.Mutex{}},
+		interval:      flushInterval,
+		done:          make(chan struct{}),
+		wg:            sync.WaitGroup{},
+	}
+
+	sketches, err := readSnapshot(filepath.Join(dir, sketchesFile), 0)
+	if err != nil {
+		return nil, fmt.Errorf("restore File %s error: %w", filepath.Join(dir, sketchesFile), err)
+	}
+
+	for _, metric := range sketches {
+		chunkStore.sketches.memory[metric.Key()] = metric
+	}
+
+	if err = chunkStore.load(); err != nil {
+		return nil, err
+	}
+
+	for key, current := range chunkStore.series {
+		_, current.stale = chunkStore.sketches.memory[key]
+	}
+
+	chunkStore.wg.Add(1)
+
+	go chunkStore.background()
+
+	return chunkStore, nil
+}
+
+// load reads the chunks written before, cuts off a torn last record and restores the flushed heads.
+func (c *ChunkStore) load() error {
+	path := filepath.Join(c.dir, chunksFile)
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return fmt.Errorf("open File %s error: %w", path, err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		_ = file.Close()
+
+		return fmt.Errorf("read File %s error: %w", path, err)
+	}
+
+	size, last, err := c.readChunks(data)
+	if err != nil {
+		_ = file.Close()
+
+		return fmt.Errorf("read File %s error: %w", path, err)
+	}
+
+	if size != int64(len(data)) {
+		log.Warn("torn chunk record truncated",
+			log.StringAttr("file", path),
+			log.Int64Attr("size", size))
+
+		if err = file.Truncate(size); err != nil {
+			_ = file.Close()
+
+			return fmt.Errorf("truncate File %s error: %w", path, err)
+		}
+	}
+
+	if err = c.loadHeads(last); err != nil {
+		_ = file.Close()
+
+		return err
+	}
+
+	c.file = file
+	c.size = size
+
+	return nil
+}
+
+// readChunks adds the chunk records of data to the series and returns the size of the complete records
+// and the offset of the last record of every series. Only the last record may be broken,
+// as it is the one a crash tears.
+func (c *ChunkStore) readChunks(data []byte) (int64, map[string]int64, error) {
+	var size int64
+
+	last := map[string]int64{}
+
+	for len(data) != 0 {
+		payload, recordSize, err := nextRecord(data)
+		if errors.Is(err, errTornRecord) {
+			return size, last, nil
+		}
+
+		if err != nil {
+			return 0, nil, fmt.Errorf("record at %d: %w", size, err)
+		}
+
+		metric, chunk, err := readChunk(payload)
+		if err != nil {
+			return 0, nil, fmt.Errorf("record at %d: %w", size, err)
+		}
+
+		current := c.seriesOf(metric)
+		current.chunks = append(current.chunks, chunk)
+		last[metric.Key()] = size
+
+		data = data[recordSize:]
+		size += int64(recordSize)
+	}
+
+	return size, last, nil
+}
+
+// loadHeads makes the chunks flushed to dir/heads the heads of their series again. A series with a record
+// at or after the offset of the flush closed its head since, the samples of the head are in that chunk.
+func (c *ChunkStore) loadHeads(last map[string]int64) error {
+	path := filepath.Join(c.dir, headsFile)
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("read File %s error: %w", path, err)
+	}
+
+	// the heads are written at once, a broken record is no crash
+	if len(data) < headsOffsetSize {
+		return fmt.Errorf("read File %s error: %w", path, ErrCorruptedChunk)
+	}
+
+	offset := int64(binary.BigEndian.Uint64(data))
+
+	for data = data[headsOffsetSize:]; len(data) != 0; {
+		payload, recordSize, err := nextRecord(data)
+		if err != nil {
+			return fmt.Errorf("read File %s error: %w", path, ErrCorruptedChunk)
+		}
+
+		metric, chunk, err := readChunk(payload)
+		if err != nil {
+			return fmt.Errorf("read File %s error: %w", path, err)
+		}
+
+		data = data[recordSize:]
+
+		if closed, ok := last[metric.Key()]; ok && closed >= offset {
+			continue
+		}
+
+		head, err := reopenChunk(chunk)
+		if err != nil {
+			return fmt.Errorf("read File %s error: %w", path, err)
+		}
+
+		current := c.seriesOf(metric)
+		current.head = head
+	}
+
+	return nil
+}
+
+// nextRecord returns the payload of the first record of data and the size of the record.
+// A record cut short or with a bad checksum at the end of data is torn, a bad one before others is corrupted.
+func nextRecord(data []byte) ([]byte, int, error) {
+	if len(data) < recordLengthSize+recordCRCSize {
+		return nil, 0, errTornRecord
+	}
+
+	length := int(binary.BigEndian.Uint32(data))
+	if length > len(data)-recordLengthSize-recordCRCSize {
+		return nil, 0, errTornRecord
+	}
+
+	payload := data[recordLengthSize : recordLengthSize+length]
+	checksum := binary.BigEndian.Uint32(data[recordLengthSize+length:])
+	recordSize := recordLengthSize + length + recordCRCSize
+
+	if crc32.ChecksumIEEE(payload) != checksum {
+		if recordSize == len(data) {
+			return nil, 0, errTornRecord
+		}
+
+		return nil, 0, ErrCorruptedChunk
+	}
+
+	return payload, recordSize, nil
+}
+
+// seriesOf returns the series of the metric, made the last sample of it.
+func (c *ChunkStore) seriesOf(metric service.Metric) *series {
+	key := metric.Key()
+
+	current, ok := c.series[key]
+	if !ok {
+		current = &series{metric: metric, stale: false, chunks: nil, head: nil}
+		c.series[key] = current
+	}
+
+	current.metric = metric
+
+	return current
+}
+
+// readChunk decodes a record payload: the metric name and its labels as JSON, both prefixed
+// with their uvarint length, the chunk encoding, the uvarint number of samples and the chunk bytes.
+// The metric has the last sample of the chunk.
+func readChunk(payload []byte) (service.Metric, *Chunk, error) {
+	reader := bytes.NewReader(payload)
+
+	id, err := readBytes(reader)
+	if err != nil {
+		return service.Metric{}, nil, err
+	}
+
+	labelsJSON, err := readBytes(reader)
+	if err != nil {
+		return service.Metric{}, nil, err
+	}
+
+	labels, err := decodeLabels(labelsJSON)
+	if err != nil {
+		return service.Metric{}, nil, fmt.Errorf("%w: %w", ErrCorruptedChunk, err)
+	}
+
+	encoding, err := reader.ReadByte()
+	if err != nil {
+		return service.Metric{}, nil, ErrCorruptedChunk
+	}
+
+	count, err := binary.ReadUvarint(reader)
+	if err != nil || count == 0 {
+		return service.Metric{}, nil, ErrCorruptedChunk
+	}
+
+	chunk, err := loadChunk(ChunkEncoding(encoding), int(count), payload[len(payload)-reader.Len():])
+	if err != nil {
+		return service.Metric{}, nil, err
+	}
+
+	metric := service.Metric{
+		ID:         string(id),
+		MetricType: service.MetricGauge,
+		Delta:      nil,
+		Value:      nil,
+		Histogram:  nil,
+		Summary:    nil,
+		Labels:     labels,
+		Timestamp:  time.Time{},
+	}
+
+	// the last sample is the value of the metric
+	var delta int64
+	var value float64
+
+	it := chunk.Iterator()
+	for it.Next() {
+		_, delta = it.AtInt()
+		_, value = it.At()
+	}
+
+	if it.Err() != nil {
+		return service.Metric{}, nil, it.Err()
+	}
+
+	if chunk.Encoding() == EncodingVarint {
+		metric.MetricType = service.MetricCounter
+		metric.Delta = &delta
+	} else {
+		metric.Value = &value
+	}
+
+	return metric, chunk, nil
+}
+
+// reopenChunk appends the samples of a loaded chunk to a new one, which takes further samples.
+func reopenChunk(chunk *Chunk) (*Chunk, error) {
+	head := NewChunk(chunk.Encoding())
+
+	it := chunk.Iterator()
+	for it.Next() {
+		if chunk.Encoding() == EncodingVarint {
+			head.AppendInt(it.AtInt())
+		} else {
+			head.AppendFloat(it.At())
+		}
+	}
+
+	if it.Err() != nil {
+		return nil, it.Err()
+	}
+
+	return head, nil
+}
+
+func readBytes(reader *bytes.Reader) ([]byte, error) {
+	length, err := binary.ReadUvarint(reader)
+	if err != nil || length > uint64(reader.Len()) {
+		return nil, ErrCorruptedChunk
+	}
+
+	data := make([]byte, length)
+	_, _ = reader.Read(data) // the length is checked
+
+	return data, nil
+}
+
+func (c *ChunkStore) AddGauge(gauge service.Metric) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.writeChunks(c.addSample(gauge, false, time.Now()))
+}
+
+func (c *ChunkStore) AddCounter(counter service.Metric, increment bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.writeChunks(c.addSample(counter, increment, time.Now()))
+}
+
+func (c *ChunkStore) AddHistogram(histogram service.Metric, increment bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.sketches.addHistogram(histogram, increment); err != nil {
+		return err
+	}
+
+	c.replaceSeries(histogram.Key())
+	c.sketchesDirty = true
+
+	return nil
+}
+
+func (c *ChunkStore) AddSummary(summary service.Metric, increment bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sketches.addSummary(summary, increment)
+	c.replaceSeries(summary.Key())
+	c.sketchesDirty = true
+
+	return nil
+}
+
+// AddMetrics applies the whole batch under a single lock and writes the chunks it fills with a single write.
+func (c *ChunkStore) AddMetrics(metrics []service.Metric) error {
+	for _, metric := range metrics {
+		switch metric.MetricType {
+		case service.MetricCounter, service.MetricGauge, service.MetricHistogram, service.MetricSummary:
+		default:
+			return fmt.Errorf("metric type: %s, %w", metric.MetricType, service.ErrUnknownMetricType)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.sketches.checkBuckets(metrics); err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	var full []chunkRecord
+
+	for _, metric := range metrics {
+		switch metric.MetricType {
+		case service.MetricCounter:
+			full = append(full, c.addSample(metric, true, now)...)
+		case service.MetricGauge:
+			full = append(full, c.addSample(metric, false, now)...)
+		case service.MetricHistogram:
+			_ = c.sketches.addHistogram(metric, true) // buckets are checked
+			c.replaceSeries(metric.Key())
+			c.sketchesDirty = true
+		case service.MetricSummary:
+			c.sketches.addSummary(metric, true)
+			c.replaceSeries(metric.Key())
+			c.sketchesDirty = true
+		}
+	}
+
+	return c.writeChunks(full)
+}
+
+// chunkRecord is a chunk to be written with the metric it belongs to.
+type chunkRecord struct {
+	metric service.Metric
+	chunk  *Chunk
+}
+
+// addSample appends the gauge value or the counter total to the head chunk of the metric
+// and returns the chunks closed by it. It leaves the counter total in counter.Delta.
+func (c *ChunkStore) addSample(metric service.Metric, increment bool, at time.Time) []chunkRecord {
+	key := metric.Key()
+
+	if _, ok := c.sketches.memory[key]; ok {
+		delete(c.sketches.memory, key)
+		c.sketchesDirty = true
+	}
+
+	current, ok := c.series[key]
+	if !ok {
+		current = &series{metric: metric, stale: false, chunks: nil, head: nil}
+		c.series[key] = current
+	}
+
+	if metric.MetricType == service.MetricCounter && ok && !current.stale && current.metric.Delta != nil && increment {
+		*metric.Delta += *current.metric.Delta
+	}
+
+	var closed []chunkRecord
+
+	encoding := EncodingXOR
+	if metric.MetricType == service.MetricCounter {
+		encoding = EncodingVarint
+	}
+
+	if current.head != nil && (current.head.isFull() || current.head.Encoding() != encoding) {
+		closed = append(closed, chunkRecord{metric: current.metric, chunk: current.head})
+		current.chunks = append(current.chunks, current.head)
+		current.head = nil
+	}
+
+	if current.head == nil {
+		current.head = NewChunk(encoding)
+	}
+
+	at = metric.ObservedAt(at)
+
+	if encoding == EncodingVarint {
+		current.head.AppendInt(at.UnixMilli(), *metric.Delta)
+	} else {
+		current.head.AppendFloat(at.UnixMilli(), *metric.Value)
+	}
+
+	current.metric = metric
+	current.stale = false
+	c.headsDirty = true
+
+	return closed
+}
+
+// replaceSeries keeps the samples of a gauge or a counter that became a histogram or a summary for Range.
+func (c *ChunkStore) replaceSeries(key string) {
+	if current, ok := c.series[key]; ok {
+		current.stale = true
+	}
+}
+
+func (c *ChunkStore) writeChunks(records []chunkRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	var data []byte
+
+	for _, record := range records {
+		data = appendChunkRecord(data, record)
+	}
+
+	n, err := c.file.Write(data)
+	c.size += int64(n)
+
+	if err != nil {
+		return fmt.Errorf("write File %s error: %w", c.file.Name(), err)
+	}
+
+	return nil
+}
+
+func appendChunkRecord(data []byte, record chunkRecord) []byte {
+	labels := encodeLabels(record.metric.Labels)
+
+	payload := binary.AppendUvarint(nil, uint64(len(record.metric.ID)))
+	payload = append(payload, record.metric.ID...)
+	payload = binary.AppendUvarint(payload, uint64(len(labels)))
+	payload = append(payload, labels...)
+	payload = append(payload, byte(record.chunk.Encoding()))
+	payload = binary.AppendUvarint(payload, uint64(record.chunk.NumSamples()))
+	payload = append(payload, record.chunk.Bytes()...)
+
+	data = binary.BigEndian.AppendUint32(data, uint32(len(payload)))
+	data = append(data, payload...)
+
+	return binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(payload))
+}
+
+func (c *ChunkStore) GetMetric(key string) (service.Metric, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if current, ok := c.series[key]; ok && !current.stale {
+		return current.metric, nil
+	}
+
+	if metric, ok := c.sketches.memory[key]; ok {
+		return metric, nil
+	}
+
+	return service.Metric{}, service.ErrMetricNotFound
+}
+
+func (c *ChunkStore) GetAllMetrics() []service.Metric {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	metrics := make([]service.Metric, 0, len(c.series)+len(c.sketches.memory))
+
+	for _, current := range c.series {
+		if !current.stale {
+			metrics = append(metrics, current.metric)
+		}
+	}
+
+	for _, metric := range c.sketches.memory {
+		metrics = append(metrics, metric)
+	}
+
+	return metrics
+}
+
+// Range returns every sample of the gauge or the counter between from and to.
+func (c *ChunkStore) Range(key string, from, to time.Time) []service.Point {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	points := []service.Point{}
+
+	current, ok := c.series[key]
+	if !ok {
+		return points
+	}
+
+	chunks := current.chunks
+	if current.head != nil {
+		chunks = append(chunks[:len(chunks):len(chunks)], current.head)
+	}
+
+	for _, chunk := range chunks {
+		it := chunk.Iterator()
+
+		for it.Next() {
+			point := service.Point{Time: time.Time{}, Delta: nil, Value: nil}
+
+			var t int64
+
+			if chunk.Encoding() == EncodingVarint {
+				var delta int64
+				t, delta = it.AtInt()
+				point.Delta = &delta
+			} else {
+				var value float64
+				t, value = it.At()
+				point.Value = &value
+			}
+
+			point.Time = time.UnixMilli(t)
+
+			if !point.Time.Before(from) && !point.Time.After(to) {
+				points = append(points, point)
+			}
+		}
+
+		if it.Err() != nil {
+			log.Error("read chunk error",
+				log.StringAttr("name", key),
+				log.ErrAttr(it.Err()))
+		}
+	}
+
+	// a write may tell the times of the samples out of order
+	slices.SortStableFunc(points, func(a, b service.Point) int {
+		return a.Time.Compare(b.Time)
+	})
+
+	return points
+}
+
+// Flush writes the chunks being filled to dir/heads and the histograms and summaries to dir/sketches.json,
+// those that changed since the last flush. The heads tell the size of dir/chunks, synced before them.
+func (c *ChunkStore) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.headsDirty {
+		if err := c.file.Sync(); err != nil {
+			return fmt.Errorf("sync File %s error: %w", c.file.Name(), err)
+		}
+
+		data := binary.BigEndian.AppendUint64(nil, uint64(c.size))
+
+		for _, current := range c.series {
+			if current.head != nil {
+				data = appendChunkRecord(data, chunkRecord{metric: current.metric, chunk: current.head})
+			}
+		}
+
+		if _, err := writeFile(filepath.Join(c.dir, headsFile), data); err != nil {
+			return err
+		}
+
+		c.headsDirty = false
+	}
+
+	if c.sketchesDirty {
+		if err := c.writeSketches(); err != nil {
+			return err
+		}
+
+		c.sketchesDirty = false
+	}
+
+	return nil
+}
+
+func (c *ChunkStore) background() {
+	defer c.wg.Done()
+
+	tickFlush := time.NewTicker(c.interval)
+	defer tickFlush.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-tickFlush.C:
+			if err := c.Flush(); err != nil {
+				log.Error("flush chunk store error",
+					log.ErrAttr(err))
+			}
+		}
+	}
+}
+
+func (c *ChunkStore) writeSketches() error {
+	sketches := make([]service.Metric, 0, len(c.sketches.memory))
+	for _, metric := range c.sketches.memory {
+		sketches = append(sketches, metric)
+	}
+
+	if _, err := writeSnapshot(filepath.Join(c.dir, sketchesFile), 0, sketches); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Close writes the chunks being filled and the histograms and summaries.
+func (c *ChunkStore) Close() {
+	close(c.done)
+	c.wg.Wait()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var heads []chunkRecord
+
+	for _, current := range c.series {
+		if current.head != nil {
+			heads = append(heads, chunkRecord{metric: current.metric, chunk: current.head})
+			current.chunks = append(current.chunks, current.head)
+			current.head = nil
+		}
+	}
+
+	if err := c.writeChunks(heads); err != nil {
+		log.Error("write chunks error",
+			log.ErrAttr(err))
+	}
+
+	if err := c.file.Sync(); err != nil {
+		log.Error("sync file error",
+			log.StringAttr("file", c.file.Name()),
+			log.ErrAttr(err))
+	}
+
+	// the heads are in the chunks now
+	if err := os.Remove(filepath.Join(c.dir, headsFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Error("remove file error",
+			log.StringAttr("file", filepath.Join(c.dir, headsFile)),
+			log.ErrAttr(err))
+	}
+
+	if err := c.file.Close(); err != nil {
+		log.Error("close file error",
+			log.StringAttr("file", c.file.Name()),
+			log.ErrAttr(err))
+	}
+
+	if err := c.writeSketches(); err != nil {
+		log.Error("write sketches error",
+			log.ErrAttr(err))
+	}
+}
+
+var _ service.RangeStore = (*ChunkStore)(nil)
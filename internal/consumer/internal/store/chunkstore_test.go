@@ -0,0 +1,198 @@
+package store_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"metrics/internal/consumer/internal/service"
+	"metrics/internal/consumer/internal/store"
+)
+
+func TestChunkStore(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	db, err := store.NewChunkStore(dir, time.Hour)
+	require.NoError(t, err)
+
+	from := time.Now().Add(-time.Second)
+
+	// more than a chunk, so a full one is written before Close
+	for i := range 150 {
+		require.NoError(t, db.AddGauge(service.Metric{ID: "Alloc", MetricType: service.MetricGauge, Value: ptr(float64(i)), Delta: nil}))
+	}
+
+	counter := service.Metric{ID: "PollCount", MetricType: service.MetricCounter, Value: nil, Delta: ptr(int64(3))}
+	require.NoError(t, db.AddCounter(counter, true))
+	require.NoError(t, db.AddMetrics([]service.Metric{
+		{ID: "PollCount", MetricType: service.MetricCounter, Value: nil, Delta: ptr(int64(4))},
+		{ID: "Alloc", MetricType: service.MetricGauge, Value: ptr(0.5), Delta: nil, Labels: service.Labels{"host": "a"}},
+		{ID: "Latency", MetricType: service.MetricHistogram, Histogram: &service.Histogram{Bounds: []float64{1}, Counts: []uint64{1, 0}, Sum: 0.5, Count: 1}},
+	}))
+
+	metric, err := db.GetMetric("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), *metric.Delta)
+
+	info, err := os.Stat(filepath.Join(dir, "chunks"))
+	require.NoError(t, err)
+	assert.NotZero(t, info.Size())
+
+	db.Close()
+
+	db, err = store.NewChunkStore(dir, time.Hour)
+	require.NoError(t, err)
+
+	t.Cleanup(db.Close)
+
+	metric, err = db.GetMetric("Alloc")
+	require.NoError(t, err)
+	assert.InDelta(t, 149, *metric.Value, 0)
+
+	metric, err = db.GetMetric(service.Metric{ID: "Alloc", Labels: service.Labels{"host": "a"}}.Key()) //nolint:exhaustruct // key only
+	require.NoError(t, err)
+	assert.InDelta(t, 0.5, *metric.Value, 0)
+
+	metric, err = db.GetMetric("Latency")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), metric.Histogram.Count)
+
+	assert.Len(t, db.GetAllMetrics(), 4)
+
+	// the counter goes on from the stored total
+	require.NoError(t, db.AddCounter(service.Metric{ID: "PollCount", MetricType: service.MetricCounter, Value: nil, Delta: ptr(int64(1))}, true))
+
+	to := time.Now().Add(time.Second)
+
+	points := db.Range("Alloc", from, to)
+	require.Len(t, points, 150)
+
+	for i, point := range points {
+		assert.InDelta(t, float64(i), *point.Value, 0)
+	}
+
+	points = db.Range("PollCount", from, to)
+	require.Len(t, points, 3)
+	assert.Equal(t, int64(3), *points[0].Delta)
+	assert.Equal(t, int64(7), *points[1].Delta)
+	assert.Equal(t, int64(8), *points[2].Delta)
+
+	assert.Empty(t, db.Range("Alloc", to, to.Add(time.Minute)))
+	assert.Empty(t, db.Range("Latency", from, to))
+}
+
+func TestChunkStoreFlush(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	db, err := store.NewChunkStore(dir, time.Hour)
+	require.NoError(t, err)
+
+	from := time.Now().Add(-time.Second)
+
+	for i := range 10 {
+		require.NoError(t, db.AddGauge(service.Metric{ID: "Alloc", MetricType: service.MetricGauge, Value: ptr(float64(i)), Delta: nil}))
+	}
+
+	require.NoError(t, db.AddCounter(service.Metric{ID: "PollCount", MetricType: service.MetricCounter, Value: nil, Delta: ptr(int64(5))}, true))
+	require.NoError(t, db.AddHistogram(service.Metric{ID: "Latency", MetricType: service.MetricHistogram, Histogram: &service.Histogram{Bounds: []float64{1}, Counts: []uint64{1, 0}, Sum: 0.5, Count: 1}}, true))
+	require.NoError(t, db.Flush())
+
+	// the flushed head of Alloc is closed into a chunk after the flush, the samples after it are lost
+	for i := 10; i < 125; i++ {
+		require.NoError(t, db.AddGauge(service.Metric{ID: "Alloc", MetricType: service.MetricGauge, Value: ptr(float64(i)), Delta: nil}))
+	}
+
+	require.NoError(t, db.AddCounter(service.Metric{ID: "PollCount", MetricType: service.MetricCounter, Value: nil, Delta: ptr(int64(1))}, true))
+
+	// a crash, the store is not closed
+	db, err = store.NewChunkStore(dir, time.Hour)
+	require.NoError(t, err)
+
+	to := time.Now().Add(time.Second)
+
+	points := db.Range("Alloc", from, to)
+	require.Len(t, points, 120)
+
+	for i, point := range points {
+		assert.InDelta(t, float64(i), *point.Value, 0)
+	}
+
+	metric, err := db.GetMetric("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), *metric.Delta)
+
+	metric, err = db.GetMetric("Latency")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), metric.Histogram.Count)
+
+	// the restored head takes new samples
+	require.NoError(t, db.AddCounter(service.Metric{ID: "PollCount", MetricType: service.MetricCounter, Value: nil, Delta: ptr(int64(2))}, true))
+	db.Close()
+
+	_, err = os.Stat(filepath.Join(dir, "heads"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	db, err = store.NewChunkStore(dir, time.Hour)
+	require.NoError(t, err)
+
+	t.Cleanup(db.Close)
+
+	points = db.Range("PollCount", from, time.Now().Add(time.Second))
+	require.Len(t, points, 2)
+	assert.Equal(t, int64(5), *points[0].Delta)
+	assert.Equal(t, int64(7), *points[1].Delta)
+}
+
+func TestChunkStoreTornRecord(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	db, err := store.NewChunkStore(dir, time.Hour)
+	require.NoError(t, err)
+
+	require.NoError(t, db.AddGauge(service.Metric{ID: "Alloc", MetricType: service.MetricGauge, Value: ptr(1.5), Delta: nil}))
+	db.Close()
+
+	path := filepath.Join(dir, "chunks")
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	// a crash in the middle of the next record
+	require.NoError(t, os.WriteFile(path, append(data, data[:len(data)-3]...), 0600))
+
+	db, err = store.NewChunkStore(dir, time.Hour)
+	require.NoError(t, err)
+
+	metric, err := db.GetMetric("Alloc")
+	require.NoError(t, err)
+	assert.InDelta(t, 1.5, *metric.Value, 0)
+
+	db.Close()
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), info.Size())
+
+	// a broken record followed by a good one is not a crash
+	data[len(data)-1] ^= 1
+	require.NoError(t, os.WriteFile(path, append(data, data...), 0600))
+
+	_, err = store.NewChunkStore(dir, time.Hour)
+	require.ErrorIs(t, err, store.ErrCorruptedChunk)
+}
@@ -16,6 +16,18 @@ func (*DummyStore) AddCounter(_ service.Metric, _ bool) error {
 	return nil
 }
 
+func (*DummyStore) AddHistogram(_ service.Metric, _ bool) error {
+	return nil
+}
+
+func (*DummyStore) AddSummary(_ service.Metric, _ bool) error {
+	return nil
+}
+
+func (*DummyStore) AddMetrics(_ []service.Metric) error {
+	return nil
+}
+
 func (*DummyStore) GetMetric(_ string) (service.Metric, error) {
 	return service.Metric{}, nil //nolint:exhaustruct // empty
 }
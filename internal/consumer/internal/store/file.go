@@ -1,136 +1,310 @@
 package store
 
 import (
-	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"sync"
+	"time"
 
 	"metrics/config"
 	"metrics/internal/consumer/internal/service"
 	"metrics/internal/log"
 )
 
+const (
+	WALSyncAlways   = "always"
+	WALSyncInterval = "interval"
+	WALSyncNever    = "never"
+
+	defaultWALSyncInterval = time.Second
+	// minCompactBase keeps a tiny snapshot from triggering compaction on every few writes.
+	minCompactBase = 64 << 10
+)
+
+// FileStore keeps the metrics in memory and appends every change to a write-ahead log,
+// which is compacted into the snapshot in the background once it grows too large.
 type FileStore struct {
-	file    *os.File
-	encoder *json.Encoder
 	*MemoryStore
+	cfg          config.Store
+	wal          *os.File
+	walSize      int64
+	snapshotSize int64
+	dirty        bool
+	// mu orders the log the same way as the memory and guards the fields above.
+	mu      sync.Mutex
+	compact chan struct{}
+	done    chan struct{}
+	wg      sync.WaitGroup
 }
 
 func NewFileStore(cfg config.Store) (*FileStore, error) {
-	file, err := os.OpenFile(cfg.FileStoragePath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
-	if err != nil {
-		return nil, fmt.Errorf("open File %s error: %w", cfg.FileStoragePath, err)
+	memoryStore := &MemoryStore{
+		memory: map[string]service.Metric{},
+		mu:     sync.Mutex{},
 	}
 
-	fileInfo, err := file.Stat()
+	walSize, err := restore(cfg, memoryStore)
 	if err != nil {
-		return nil, fmt.Errorf("get File %s stat error: %w", cfg.FileStoragePath, err)
+		return nil, fmt.Errorf("restore File %s error: %w", cfg.FileStoragePath, err)
 	}
 
-	memoryStore, err := NewMemoryStore(cfg)
+	path := walPath(cfg.FileStoragePath)
+
+	wal, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
-		return nil, fmt.Errorf("create memory store error: %w", err)
+		return nil, fmt.Errorf("open File %s error: %w", path, err)
 	}
 
-	fileStore := &FileStore{
-		file:        file,
-		encoder:     json.NewEncoder(file),
-		MemoryStore: memoryStore,
-	}
+	// cut off a torn last record, so the next one starts on its own line
+	if err = wal.Truncate(walSize); err != nil {
+		_ = wal.Close()
 
-	if !cfg.ShouldRestore {
-		if err = fileStore.Clear(); err != nil {
-			return nil, fmt.Errorf("clear File %s error: %w", cfg.FileStoragePath, err)
-		}
+		return nil, fmt.Errorf("truncate File %s error: %w", path, err)
 	}
 
-	if fileInfo.Size() != 0 {
-		scanner := bufio.NewScanner(file)
-
-		for scanner.Scan() {
-			data := scanner.Bytes()
+	var snapshotSize int64
+	if fileInfo, errStat := os.Stat(cfg.FileStoragePath); errStat == nil {
+		snapshotSize = fileInfo.Size()
+	}
 
-			var metric service.Metric
-			if err = json.Unmarshal(data, &metric); err != nil {
-				return nil, fmt.Errorf("unmarshal json error: %w", err)
-			}
+	fileStore := &FileStore{
+		MemoryStore:  memoryStore,
+		cfg:          cfg,
+		wal:          wal,
+		walSize:      walSize,
+		snapshotSize: snapshotSize,
+		dirty:        false,
+		mu:           sync.Mutex{},
+		compact:      make(chan struct{}, 1),
+		done:         make(chan struct{}),
+		wg:           sync.WaitGroup{},
+	}
 
-			switch metric.MetricType {
-			case service.MetricGauge:
-				_ = fileStore.MemoryStore.AddGauge(metric) // err nil
-			case service.MetricCounter:
-				_ = fileStore.MemoryStore.AddCounter(metric, false) // err nil
-			default:
-				return nil, fmt.Errorf("metric type: %s, %w", metric.MetricType, service.ErrUnknownMetricType)
-			}
-		}
+	fileStore.wg.Add(1)
 
-		if err = scanner.Err(); err != nil {
-			return nil, fmt.Errorf("scanner error: %w", err)
-		}
-	}
+	go fileStore.background()
 
 	return fileStore, nil
 }
 
 func (f *FileStore) AddGauge(gauge service.Metric) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
 	_ = f.MemoryStore.AddGauge(gauge) // err nil
 
-	if err := f.saveAllMetrics(); err != nil {
-		return fmt.Errorf("save all metrics error: %w", err)
+	return f.appendMetrics([]service.Metric{gauge})
+}
+
+func (f *FileStore) AddCounter(counter service.Metric, increment bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_ = f.MemoryStore.AddCounter(counter, increment) // err nil, counter.Delta is the total now
+
+	return f.appendMetrics([]service.Metric{counter})
+}
+
+func (f *FileStore) AddHistogram(histogram service.Metric, increment bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.MemoryStore.AddHistogram(histogram, increment); err != nil {
+		return fmt.Errorf("add histogram to memory error: %w", err)
 	}
 
-	return nil
+	return f.appendMetrics([]service.Metric{histogram})
 }
 
-func (f *FileStore) AddCounter(counter service.Metric, increment bool) error {
-	_ = f.MemoryStore.AddCounter(counter, increment) // err nil
+func (f *FileStore) AddSummary(summary service.Metric, increment bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
-	if err := f.saveAllMetrics(); err != nil {
-		return fmt.Errorf("save all metrics error: %w", err)
+	if err := f.MemoryStore.AddSummary(summary, increment); err != nil {
+		return fmt.Errorf("add summary to memory error: %w", err)
 	}
 
-	return nil
+	return f.appendMetrics([]service.Metric{summary})
 }
 
-func (f *FileStore) saveAllMetrics() error {
-	for _, metric := range f.memory {
-		if metric.MetricType != service.MetricCounter && metric.MetricType != service.MetricGauge {
-			log.Error("unknown metric type",
-				log.ErrAttr(service.ErrUnknownMetricType))
+func (f *FileStore) AddMetrics(metrics []service.Metric) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
-			continue
-		}
+	if err := f.MemoryStore.AddMetrics(metrics); err != nil {
+		return fmt.Errorf("add metrics to memory error: %w", err)
+	}
+
+	return f.appendMetrics(metrics)
+}
 
-		err := f.encoder.Encode(metric)
+// appendMetrics writes the records with a single write, so a crash tears at most the last one.
+func (f *FileStore) appendMetrics(metrics []service.Metric) error {
+	var data []byte
+
+	for _, metric := range metrics {
+		record, err := json.Marshal(metric)
 		if err != nil {
-			return fmt.Errorf("encode File %s error: %w", f.file.Name(), err)
+			return fmt.Errorf("encode File %s error: %w", f.wal.Name(), err)
+		}
+
+		data = append(data, record...)
+		data = append(data, '\n')
+	}
+
+	n, err := f.wal.Write(data)
+	f.walSize += int64(n)
+
+	if err != nil {
+		return fmt.Errorf("write File %s error: %w", f.wal.Name(), err)
+	}
+
+	switch f.cfg.WALSync {
+	case WALSyncAlways:
+		if err = f.wal.Sync(); err != nil {
+			return fmt.Errorf("sync File %s error: %w", f.wal.Name(), err)
+		}
+	case WALSyncNever:
+	default:
+		f.dirty = true
+	}
+
+	if f.shouldCompact() {
+		select {
+		case f.compact <- struct{}{}:
+		default:
 		}
 	}
 
 	return nil
 }
 
-func (f *FileStore) Close() {
-	err := f.file.Close()
-	if err != nil {
-		log.Error("close File %s error", f.file.Name(),
+func (f *FileStore) shouldCompact() bool {
+	if f.cfg.WALCompactSize > 0 && f.walSize >= f.cfg.WALCompactSize {
+		return true
+	}
+
+	return f.cfg.WALCompactRatio > 0 &&
+		float64(f.walSize) >= f.cfg.WALCompactRatio*float64(max(f.snapshotSize, minCompactBase))
+}
+
+func (f *FileStore) background() {
+	defer f.wg.Done()
+
+	syncInterval := f.cfg.WALSyncInterval
+	if syncInterval <= 0 {
+		syncInterval = defaultWALSyncInterval
+	}
+
+	tickSync := time.NewTicker(syncInterval)
+	defer tickSync.Stop()
+
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-tickSync.C:
+			f.sync()
+		case <-f.compact:
+			if err := f.Compact(); err != nil {
+				log.Error("compact file store error",
+					log.ErrAttr(err))
+			}
+		}
+	}
+}
+
+func (f *FileStore) sync() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.dirty {
+		return
+	}
+
+	if err := f.wal.Sync(); err != nil {
+		log.Error("sync file store error",
+			log.StringAttr("file", f.wal.Name()),
 			log.ErrAttr(err))
+
+		return
 	}
+
+	f.dirty = false
 }
 
-func (f *FileStore) Clear() error {
-	err := f.file.Truncate(0)
+// Compact writes the current metrics into the snapshot and keeps only the log written meanwhile.
+// Writers are blocked only while the log tail is moved, not while the snapshot is written.
+func (f *FileStore) Compact() error {
+	f.mu.Lock()
+	metrics := f.MemoryStore.GetAllMetrics()
+	offset := f.walSize
+	f.mu.Unlock()
+
+	// a crash from here on is harmless: the records are absolute, so replaying
+	// the old log over the new snapshot ends in the same state
+	snapshotSize, err := writeSnapshot(f.cfg.FileStoragePath, f.cfg.SnapshotBackups, metrics)
 	if err != nil {
-		return fmt.Errorf("truncate File %s error: %w", f.file.Name(), err)
+		return err
 	}
 
-	_, err = f.file.Seek(0, io.SeekStart)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	tail := make([]byte, f.walSize-offset)
+
+	if _, err = f.wal.ReadAt(tail, offset); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("read File %s error: %w", f.wal.Name(), err)
+	}
+
+	path := walPath(f.cfg.FileStoragePath)
+
+	if _, err = writeFile(path, tail); err != nil {
+		return err
+	}
+
+	wal, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0666)
 	if err != nil {
-		return fmt.Errorf("seek File %s error: %w", f.file.Name(), err)
+		return fmt.Errorf("open File %s error: %w", path, err)
 	}
 
+	if err = f.wal.Close(); err != nil {
+		log.Error("close file error",
+			log.StringAttr("file", path),
+			log.ErrAttr(err))
+	}
+
+	f.wal = wal
+	f.walSize = int64(len(tail))
+	f.snapshotSize = snapshotSize
+	f.dirty = false
+
+	log.Debug("file store compacted",
+		log.IntAttr("metrics", len(metrics)),
+		log.Int64Attr("snapshot size", snapshotSize))
+
 	return nil
 }
+
+func (f *FileStore) Close() {
+	close(f.done)
+	f.wg.Wait()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.wal.Sync(); err != nil {
+		log.Error("sync file error",
+			log.StringAttr("file", f.wal.Name()),
+			log.ErrAttr(err))
+	}
+
+	if err := f.wal.Close(); err != nil {
+		log.Error("close file error",
+			log.StringAttr("file", f.wal.Name()),
+			log.ErrAttr(err))
+	}
+}
@@ -0,0 +1,191 @@
+package store_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"metrics/config"
+	"metrics/internal/consumer/internal/service"
+	"metrics/internal/consumer/internal/store"
+)
+
+func TestFileStore(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	cfg := config.Store{FileStoragePath: path, ShouldRestore: true, WALSync: store.WALSyncAlways}
+
+	db, err := store.NewFileStore(cfg)
+	require.NoError(t, err)
+
+	require.NoError(t, db.AddGauge(service.Metric{ID: "Alloc", MetricType: service.MetricGauge, Value: ptr(1.5), Delta: nil}))
+
+	counter := service.Metric{ID: "PollCount", MetricType: service.MetricCounter, Value: nil, Delta: ptr(int64(3))}
+	require.NoError(t, db.AddCounter(counter, true))
+
+	batch := []service.Metric{
+		{ID: "PollCount", MetricType: service.MetricCounter, Value: nil, Delta: ptr(int64(4))},
+		{ID: "Alloc", MetricType: service.MetricGauge, Value: ptr(2.5), Delta: nil},
+	}
+	require.NoError(t, db.AddMetrics(batch))
+
+	db.Close()
+
+	// only the changed records are appended
+	data, err := os.ReadFile(path + ".wal")
+	require.NoError(t, err)
+	assert.Equal(t, `{"id":"Alloc","type":"gauge","value":1.5}
+{"id":"PollCount","type":"counter","delta":3}
+{"id":"PollCount","type":"counter","delta":7}
+{"id":"Alloc","type":"gauge","value":2.5}
+`, string(data))
+
+	db, err = store.NewFileStore(cfg)
+	require.NoError(t, err)
+
+	t.Cleanup(db.Close)
+
+	metric, err := db.GetMetric("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), *metric.Delta)
+
+	metric, err = db.GetMetric("Alloc")
+	require.NoError(t, err)
+	assert.InDelta(t, 2.5, *metric.Value, 0)
+}
+
+func TestFileStoreHistogram(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	cfg := config.Store{FileStoragePath: path, ShouldRestore: true, WALSync: store.WALSyncAlways}
+
+	db, err := store.NewFileStore(cfg)
+	require.NoError(t, err)
+
+	histogram := service.Metric{ID: "Latency", MetricType: service.MetricHistogram, Value: nil, Delta: nil,
+		Histogram: &service.Histogram{Bounds: []float64{1}, Counts: []uint64{1, 0}, Sum: 0.5, Count: 1}}
+	require.NoError(t, db.AddHistogram(histogram, true))
+
+	// the batch is refused as a whole when one histogram has other buckets
+	batch := []service.Metric{
+		{ID: "Latency", MetricType: service.MetricHistogram, Value: nil, Delta: nil,
+			Histogram: &service.Histogram{Bounds: []float64{1}, Counts: []uint64{0, 1}, Sum: 2, Count: 1}},
+		{ID: "Latency", MetricType: service.MetricHistogram, Value: nil, Delta: nil,
+			Histogram: &service.Histogram{Bounds: []float64{2}, Counts: []uint64{0, 1}, Sum: 3, Count: 1}},
+	}
+	require.ErrorIs(t, db.AddMetrics(batch), service.ErrBucketsMismatch)
+	require.NoError(t, db.AddMetrics(batch[:1]))
+
+	db.Close()
+
+	db, err = store.NewFileStore(cfg)
+	require.NoError(t, err)
+
+	t.Cleanup(db.Close)
+
+	metric, err := db.GetMetric("Latency")
+	require.NoError(t, err)
+	assert.Equal(t, &service.Histogram{Bounds: []float64{1}, Counts: []uint64{1, 1}, Sum: 2.5, Count: 2}, metric.Histogram)
+}
+
+func TestFileStoreTornRecord(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	cfg := config.Store{FileStoragePath: path, ShouldRestore: true}
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"delta":5,"id":"PollCount","type":"counter"}`+"\n"), 0o600))
+	require.NoError(t, os.WriteFile(path+".wal", []byte(`{"delta":6,"id":"PollCount","type":"counter"}
+{"value":1.5,"id":"Alloc","type":"gau`), 0o600))
+
+	db, err := store.NewFileStore(cfg)
+	require.NoError(t, err)
+
+	metric, err := db.GetMetric("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), *metric.Delta)
+
+	_, err = db.GetMetric("Alloc")
+	require.ErrorIs(t, err, service.ErrMetricNotFound)
+
+	// the next record must not be glued to the torn one
+	require.NoError(t, db.AddGauge(service.Metric{ID: "Alloc", MetricType: service.MetricGauge, Value: ptr(2.5), Delta: nil}))
+
+	db.Close()
+
+	db, err = store.NewFileStore(cfg)
+	require.NoError(t, err)
+
+	t.Cleanup(db.Close)
+
+	metric, err = db.GetMetric("Alloc")
+	require.NoError(t, err)
+	assert.InDelta(t, 2.5, *metric.Value, 0)
+}
+
+func TestFileStoreCorruptedRecord(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	cfg := config.Store{FileStoragePath: path, ShouldRestore: true}
+
+	require.NoError(t, os.WriteFile(path+".wal", []byte(`{"delta":6,"id":"PollCount","type":"coun
+{"value":1.5,"id":"Alloc","type":"gauge"}
+`), 0o600))
+
+	_, err := store.NewFileStore(cfg)
+	require.ErrorIs(t, err, store.ErrCorruptedRecord)
+}
+
+func TestFileStoreCompact(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	cfg := config.Store{FileStoragePath: path, ShouldRestore: true, WALSync: store.WALSyncNever}
+
+	db, err := store.NewFileStore(cfg)
+	require.NoError(t, err)
+
+	for i := range 100 {
+		require.NoError(t, db.AddGauge(service.Metric{ID: "Alloc", MetricType: service.MetricGauge, Value: ptr(float64(i)), Delta: nil}))
+	}
+
+	require.NoError(t, db.Compact())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(data), `{"id":"Alloc","type":"gauge","value":99}`+"\n#sha256:"))
+
+	info, err := os.Stat(path + ".wal")
+	require.NoError(t, err)
+	assert.Zero(t, info.Size())
+
+	require.NoError(t, db.AddGauge(service.Metric{ID: "Alloc", MetricType: service.MetricGauge, Value: ptr(100.0), Delta: nil}))
+
+	db.Close()
+
+	db, err = store.NewFileStore(cfg)
+	require.NoError(t, err)
+
+	t.Cleanup(db.Close)
+
+	metric, err := db.GetMetric("Alloc")
+	require.NoError(t, err)
+	assert.InDelta(t, 100.0, *metric.Value, 0)
+}
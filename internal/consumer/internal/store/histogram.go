@@ -0,0 +1,49 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"metrics/internal/consumer/internal/service"
+)
+
+// encodeHistogram stores a histogram as a JSON object, NULL for the other metric types.
+func encodeHistogram(histogram *service.Histogram) any {
+	if histogram == nil {
+		return nil
+	}
+
+	data, _ := json.Marshal(histogram) //nolint:errchkjson // observations are bucketed, so no NaN or Inf is left
+
+	return string(data)
+}
+
+func decodeHistogram(data []byte) (*service.Histogram, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	var histogram service.Histogram
+
+	if err := json.Unmarshal(data, &histogram); err != nil {
+		return nil, fmt.Errorf("decode histogram error: %w", err)
+	}
+
+	return &histogram, nil
+}
+
+// mergeHistogram adds the stored histogram to the update, so the update can be written over the stored one.
+func mergeHistogram(histogram service.Metric, stored []byte) error {
+	current, err := decodeHistogram(stored)
+	if err != nil || current == nil {
+		return err
+	}
+
+	if err = current.Merge(*histogram.Histogram); err != nil {
+		return fmt.Errorf("histogram %s: %w", histogram.Key(), err)
+	}
+
+	*histogram.Histogram = *current
+
+	return nil
+}
@@ -0,0 +1,151 @@
+package store
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"metrics/config"
+	"metrics/internal/consumer/internal/service"
+)
+
+// HistoryStore records every stored gauge and counter of the wrapped store with the time of the update,
+// or the time the metric was observed when it tells it.
+// It keeps the last cfg.HistoryPoints points per metric and returns the ones within cfg.HistoryRetention.
+// The history lives in memory only and starts empty on every run.
+type HistoryStore struct {
+	service.Store
+	retention time.Duration
+	size      int
+	mu        sync.RWMutex
+	series    map[string]*ring
+}
+
+// ring keeps the last points of a metric in the order they were added, overwriting the oldest one when full.
+type ring struct {
+	points []service.Point
+	start  int
+}
+
+func NewHistoryStore(store service.Store, cfg config.Store) *HistoryStore {
+	return &HistoryStore{
+		Store:     store,
+		retention: cfg.HistoryRetention,
+		size:      max(cfg.HistoryPoints, 1),
+		mu:        sync.RWMutex{},
+		series:    map[string]*ring{},
+	}
+}
+
+// Unwrap returns the wrapped store.
+func (h *HistoryStore) Unwrap() service.Store {
+	return h.Store
+}
+
+func (h *HistoryStore) AddGauge(gauge service.Metric) error {
+	if err := h.Store.AddGauge(gauge); err != nil {
+		return err //nolint:wrapcheck // the wrapped store's error
+	}
+
+	h.record([]service.Metric{gauge}, time.Now())
+
+	return nil
+}
+
+// AddCounter records the total the wrapped store leaves in counter.Delta.
+func (h *HistoryStore) AddCounter(counter service.Metric, increment bool) error {
+	if err := h.Store.AddCounter(counter, increment); err != nil {
+		return err //nolint:wrapcheck // the wrapped store's error
+	}
+
+	h.record([]service.Metric{counter}, time.Now())
+
+	return nil
+}
+
+func (h *HistoryStore) AddMetrics(metrics []service.Metric) error {
+	if err := h.Store.AddMetrics(metrics); err != nil {
+		return err //nolint:wrapcheck // the wrapped store's error
+	}
+
+	h.record(metrics, time.Now())
+
+	return nil
+}
+
+func (h *HistoryStore) Range(key string, from, to time.Time) []service.Point {
+	if h.retention > 0 {
+		if oldest := time.Now().Add(-h.retention); from.Before(oldest) {
+			from = oldest
+		}
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	series, ok := h.series[key]
+	if !ok {
+		return []service.Point{}
+	}
+
+	return series.between(from, to)
+}
+
+func (h *HistoryStore) record(metrics []service.Metric, at time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, metric := range metrics {
+		point := service.Point{Time: metric.ObservedAt(at), Delta: nil, Value: nil}
+
+		// the stored metric may be changed later, the point keeps copies
+		switch metric.MetricType {
+		case service.MetricGauge:
+			value := *metric.Value
+			point.Value = &value
+		case service.MetricCounter:
+			delta := *metric.Delta
+			point.Delta = &delta
+		default:
+			continue
+		}
+
+		series, ok := h.series[metric.Key()]
+		if !ok {
+			series = &ring{points: make([]service.Point, 0, min(h.size, 64)), start: 0} //nolint:mnd // grows on demand
+			h.series[metric.Key()] = series
+		}
+
+		series.add(point, h.size)
+	}
+}
+
+func (r *ring) add(point service.Point, size int) {
+	if len(r.points) < size {
+		r.points = append(r.points, point)
+
+		return
+	}
+
+	r.points[r.start] = point
+	r.start = (r.start + 1) % len(r.points)
+}
+
+func (r *ring) between(from, to time.Time) []service.Point {
+	points := make([]service.Point, 0, len(r.points))
+
+	for i := range len(r.points) {
+		point := r.points[(r.start+i)%len(r.points)]
+
+		if !point.Time.Before(from) && !point.Time.After(to) {
+			points = append(points, point)
+		}
+	}
+
+	// a write may tell the times of the points out of order
+	slices.SortStableFunc(points, func(a, b service.Point) int {
+		return a.Time.Compare(b.Time)
+	})
+
+	return points
+}
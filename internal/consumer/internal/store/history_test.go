@@ -0,0 +1,79 @@
+package store_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"metrics/config"
+	"metrics/internal/consumer/internal/service"
+	"metrics/internal/consumer/internal/store"
+)
+
+func TestHistoryStore(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	memoryStore, err := store.NewMemoryStore(config.Store{}) //nolint:exhaustruct // memory only
+	require.NoError(t, err)
+
+	db := store.NewHistoryStore(memoryStore, config.Store{HistoryPoints: 3}) //nolint:exhaustruct // no retention
+
+	from := time.Now()
+
+	for i := range 4 {
+		require.NoError(t, db.AddGauge(service.Metric{ID: "Alloc", MetricType: service.MetricGauge, Value: ptr(float64(i)), Delta: nil}))
+	}
+
+	require.NoError(t, db.AddCounter(service.Metric{ID: "PollCount", MetricType: service.MetricCounter, Value: nil, Delta: ptr(int64(2))}, true))
+	require.NoError(t, db.AddMetrics([]service.Metric{
+		{ID: "PollCount", MetricType: service.MetricCounter, Value: nil, Delta: ptr(int64(3))},
+	}))
+
+	to := time.Now()
+
+	// the oldest point is overwritten
+	points := db.Range("Alloc", from, to)
+	require.Len(t, points, 3)
+	assert.InDelta(t, 1, *points[0].Value, 0)
+	assert.InDelta(t, 3, *points[2].Value, 0)
+	assert.False(t, points[2].Time.Before(points[0].Time))
+
+	// counters keep the totals
+	points = db.Range("PollCount", from, to)
+	require.Len(t, points, 2)
+	assert.Equal(t, int64(2), *points[0].Delta)
+	assert.Equal(t, int64(5), *points[1].Delta)
+
+	assert.Empty(t, db.Range("Alloc", to.Add(time.Second), to.Add(time.Minute)))
+	assert.Empty(t, db.Range("Unknown", from, to))
+
+	// the last value is kept as before
+	metric, err := db.GetMetric("Alloc")
+	require.NoError(t, err)
+	assert.InDelta(t, 3, *metric.Value, 0)
+}
+
+func TestHistoryStoreRetention(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	memoryStore, err := store.NewMemoryStore(config.Store{}) //nolint:exhaustruct // memory only
+	require.NoError(t, err)
+
+	db := store.NewHistoryStore(memoryStore, config.Store{HistoryRetention: 50 * time.Millisecond, HistoryPoints: 10}) //nolint:exhaustruct // history only
+
+	require.NoError(t, db.AddGauge(service.Metric{ID: "Alloc", MetricType: service.MetricGauge, Value: ptr(1.0), Delta: nil}))
+
+	time.Sleep(100 * time.Millisecond)
+
+	require.NoError(t, db.AddGauge(service.Metric{ID: "Alloc", MetricType: service.MetricGauge, Value: ptr(2.0), Delta: nil}))
+
+	points := db.Range("Alloc", time.Time{}, time.Now())
+	require.Len(t, points, 1)
+	assert.InDelta(t, 2, *points[0].Value, 0)
+}
@@ -0,0 +1,33 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"metrics/internal/consumer/internal/service"
+)
+
+// encodeLabels stores labels as a JSON object, {} for none.
+func encodeLabels(labels service.Labels) string {
+	if len(labels) == 0 {
+		return "{}"
+	}
+
+	data, _ := json.Marshal(labels) //nolint:errchkjson // a string map always encodes
+
+	return string(data)
+}
+
+func decodeLabels(data []byte) (service.Labels, error) {
+	var labels service.Labels
+
+	if err := json.Unmarshal(data, &labels); err != nil {
+		return nil, fmt.Errorf("decode labels error: %w", err)
+	}
+
+	if len(labels) == 0 {
+		return nil, nil
+	}
+
+	return labels, nil
+}
@@ -1,16 +1,12 @@
 package store
 
 import (
-	"bufio"
-	"encoding/json"
 	"fmt"
-	"io"
-	"os"
+	"slices"
 	"sync"
 
 	"metrics/config"
 	"metrics/internal/consumer/internal/service"
-	"metrics/internal/log"
 )
 
 type (
@@ -21,101 +17,162 @@ type (
 )
 
 func NewMemoryStore(cfg config.Store) (*MemoryStore, error) {
+	memoryStore := &MemoryStore{
+		memory: map[string]service.Metric{},
+		mu:     sync.Mutex{},
+	}
+
 	if cfg.FileStoragePath == "" {
-		return &MemoryStore{
-			memory: map[string]service.Metric{},
-			mu:     sync.Mutex{},
-		}, nil
+		return memoryStore, nil
 	}
 
-	file, err := os.OpenFile(cfg.FileStoragePath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
-	if err != nil {
-		return nil, fmt.Errorf("open File %s error: %w", cfg.FileStoragePath, err)
+	if _, err := restore(cfg, memoryStore); err != nil {
+		return nil, err
 	}
 
-	defer func(file *os.File) {
-		err = file.Close()
-		if err != nil {
-			log.Error("close file error", cfg.FileStoragePath,
-				log.ErrAttr(err))
-		}
-	}(file)
+	return memoryStore, nil
+}
 
-	fileInfo, err := file.Stat()
-	if err != nil {
-		return nil, fmt.Errorf("get File %s stat error: %w", cfg.FileStoragePath, err)
-	}
+func (m *MemoryStore) AddGauge(gauge service.Metric) error {
+	m.mu.Lock()
 
-	memoryStore := MemoryStore{
-		memory: map[string]service.Metric{},
-		mu:     sync.Mutex{},
-	}
+	m.addGauge(gauge)
+
+	m.mu.Unlock()
+
+	return nil
+}
+
+func (m *MemoryStore) AddCounter(counter service.Metric, increment bool) error {
+	m.mu.Lock()
+
+	m.addCounter(counter, increment)
+
+	m.mu.Unlock()
+
+	return nil
+}
+
+func (m *MemoryStore) AddHistogram(histogram service.Metric, increment bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.addHistogram(histogram, increment)
+}
+
+func (m *MemoryStore) AddSummary(summary service.Metric, increment bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.addSummary(summary, increment)
+
+	return nil
+}
 
-	if !cfg.ShouldRestore {
-		if err = clearFile(file); err != nil {
-			return nil, fmt.Errorf("clear File %s error: %w", cfg.FileStoragePath, err)
+// AddMetrics applies the whole batch under a single lock, so readers never see it half-applied.
+func (m *MemoryStore) AddMetrics(metrics []service.Metric) error {
+	for _, metric := range metrics {
+		switch metric.MetricType {
+		case service.MetricCounter, service.MetricGauge, service.MetricHistogram, service.MetricSummary:
+		default:
+			return fmt.Errorf("metric type: %s, %w", metric.MetricType, service.ErrUnknownMetricType)
 		}
 	}
 
-	if fileInfo.Size() != 0 {
-		scanner := bufio.NewScanner(file)
-
-		for scanner.Scan() {
-			data := scanner.Bytes()
-
-			var metric service.Metric
-			if err = json.Unmarshal(data, &metric); err != nil {
-				return nil, fmt.Errorf("unmarshal json error: %w", err)
-			}
-
-			switch metric.MetricType {
-			case service.MetricGauge:
-				_ = memoryStore.AddGauge(metric) // err nil
-			case service.MetricCounter:
-				_ = memoryStore.AddCounter(metric, false) // err nil
-			default:
-				return nil, fmt.Errorf("metric type: %s, %w", metric.MetricType, service.ErrUnknownMetricType)
-			}
-		}
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-		if err = scanner.Err(); err != nil {
-			return nil, fmt.Errorf("scanner error: %w", err)
+	if err := m.checkBuckets(metrics); err != nil {
+		return err
+	}
+
+	for _, metric := range metrics {
+		switch metric.MetricType {
+		case service.MetricCounter:
+			m.addCounter(metric, true)
+		case service.MetricGauge:
+			m.addGauge(metric)
+		case service.MetricHistogram:
+			_ = m.addHistogram(metric, true) // buckets are checked
+		case service.MetricSummary:
+			m.addSummary(metric, true)
 		}
 	}
 
-	return &memoryStore, nil
+	return nil
 }
 
-func (m *MemoryStore) AddGauge(gauge service.Metric) error {
-	m.mu.Lock()
+// checkBuckets makes sure every histogram of the batch merges, so the batch is never applied halfway.
+func (m *MemoryStore) checkBuckets(metrics []service.Metric) error {
+	last := map[string]*service.Histogram{}
 
-	m.memory[gauge.ID] = gauge
+	for _, metric := range metrics {
+		key := metric.Key()
 
-	m.mu.Unlock()
+		current, ok := last[key]
+		if !ok {
+			current = m.memory[key].Histogram
+		}
+
+		if metric.MetricType == service.MetricHistogram && current != nil && !slices.Equal(current.Bounds, metric.Histogram.Bounds) {
+			return fmt.Errorf("histogram %s: %w", key, service.ErrBucketsMismatch)
+		}
+
+		last[key] = metric.Histogram
+	}
 
 	return nil
 }
 
-func (m *MemoryStore) AddCounter(counter service.Metric, increment bool) error {
-	m.mu.Lock()
+func (m *MemoryStore) addGauge(gauge service.Metric) {
+	m.memory[gauge.Key()] = gauge
+}
 
-	current := m.memory[counter.ID]
+func (m *MemoryStore) addCounter(counter service.Metric, increment bool) {
+	current := m.memory[counter.Key()]
 
 	if current.Delta != nil && increment {
 		*counter.Delta += *current.Delta
 	}
 
-	m.memory[counter.ID] = counter
+	m.memory[counter.Key()] = counter
+}
 
-	m.mu.Unlock()
+// addHistogram changes nothing when the buckets do not match the stored histogram.
+func (m *MemoryStore) addHistogram(histogram service.Metric, increment bool) error {
+	current := m.memory[histogram.Key()]
+
+	if current.Histogram != nil && increment {
+		merged := current.Histogram.Clone()
+		if err := merged.Merge(*histogram.Histogram); err != nil {
+			return fmt.Errorf("histogram %s: %w", histogram.Key(), err)
+		}
+
+		*histogram.Histogram = merged
+	}
+
+	m.memory[histogram.Key()] = histogram
 
 	return nil
 }
 
-func (m *MemoryStore) GetMetric(id string) (service.Metric, error) {
+func (m *MemoryStore) addSummary(summary service.Metric, increment bool) {
+	current := m.memory[summary.Key()]
+
+	if current.Summary != nil && increment {
+		merged := current.Summary.Clone()
+		merged.Merge(*summary.Summary)
+
+		*summary.Summary = merged
+	}
+
+	m.memory[summary.Key()] = summary
+}
+
+func (m *MemoryStore) GetMetric(key string) (service.Metric, error) {
 	m.mu.Lock()
 
-	metric, ok := m.memory[id]
+	metric, ok := m.memory[key]
 
 	m.mu.Unlock()
 
@@ -141,17 +198,3 @@ func (m *MemoryStore) GetAllMetrics() []service.Metric {
 }
 
 func (*MemoryStore) Close() {}
-
-func clearFile(file *os.File) error {
-	err := file.Truncate(0)
-	if err != nil {
-		return fmt.Errorf("truncate File %s error: %w", file.Name(), err)
-	}
-
-	_, err = file.Seek(0, io.SeekStart)
-	if err != nil {
-		return fmt.Errorf("seek File %s error: %w", file.Name(), err)
-	}
-
-	return nil
-}
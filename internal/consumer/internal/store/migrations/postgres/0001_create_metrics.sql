@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS metrics (
+    id    TEXT PRIMARY KEY,
+    type  TEXT NOT NULL CHECK (type IN ('gauge', 'counter')),
+    delta BIGINT,
+    value DOUBLE PRECISION,
+    CHECK ((type = 'gauge' AND value IS NOT NULL AND delta IS NULL) OR (type = 'counter' AND delta IS NOT NULL AND value IS NULL))
+);
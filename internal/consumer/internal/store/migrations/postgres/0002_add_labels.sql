@@ -0,0 +1,6 @@
+-- id is the series key now: the name followed by the sorted labels, as in cpu{host="a"}
+ALTER TABLE metrics ADD COLUMN IF NOT EXISTS name TEXT;
+UPDATE metrics SET name = id WHERE name IS NULL;
+ALTER TABLE metrics ALTER COLUMN name SET NOT NULL;
+ALTER TABLE metrics ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
+CREATE INDEX IF NOT EXISTS metrics_name_idx ON metrics (name);
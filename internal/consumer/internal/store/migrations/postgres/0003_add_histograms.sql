@@ -0,0 +1,10 @@
+-- histogram keeps the bounds, the per-bucket counts, the sum and the count as one JSON object
+ALTER TABLE metrics ADD COLUMN IF NOT EXISTS histogram JSONB;
+ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_type_check;
+ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_check;
+ALTER TABLE metrics ADD CONSTRAINT metrics_type_check CHECK (type IN ('gauge', 'counter', 'histogram'));
+ALTER TABLE metrics ADD CONSTRAINT metrics_check CHECK (
+    (type = 'gauge' AND value IS NOT NULL AND delta IS NULL AND histogram IS NULL) OR
+    (type = 'counter' AND delta IS NOT NULL AND value IS NULL AND histogram IS NULL) OR
+    (type = 'histogram' AND histogram IS NOT NULL AND value IS NULL AND delta IS NULL)
+);
@@ -0,0 +1,11 @@
+-- summary keeps the DDSketch bins, the sum, the count and the extremes as one JSON object
+ALTER TABLE metrics ADD COLUMN IF NOT EXISTS summary JSONB;
+ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_type_check;
+ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_check;
+ALTER TABLE metrics ADD CONSTRAINT metrics_type_check CHECK (type IN ('gauge', 'counter', 'histogram', 'summary'));
+ALTER TABLE metrics ADD CONSTRAINT metrics_check CHECK (
+    (type = 'gauge' AND value IS NOT NULL AND delta IS NULL AND histogram IS NULL AND summary IS NULL) OR
+    (type = 'counter' AND delta IS NOT NULL AND value IS NULL AND histogram IS NULL AND summary IS NULL) OR
+    (type = 'histogram' AND histogram IS NOT NULL AND value IS NULL AND delta IS NULL AND summary IS NULL) OR
+    (type = 'summary' AND summary IS NOT NULL AND value IS NULL AND delta IS NULL AND histogram IS NULL)
+);
@@ -0,0 +1,5 @@
+-- id is the series key now: the name followed by the sorted labels, as in cpu{host="a"}
+ALTER TABLE metrics ADD COLUMN name TEXT NOT NULL DEFAULT '';
+UPDATE metrics SET name = id;
+ALTER TABLE metrics ADD COLUMN labels TEXT NOT NULL DEFAULT '{}';
+CREATE INDEX IF NOT EXISTS metrics_name_idx ON metrics (name);
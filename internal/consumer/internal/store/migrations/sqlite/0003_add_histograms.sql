@@ -0,0 +1,18 @@
+-- histogram keeps the bounds, the per-bucket counts, the sum and the count as one JSON object;
+-- sqlite cannot change a CHECK constraint, so the table is copied
+CREATE TABLE metrics_new (
+    id        TEXT PRIMARY KEY,
+    name      TEXT NOT NULL DEFAULT '',
+    labels    TEXT NOT NULL DEFAULT '{}',
+    type      TEXT NOT NULL CHECK (type IN ('gauge', 'counter', 'histogram')),
+    delta     INTEGER,
+    value     REAL,
+    histogram TEXT,
+    CHECK ((type = 'gauge' AND value IS NOT NULL AND delta IS NULL AND histogram IS NULL) OR
+           (type = 'counter' AND delta IS NOT NULL AND value IS NULL AND histogram IS NULL) OR
+           (type = 'histogram' AND histogram IS NOT NULL AND value IS NULL AND delta IS NULL))
+) STRICT;
+INSERT INTO metrics_new (id, name, labels, type, delta, value) SELECT id, name, labels, type, delta, value FROM metrics;
+DROP TABLE metrics;
+ALTER TABLE metrics_new RENAME TO metrics;
+CREATE INDEX IF NOT EXISTS metrics_name_idx ON metrics (name);
@@ -0,0 +1,21 @@
+-- summary keeps the DDSketch bins, the sum, the count and the extremes as one JSON object;
+-- sqlite cannot change a CHECK constraint, so the table is copied
+CREATE TABLE metrics_new (
+    id        TEXT PRIMARY KEY,
+    name      TEXT NOT NULL DEFAULT '',
+    labels    TEXT NOT NULL DEFAULT '{}',
+    type      TEXT NOT NULL CHECK (type IN ('gauge', 'counter', 'histogram', 'summary')),
+    delta     INTEGER,
+    value     REAL,
+    histogram TEXT,
+    summary   TEXT,
+    CHECK ((type = 'gauge' AND value IS NOT NULL AND delta IS NULL AND histogram IS NULL AND summary IS NULL) OR
+           (type = 'counter' AND delta IS NOT NULL AND value IS NULL AND histogram IS NULL AND summary IS NULL) OR
+           (type = 'histogram' AND histogram IS NOT NULL AND value IS NULL AND delta IS NULL AND summary IS NULL) OR
+           (type = 'summary' AND summary IS NOT NULL AND value IS NULL AND delta IS NULL AND histogram IS NULL))
+) STRICT;
+INSERT INTO metrics_new (id, name, labels, type, delta, value, histogram)
+SELECT id, name, labels, type, delta, value, histogram FROM metrics;
+DROP TABLE metrics;
+ALTER TABLE metrics_new RENAME TO metrics;
+CREATE INDEX IF NOT EXISTS metrics_name_idx ON metrics (name);
@@ -0,0 +1,394 @@
+package store
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"metrics/config"
+	"metrics/internal/consumer/internal/service"
+	"metrics/internal/log"
+)
+
+const (
+	queryTimeout = 5 * time.Second
+
+	// migrationLockID serializes migrations of servers sharing one database.
+	migrationLockID = 7_260_318
+)
+
+//go:embed migrations/postgres/*.sql
+var postgresMigrations embed.FS
+
+const (
+	upsertGaugeQuery = `
+		INSERT INTO metrics (id, name, labels, type, value, delta) VALUES ($1, $2, $3, 'gauge', $4, NULL)
+		ON CONFLICT (id) DO UPDATE SET type = 'gauge', value = EXCLUDED.value, delta = NULL, histogram = NULL, summary = NULL`
+
+	incrementCounterQuery = `
+		INSERT INTO metrics (id, name, labels, type, value, delta) VALUES ($1, $2, $3, 'counter', NULL, $4)
+		ON CONFLICT (id) DO UPDATE SET
+			type      = 'counter',
+			value     = NULL,
+			histogram = NULL,
+			summary   = NULL,
+			delta     = CASE WHEN metrics.type = 'counter' THEN metrics.delta + EXCLUDED.delta ELSE EXCLUDED.delta END
+		RETURNING delta`
+
+	setCounterQuery = `
+		INSERT INTO metrics (id, name, labels, type, value, delta) VALUES ($1, $2, $3, 'counter', NULL, $4)
+		ON CONFLICT (id) DO UPDATE SET type = 'counter', value = NULL, delta = EXCLUDED.delta, histogram = NULL, summary = NULL
+		RETURNING delta`
+
+	upsertHistogramQuery = `
+		INSERT INTO metrics (id, name, labels, type, value, delta, histogram) VALUES ($1, $2, $3, 'histogram', NULL, NULL, $4)
+		ON CONFLICT (id) DO UPDATE SET type = 'histogram', value = NULL, delta = NULL, histogram = EXCLUDED.histogram, summary = NULL`
+
+	upsertSummaryQuery = `
+		INSERT INTO metrics (id, name, labels, type, value, delta, summary) VALUES ($1, $2, $3, 'summary', NULL, NULL, $4)
+		ON CONFLICT (id) DO UPDATE SET type = 'summary', value = NULL, delta = NULL, histogram = NULL, summary = EXCLUDED.summary`
+
+	selectHistogramForUpdateQuery = `SELECT histogram FROM metrics WHERE id = $1 AND type = 'histogram' FOR UPDATE`
+	selectSummaryForUpdateQuery   = `SELECT summary FROM metrics WHERE id = $1 AND type = 'summary' FOR UPDATE`
+
+	selectMetricQuery     = `SELECT name, labels, type, delta, value, histogram, summary FROM metrics WHERE id = $1`
+	selectAllMetricsQuery = `SELECT name, labels, type, delta, value, histogram, summary FROM metrics ORDER BY id`
+)
+
+type PostgresStore struct {
+	pool *pgxpool.Pool
+}
+
+// NewPostgresStore connects to cfg.DatabaseDSN and brings the schema up to date.
+func NewPostgresStore(ctx context.Context, cfg config.Store) (*PostgresStore, error) {
+	pool, err := pgxpool.New(ctx, cfg.DatabaseDSN)
+	if err != nil {
+		return nil, fmt.Errorf("create pool error: %w", err)
+	}
+
+	if err = pool.Ping(ctx); err != nil {
+		pool.Close()
+
+		return nil, fmt.Errorf("ping database error: %w", err)
+	}
+
+	postgresStore := &PostgresStore{pool: pool}
+
+	if err = postgresStore.migrate(ctx); err != nil {
+		pool.Close()
+
+		return nil, fmt.Errorf("migrate database error: %w", err)
+	}
+
+	return postgresStore, nil
+}
+
+func (p *PostgresStore) AddGauge(gauge service.Metric) error {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	if _, err := p.pool.Exec(ctx, upsertGaugeQuery, gauge.Key(), gauge.ID, encodeLabels(gauge.Labels), *gauge.Value); err != nil {
+		return fmt.Errorf("upsert gauge %s error: %w", gauge.Key(), err)
+	}
+
+	return nil
+}
+
+// AddCounter leaves the stored total in counter.Delta, as MemoryStore does.
+func (p *PostgresStore) AddCounter(counter service.Metric, increment bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	query := setCounterQuery
+	if increment {
+		query = incrementCounterQuery
+	}
+
+	if err := p.pool.QueryRow(ctx, query, counter.Key(), counter.ID, encodeLabels(counter.Labels), *counter.Delta).Scan(counter.Delta); err != nil {
+		return fmt.Errorf("upsert counter %s error: %w", counter.Key(), err)
+	}
+
+	return nil
+}
+
+// AddHistogram leaves the stored histogram in histogram.Histogram. The stored row is locked
+// while the update is merged into it.
+func (p *PostgresStore) AddHistogram(histogram service.Metric, increment bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	err := pgx.BeginFunc(ctx, p.pool, func(tx pgx.Tx) error {
+		return upsertHistogram(ctx, tx, histogram, increment)
+	})
+	if err != nil {
+		return fmt.Errorf("upsert histogram %s error: %w", histogram.Key(), err)
+	}
+
+	return nil
+}
+
+// AddSummary leaves the stored summary in summary.Summary, the stored row is locked as in AddHistogram.
+func (p *PostgresStore) AddSummary(summary service.Metric, increment bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	err := pgx.BeginFunc(ctx, p.pool, func(tx pgx.Tx) error {
+		return upsertSummary(ctx, tx, summary, increment)
+	})
+	if err != nil {
+		return fmt.Errorf("upsert summary %s error: %w", summary.Key(), err)
+	}
+
+	return nil
+}
+
+// AddMetrics pipelines gauges and counters in batches. Histograms and summaries have to be read
+// to be merged, so the queued batch is sent before them.
+func (p *PostgresStore) AddMetrics(metrics []service.Metric) error {
+	for _, metric := range metrics {
+		switch metric.MetricType {
+		case service.MetricGauge, service.MetricCounter, service.MetricHistogram, service.MetricSummary:
+		default:
+			return fmt.Errorf("metric type: %s, %w", metric.MetricType, service.ErrUnknownMetricType)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	err := pgx.BeginFunc(ctx, p.pool, func(tx pgx.Tx) error {
+		batch := &pgx.Batch{}
+
+		for _, metric := range metrics {
+			switch metric.MetricType {
+			case service.MetricGauge:
+				batch.Queue(upsertGaugeQuery, metric.Key(), metric.ID, encodeLabels(metric.Labels), *metric.Value)
+			case service.MetricCounter:
+				batch.Queue(incrementCounterQuery, metric.Key(), metric.ID, encodeLabels(metric.Labels), *metric.Delta).QueryRow(func(row pgx.Row) error {
+					return row.Scan(metric.Delta)
+				})
+			case service.MetricHistogram, service.MetricSummary:
+				if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+					return err //nolint:wrapcheck // wrapped below
+				}
+
+				batch = &pgx.Batch{}
+
+				upsert := upsertHistogram
+				if metric.MetricType == service.MetricSummary {
+					upsert = upsertSummary
+				}
+
+				if err := upsert(ctx, tx, metric, true); err != nil {
+					return err
+				}
+			}
+		}
+
+		return tx.SendBatch(ctx, batch).Close()
+	})
+	if err != nil {
+		return fmt.Errorf("add metrics batch error: %w", err)
+	}
+
+	return nil
+}
+
+func upsertHistogram(ctx context.Context, tx pgx.Tx, histogram service.Metric, increment bool) error {
+	if increment {
+		var stored []byte
+
+		err := tx.QueryRow(ctx, selectHistogramForUpdateQuery, histogram.Key()).Scan(&stored)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("select histogram error: %w", err)
+		}
+
+		if err = mergeHistogram(histogram, stored); err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.Exec(ctx, upsertHistogramQuery, histogram.Key(), histogram.ID, encodeLabels(histogram.Labels), encodeHistogram(histogram.Histogram))
+	if err != nil {
+		return fmt.Errorf("upsert histogram error: %w", err)
+	}
+
+	return nil
+}
+
+func upsertSummary(ctx context.Context, tx pgx.Tx, summary service.Metric, increment bool) error {
+	if increment {
+		var stored []byte
+
+		err := tx.QueryRow(ctx, selectSummaryForUpdateQuery, summary.Key()).Scan(&stored)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("select summary error: %w", err)
+		}
+
+		if err = mergeSummary(summary, stored); err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.Exec(ctx, upsertSummaryQuery, summary.Key(), summary.ID, encodeLabels(summary.Labels), encodeSummary(summary.Summary))
+	if err != nil {
+		return fmt.Errorf("upsert summary error: %w", err)
+	}
+
+	return nil
+}
+
+func (p *PostgresStore) GetMetric(key string) (service.Metric, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	rows, err := p.pool.Query(ctx, selectMetricQuery, key)
+	if err != nil {
+		return service.Metric{}, fmt.Errorf("select metric %s error: %w", key, err)
+	}
+
+	metric, err := pgx.CollectExactlyOneRow(rows, scanMetric)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return service.Metric{}, service.ErrMetricNotFound
+	}
+
+	if err != nil {
+		return service.Metric{}, fmt.Errorf("scan metric %s error: %w", key, err)
+	}
+
+	return metric, nil
+}
+
+func (p *PostgresStore) GetAllMetrics() []service.Metric {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	rows, err := p.pool.Query(ctx, selectAllMetricsQuery)
+	if err != nil {
+		log.Error("select all metrics error",
+			log.ErrAttr(err))
+
+		return []service.Metric{}
+	}
+
+	metrics, err := pgx.CollectRows(rows, scanMetric)
+	if err != nil {
+		log.Error("scan all metrics error",
+			log.ErrAttr(err))
+
+		return []service.Metric{}
+	}
+
+	return metrics
+}
+
+func (p *PostgresStore) Close() {
+	p.pool.Close()
+}
+
+func scanMetric(row pgx.CollectableRow) (service.Metric, error) {
+	var metric service.Metric
+	var labels, histogram, summary []byte
+
+	if err := row.Scan(&metric.ID, &labels, &metric.MetricType, &metric.Delta, &metric.Value, &histogram, &summary); err != nil {
+		return service.Metric{}, err //nolint:wrapcheck // wrapped by the caller
+	}
+
+	var err error
+
+	if metric.Labels, err = decodeLabels(labels); err != nil {
+		return service.Metric{}, err
+	}
+
+	if metric.Histogram, err = decodeHistogram(histogram); err != nil {
+		return service.Metric{}, err
+	}
+
+	metric.Summary, err = decodeSummary(summary)
+
+	return metric, err
+}
+
+// migrate applies the embedded migrations newer than the recorded schema version
+// in one transaction, holding an advisory lock against concurrent servers.
+func (p *PostgresStore) migrate(ctx context.Context) error {
+	names, err := fs.Glob(postgresMigrations, "migrations/postgres/*.sql")
+	if err != nil {
+		return fmt.Errorf("list migrations error: %w", err)
+	}
+
+	slices.Sort(names)
+
+	return pgx.BeginFunc(ctx, p.pool, func(tx pgx.Tx) error { //nolint:wrapcheck // wrapped by the caller
+		if _, err = tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLockID); err != nil {
+			return fmt.Errorf("lock migrations error: %w", err)
+		}
+
+		_, err = tx.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+			version    INTEGER PRIMARY KEY,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`)
+		if err != nil {
+			return fmt.Errorf("create schema_migrations error: %w", err)
+		}
+
+		var current int
+		if err = tx.QueryRow(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+			return fmt.Errorf("select schema version error: %w", err)
+		}
+
+		for _, name := range names {
+			var version int
+			var query []byte
+
+			version, err = migrationVersion(name)
+			if err != nil {
+				return err
+			}
+
+			if version <= current {
+				continue
+			}
+
+			query, err = postgresMigrations.ReadFile(name)
+			if err != nil {
+				return fmt.Errorf("read migration %s error: %w", name, err)
+			}
+
+			if _, err = tx.Exec(ctx, string(query)); err != nil {
+				return fmt.Errorf("apply migration %s error: %w", name, err)
+			}
+
+			if _, err = tx.Exec(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, version); err != nil {
+				return fmt.Errorf("record migration %s error: %w", name, err)
+			}
+
+			log.Info("migration applied",
+				log.StringAttr("name", name))
+		}
+
+		return nil
+	})
+}
+
+// migrationVersion takes the version from the migration file name prefix, as in 0001_create_metrics.sql.
+func migrationVersion(name string) (int, error) {
+	prefix, _, _ := strings.Cut(path.Base(name), "_")
+
+	version, err := strconv.Atoi(prefix)
+	if err != nil {
+		return 0, fmt.Errorf("migration %s has no version prefix: %w", name, err)
+	}
+
+	return version, nil
+}
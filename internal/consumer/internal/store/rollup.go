@@ -0,0 +1,240 @@
+package store
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"metrics/config"
+	"metrics/internal/consumer/internal/service"
+	"metrics/internal/log"
+)
+
+// RollupStore rolls every stored gauge and counter of the wrapped store up into per minute
+// and per hour buckets. Each tier keeps its buckets for its retention, a zero retention disables it.
+// Expired buckets are dropped by Expire. The rollups live in memory only and start empty on every run.
+type RollupStore struct {
+	service.Store
+	tiers []*rollupTier
+	mu    sync.RWMutex
+}
+
+type rollupTier struct {
+	step      time.Duration
+	retention time.Duration
+	series    map[string][]rollup // oldest first
+}
+
+// rollup is a service.Rollup without the pointers, a gauge has min, max, sum and last, a counter the increase.
+type rollup struct {
+	start    time.Time
+	counter  bool
+	count    uint64
+	min      float64
+	max      float64
+	sum      float64
+	last     float64
+	increase int64
+}
+
+func NewRollupStore(store service.Store, cfg config.Store) *RollupStore {
+	rollupStore := &RollupStore{
+		Store: store,
+		tiers: nil,
+		mu:    sync.RWMutex{},
+	}
+
+	for _, tier := range []rollupTier{
+		{step: time.Minute, retention: cfg.RollupMinuteRetention, series: nil},
+		{step: time.Hour, retention: cfg.RollupHourRetention, series: nil},
+	} {
+		if tier.retention > 0 {
+			tier.series = map[string][]rollup{}
+			rollupStore.tiers = append(rollupStore.tiers, &tier)
+		}
+	}
+
+	return rollupStore
+}
+
+// Unwrap returns the wrapped store.
+func (r *RollupStore) Unwrap() service.Store {
+	return r.Store
+}
+
+func (r *RollupStore) AddGauge(gauge service.Metric) error {
+	if err := r.Store.AddGauge(gauge); err != nil {
+		return err //nolint:wrapcheck // the wrapped store's error
+	}
+
+	r.record([]service.Metric{gauge}, []int64{0}, time.Now())
+
+	return nil
+}
+
+// AddCounter rolls up the delta added to the counter. Setting the total is no increase.
+func (r *RollupStore) AddCounter(counter service.Metric, increment bool) error {
+	var increase int64
+	if increment {
+		increase = *counter.Delta
+	}
+
+	if err := r.Store.AddCounter(counter, increment); err != nil {
+		return err //nolint:wrapcheck // the wrapped store's error
+	}
+
+	r.record([]service.Metric{counter}, []int64{increase}, time.Now())
+
+	return nil
+}
+
+func (r *RollupStore) AddMetrics(metrics []service.Metric) error {
+	// the wrapped store leaves the totals in the counters
+	increases := make([]int64, len(metrics))
+
+	for i, metric := range metrics {
+		if metric.MetricType == service.MetricCounter {
+			increases[i] = *metric.Delta
+		}
+	}
+
+	if err := r.Store.AddMetrics(metrics); err != nil {
+		return err //nolint:wrapcheck // the wrapped store's error
+	}
+
+	r.record(metrics, increases, time.Now())
+
+	return nil
+}
+
+func (r *RollupStore) RollupSteps() []time.Duration {
+	steps := make([]time.Duration, 0, len(r.tiers))
+
+	for _, tier := range r.tiers {
+		steps = append(steps, tier.step)
+	}
+
+	return steps
+}
+
+func (r *RollupStore) Rollups(key string, step time.Duration, from, to time.Time) []service.Rollup {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rollups := []service.Rollup{}
+
+	for _, tier := range r.tiers {
+		if tier.step != step {
+			continue
+		}
+
+		for _, bucket := range tier.series[key] {
+			if !bucket.start.Before(from) && !bucket.start.After(to) {
+				rollups = append(rollups, bucket.toService())
+			}
+		}
+	}
+
+	return rollups
+}
+
+// Expire drops the buckets that ended before the retention of their tier.
+func (r *RollupStore) Expire(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var expired int
+
+	for _, tier := range r.tiers {
+		oldest := now.Add(-tier.retention)
+
+		for key, buckets := range tier.series {
+			kept := 0
+			for kept < len(buckets) && buckets[kept].start.Add(tier.step).Before(oldest) {
+				kept++
+			}
+
+			expired += kept
+
+			switch {
+			case kept == len(buckets):
+				delete(tier.series, key)
+			case kept != 0:
+				tier.series[key] = append(buckets[:0:0], buckets[kept:]...)
+			}
+		}
+	}
+
+	log.Debug("rollups expired",
+		log.IntAttr("count", expired))
+}
+
+func (r *RollupStore) record(metrics []service.Metric, increases []int64, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, metric := range metrics {
+		if metric.MetricType != service.MetricGauge && metric.MetricType != service.MetricCounter {
+			continue
+		}
+
+		for _, tier := range r.tiers {
+			tier.add(metric, increases[i], metric.ObservedAt(at))
+		}
+	}
+}
+
+func (t *rollupTier) add(metric service.Metric, increase int64, at time.Time) {
+	key := metric.Key()
+	start := at.Truncate(t.step)
+	counter := metric.MetricType == service.MetricCounter
+
+	buckets := t.series[key]
+
+	// the bucket is the last one, unless a write tells an earlier time
+	i := len(buckets)
+	for i > 0 && buckets[i-1].start.After(start) {
+		i--
+	}
+
+	if i == 0 || !buckets[i-1].start.Equal(start) || buckets[i-1].counter != counter {
+		buckets = slices.Insert(buckets, i, rollup{start: start, counter: counter, count: 0, min: 0, max: 0, sum: 0, last: 0, increase: 0})
+		t.series[key] = buckets
+		i++
+	}
+
+	bucket := &buckets[i-1]
+
+	if counter {
+		bucket.increase += increase
+	} else {
+		value := *metric.Value
+
+		if bucket.count == 0 {
+			bucket.min, bucket.max = value, value
+		}
+
+		bucket.min = min(bucket.min, value)
+		bucket.max = max(bucket.max, value)
+		bucket.sum += value
+		bucket.last = value
+	}
+
+	bucket.count++
+}
+
+func (b rollup) toService() service.Rollup {
+	rollup := service.Rollup{Start: b.start, Count: b.count, Min: nil, Max: nil, Sum: nil, Last: nil, Increase: nil}
+
+	if b.counter {
+		increase := b.increase
+		rollup.Increase = &increase
+
+		return rollup
+	}
+
+	minimum, maximum, sum, last := b.min, b.max, b.sum, b.last
+	rollup.Min, rollup.Max, rollup.Sum, rollup.Last = &minimum, &maximum, &sum, &last
+
+	return rollup
+}
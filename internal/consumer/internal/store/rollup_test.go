@@ -0,0 +1,105 @@
+package store_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"metrics/config"
+	"metrics/internal/consumer/internal/service"
+	"metrics/internal/consumer/internal/store"
+)
+
+func TestRollupStore(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	memoryStore, err := store.NewMemoryStore(config.Store{}) //nolint:exhaustruct // memory only
+	require.NoError(t, err)
+
+	db := store.NewRollupStore(memoryStore, config.Store{RollupMinuteRetention: time.Hour, RollupHourRetention: 24 * time.Hour}) //nolint:exhaustruct // rollups only
+
+	assert.Equal(t, []time.Duration{time.Minute, time.Hour}, db.RollupSteps())
+
+	from := time.Now().Truncate(time.Hour)
+
+	for _, value := range []float64{3, 1, 2} {
+		require.NoError(t, db.AddGauge(service.Metric{ID: "Alloc", MetricType: service.MetricGauge, Value: ptr(value), Delta: nil}))
+	}
+
+	require.NoError(t, db.AddCounter(service.Metric{ID: "PollCount", MetricType: service.MetricCounter, Value: nil, Delta: ptr(int64(2))}, true))
+	require.NoError(t, db.AddMetrics([]service.Metric{
+		{ID: "PollCount", MetricType: service.MetricCounter, Value: nil, Delta: ptr(int64(3))},
+	}))
+
+	to := time.Now()
+
+	// the updates may straddle a minute or even an hour
+	rollups := db.Rollups("Alloc", time.Hour, from, to)
+	require.NotEmpty(t, rollups)
+
+	var count uint64
+
+	minimum, maximum, sum := *rollups[0].Min, *rollups[0].Max, 0.0
+
+	for _, rollup := range rollups {
+		count += rollup.Count
+		minimum, maximum, sum = min(minimum, *rollup.Min), max(maximum, *rollup.Max), sum+*rollup.Sum
+		assert.Nil(t, rollup.Increase)
+	}
+
+	assert.Equal(t, uint64(3), count)
+	assert.InDelta(t, 1, minimum, 0)
+	assert.InDelta(t, 3, maximum, 0)
+	assert.InDelta(t, 6, sum, 0)
+	assert.InDelta(t, 2, *rollups[len(rollups)-1].Last, 0)
+
+	count = 0
+	for _, rollup := range db.Rollups("Alloc", time.Minute, from, to) {
+		count += rollup.Count
+	}
+
+	assert.Equal(t, uint64(3), count)
+
+	// counters roll up the increase, not the total
+	var increase int64
+	for _, rollup := range db.Rollups("PollCount", time.Minute, from, to) {
+		increase += *rollup.Increase
+		assert.Nil(t, rollup.Min)
+	}
+
+	assert.Equal(t, int64(5), increase)
+
+	metric, err := db.GetMetric("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), *metric.Delta)
+
+	assert.Empty(t, db.Rollups("Alloc", 5*time.Minute, from, to))
+	assert.Empty(t, db.Rollups("Unknown", time.Minute, from, to))
+
+	// the per minute rollups expire first
+	db.Expire(to.Add(2 * time.Hour))
+	assert.Empty(t, db.Rollups("Alloc", time.Minute, from, to))
+	assert.NotEmpty(t, db.Rollups("Alloc", time.Hour, from, to))
+
+	db.Expire(to.Add(26 * time.Hour))
+	assert.Empty(t, db.Rollups("Alloc", time.Hour, from, to))
+}
+
+func TestRollupStoreTiers(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	db := store.NewRollupStore(store.NewDummyStore(), config.Store{RollupHourRetention: time.Hour}) //nolint:exhaustruct // hours only
+
+	assert.Equal(t, []time.Duration{time.Hour}, db.RollupSteps())
+
+	require.NoError(t, db.AddGauge(service.Metric{ID: "Alloc", MetricType: service.MetricGauge, Value: ptr(1.0), Delta: nil}))
+
+	assert.Empty(t, db.Rollups("Alloc", time.Minute, time.Time{}, time.Now()))
+	assert.Len(t, db.Rollups("Alloc", time.Hour, time.Time{}, time.Now()), 1)
+}
@@ -0,0 +1,225 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"metrics/config"
+	"metrics/internal/consumer/internal/service"
+	"metrics/internal/log"
+)
+
+// A snapshot ends with a footer line holding the SHA-256 of everything before it.
+// Snapshots written before the footer was introduced have none and are read as is.
+const checksumPrefix = "#sha256:"
+
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// SaveSnapshot writes the metrics as the snapshot of the file storage and drops the log they include.
+func SaveSnapshot(cfg config.Store, metrics []service.Metric) error {
+	if _, err := writeSnapshot(cfg.FileStoragePath, cfg.SnapshotBackups, metrics); err != nil {
+		return err
+	}
+
+	if err := os.Truncate(walPath(cfg.FileStoragePath), 0); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("truncate File %s error: %w", walPath(cfg.FileStoragePath), err)
+	}
+
+	return nil
+}
+
+func backupPath(path string, n int) string {
+	return path + "." + strconv.Itoa(n)
+}
+
+// writeSnapshot replaces the file at path with the metrics as JSON lines and a checksum footer,
+// keeping the replaced snapshots as path.1 (the newest) to path.backups. It returns the snapshot size.
+func writeSnapshot(path string, backups int, metrics []service.Metric) (int64, error) {
+	var buf bytes.Buffer
+
+	encoder := json.NewEncoder(&buf)
+
+	for _, metric := range metrics {
+		if err := encoder.Encode(metric); err != nil {
+			return 0, fmt.Errorf("encode snapshot error: %w", err)
+		}
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	buf.WriteString(checksumPrefix + hex.EncodeToString(sum[:]) + "\n")
+
+	if err := rotate(path, backups); err != nil {
+		return 0, err
+	}
+
+	return writeFile(path, buf.Bytes())
+}
+
+// rotate shifts the backups by one and links the current snapshot as the newest backup.
+// The snapshot itself stays in place until the new one is renamed over it.
+func rotate(path string, backups int) error {
+	if backups <= 0 {
+		return nil
+	}
+
+	if err := os.Remove(backupPath(path, backups)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove File %s error: %w", backupPath(path, backups), err)
+	}
+
+	for n := backups - 1; n >= 1; n-- {
+		if err := os.Rename(backupPath(path, n), backupPath(path, n+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("rename File %s error: %w", backupPath(path, n), err)
+		}
+	}
+
+	err := os.Link(path, backupPath(path, 1))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		// no hard links here: move the snapshot, readSnapshot takes the backup if we crash before the rename
+		log.Debug("link snapshot error",
+			log.StringAttr("file", path),
+			log.ErrAttr(err))
+
+		if err = os.Rename(path, backupPath(path, 1)); err != nil {
+			return fmt.Errorf("rename File %s error: %w", path, err)
+		}
+	}
+
+	return nil
+}
+
+// readSnapshot reads the snapshot at path, falling back to its backups from the newest one
+// when it is missing or broken. No snapshot at all is an empty storage.
+func readSnapshot(path string, backups int) ([]service.Metric, error) {
+	var lastErr error
+
+	for n := 0; n <= backups; n++ {
+		candidate := path
+		if n != 0 {
+			candidate = backupPath(path, n)
+		}
+
+		metrics, err := readSnapshotFile(candidate)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		if errors.Is(err, ErrChecksumMismatch) || errors.Is(err, ErrCorruptedRecord) {
+			log.Warn("broken snapshot skipped",
+				log.StringAttr("file", candidate),
+				log.ErrAttr(err))
+
+			lastErr = err
+
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if n != 0 {
+			log.Warn("snapshot restored from backup",
+				log.StringAttr("file", candidate))
+		}
+
+		return metrics, nil
+	}
+
+	return nil, lastErr
+}
+
+func readSnapshotFile(path string) ([]service.Metric, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read File %s error: %w", path, err)
+	}
+
+	body := data
+
+	if len(data) != 0 && data[len(data)-1] == '\n' {
+		start := bytes.LastIndexByte(data[:len(data)-1], '\n') + 1
+		footer := data[start : len(data)-1]
+
+		if bytes.HasPrefix(footer, []byte(checksumPrefix)) {
+			body = data[:start]
+			sum := sha256.Sum256(body)
+
+			if string(footer[len(checksumPrefix):]) != hex.EncodeToString(sum[:]) {
+				return nil, fmt.Errorf("File %s: %w", path, ErrChecksumMismatch)
+			}
+		}
+	}
+
+	metrics, _, err := readRecords(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("read File %s error: %w", path, err)
+	}
+
+	return metrics, nil
+}
+
+// writeFile writes a temporary file, syncs it and renames it over path,
+// so a crash leaves either the old or the new content.
+func writeFile(path string, data []byte) (int64, error) {
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return 0, fmt.Errorf("create File %s error: %w", path, err)
+	}
+
+	defer os.Remove(tmpFile.Name()) // no-op after the rename
+
+	if _, err = tmpFile.Write(data); err != nil {
+		_ = tmpFile.Close()
+
+		return 0, fmt.Errorf("write File %s error: %w", tmpFile.Name(), err)
+	}
+
+	if err = tmpFile.Sync(); err != nil {
+		_ = tmpFile.Close()
+
+		return 0, fmt.Errorf("sync File %s error: %w", tmpFile.Name(), err)
+	}
+
+	if err = tmpFile.Close(); err != nil {
+		return 0, fmt.Errorf("close File %s error: %w", tmpFile.Name(), err)
+	}
+
+	if err = os.Rename(tmpFile.Name(), path); err != nil {
+		return 0, fmt.Errorf("rename File %s error: %w", tmpFile.Name(), err)
+	}
+
+	syncDir(filepath.Dir(path))
+
+	return int64(len(data)), nil
+}
+
+// syncDir makes a rename in dir durable. Not every platform can sync a directory, so failures are only logged.
+func syncDir(dir string) {
+	dirFile, err := os.Open(dir)
+	if err != nil {
+		log.Error("open dir error",
+			log.StringAttr("dir", dir),
+			log.ErrAttr(err))
+
+		return
+	}
+
+	defer dirFile.Close()
+
+	if err = dirFile.Sync(); err != nil {
+		log.Debug("sync dir error",
+			log.StringAttr("dir", dir),
+			log.ErrAttr(err))
+	}
+}
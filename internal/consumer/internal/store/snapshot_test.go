@@ -0,0 +1,128 @@
+package store_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"metrics/config"
+	"metrics/internal/consumer/internal/service"
+	"metrics/internal/consumer/internal/store"
+)
+
+func TestSaveSnapshot(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	cfg := config.Store{FileStoragePath: path, ShouldRestore: true, SnapshotBackups: 2}
+
+	require.NoError(t, os.WriteFile(path+".wal", []byte(`{"id":"Alloc","type":"gauge","value":0.5}`+"\n"), 0o600))
+
+	for i := range 4 {
+		metrics := []service.Metric{
+			{ID: "PollCount", MetricType: service.MetricCounter, Value: nil, Delta: ptr(int64(i))},
+		}
+		require.NoError(t, store.SaveSnapshot(cfg, metrics))
+	}
+
+	assert.FileExists(t, path+".1")
+	assert.FileExists(t, path+".2")
+	assert.NoFileExists(t, path+".3")
+
+	// the log is part of the snapshot now
+	info, err := os.Stat(path + ".wal")
+	require.NoError(t, err)
+	assert.Zero(t, info.Size())
+
+	db, err := store.NewMemoryStore(cfg)
+	require.NoError(t, err)
+
+	metric, err := db.GetMetric("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), *metric.Delta)
+
+	_, err = db.GetMetric("Alloc")
+	require.ErrorIs(t, err, service.ErrMetricNotFound)
+}
+
+func TestSnapshotChecksumMismatch(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	cfg := config.Store{FileStoragePath: path, ShouldRestore: true, SnapshotBackups: 1}
+
+	for i := range 2 {
+		metrics := []service.Metric{
+			{ID: "PollCount", MetricType: service.MetricCounter, Value: nil, Delta: ptr(int64(i + 1))},
+		}
+		require.NoError(t, store.SaveSnapshot(cfg, metrics))
+	}
+
+	// flip a digit, the record is still valid JSON
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, []byte(strings.Replace(string(data), `"delta":2`, `"delta":9`, 1)), 0o600))
+
+	db, err := store.NewMemoryStore(cfg)
+	require.NoError(t, err)
+
+	metric, err := db.GetMetric("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), *metric.Delta)
+
+	// without a good backup the restore fails
+	require.NoError(t, os.Remove(path+".1"))
+
+	_, err = store.NewMemoryStore(cfg)
+	require.ErrorIs(t, err, store.ErrChecksumMismatch)
+}
+
+func TestSnapshotSummary(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	cfg := config.Store{FileStoragePath: path, ShouldRestore: true}
+
+	db, err := store.NewMemoryStore(config.Store{}) //nolint:exhaustruct // memory only
+	require.NoError(t, err)
+
+	consumer := service.NewConsumerService(db, config.ConsumerConfig{}) //nolint:exhaustruct // defaults
+
+	// two agents report the same summary
+	_, err = consumer.AddSummary("Latency", service.Summary{Observations: []float64{0.1, 0.2, 0.3}}, nil) //nolint:exhaustruct // observations only
+	require.NoError(t, err)
+	_, err = consumer.AddSummary("Latency", service.Summary{Observations: []float64{-1, 0, 4}}, nil) //nolint:exhaustruct // observations only
+	require.NoError(t, err)
+
+	saved, err := db.GetMetric("Latency")
+	require.NoError(t, err)
+	require.NoError(t, store.SaveSnapshot(cfg, db.GetAllMetrics()))
+
+	db, err = store.NewMemoryStore(cfg)
+	require.NoError(t, err)
+
+	restored, err := db.GetMetric("Latency")
+	require.NoError(t, err)
+	assert.Equal(t, saved, restored)
+
+	consumer = service.NewConsumerService(db, config.ConsumerConfig{}) //nolint:exhaustruct // defaults
+
+	metric, err := consumer.GetMetric("Latency")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(6), metric.Summary.Count)
+	assert.InDelta(t, -1, metric.Summary.Min, 0)
+	assert.InDelta(t, 4, metric.Summary.Max, 0)
+	// the quantile of rank q*(count-1), rounded down, within 1%
+	assert.InEpsilon(t, 0.1, metric.Summary.Quantiles["0.5"], 0.01)
+	assert.InEpsilon(t, 0.3, metric.Summary.Quantiles["0.99"], 0.01)
+}
@@ -0,0 +1,427 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"slices"
+
+	_ "modernc.org/sqlite" // registers the cgo-free sqlite driver
+
+	"metrics/config"
+	"metrics/internal/consumer/internal/service"
+	"metrics/internal/log"
+)
+
+//go:embed migrations/sqlite/*.sql
+var sqliteMigrations embed.FS
+
+const (
+	sqliteUpsertGaugeQuery = `
+		INSERT INTO metrics (id, name, labels, type, value, delta) VALUES (?, ?, ?, 'gauge', ?, NULL)
+		ON CONFLICT (id) DO UPDATE SET type = 'gauge', value = excluded.value, delta = NULL, histogram = NULL, summary = NULL`
+
+	sqliteIncrementCounterQuery = `
+		INSERT INTO metrics (id, name, labels, type, value, delta) VALUES (?, ?, ?, 'counter', NULL, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			type      = 'counter',
+			value     = NULL,
+			histogram = NULL,
+			summary   = NULL,
+			delta     = CASE WHEN metrics.type = 'counter' THEN metrics.delta + excluded.delta ELSE excluded.delta END
+		RETURNING delta`
+
+	sqliteSetCounterQuery = `
+		INSERT INTO metrics (id, name, labels, type, value, delta) VALUES (?, ?, ?, 'counter', NULL, ?)
+		ON CONFLICT (id) DO UPDATE SET type = 'counter', value = NULL, delta = excluded.delta, histogram = NULL, summary = NULL
+		RETURNING delta`
+
+	sqliteUpsertHistogramQuery = `
+		INSERT INTO metrics (id, name, labels, type, value, delta, histogram) VALUES (?, ?, ?, 'histogram', NULL, NULL, ?)
+		ON CONFLICT (id) DO UPDATE SET type = 'histogram', value = NULL, delta = NULL, histogram = excluded.histogram, summary = NULL`
+
+	sqliteUpsertSummaryQuery = `
+		INSERT INTO metrics (id, name, labels, type, value, delta, summary) VALUES (?, ?, ?, 'summary', NULL, NULL, ?)
+		ON CONFLICT (id) DO UPDATE SET type = 'summary', value = NULL, delta = NULL, histogram = NULL, summary = excluded.summary`
+
+	sqliteSelectHistogramQuery = `SELECT histogram FROM metrics WHERE id = ? AND type = 'histogram'`
+	sqliteSelectSummaryQuery   = `SELECT summary FROM metrics WHERE id = ? AND type = 'summary'`
+
+	sqliteSelectMetricQuery     = `SELECT name, labels, type, delta, value, histogram, summary FROM metrics WHERE id = ?`
+	sqliteSelectAllMetricsQuery = `SELECT name, labels, type, delta, value, histogram, summary FROM metrics ORDER BY id`
+)
+
+// SQLiteStore keeps metrics in an embedded SQLite database in WAL mode, for servers without PostgreSQL.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore opens the database from the sqlite:// storage option. A new database imports
+// the metrics from the JSON lines file at cfg.FileStoragePath, if there is one.
+func NewSQLiteStore(ctx context.Context, cfg config.Store) (*SQLiteStore, error) {
+	_, path := cfg.StorageURL()
+
+	query := url.Values{}
+	query.Add("_pragma", "journal_mode(WAL)")
+	query.Add("_pragma", "synchronous(NORMAL)")
+	query.Add("_pragma", "busy_timeout(5000)")
+	query.Add("_pragma", "foreign_keys(ON)")
+
+	db, err := sql.Open("sqlite", "file:"+path+"?"+query.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite %s error: %w", path, err)
+	}
+
+	// sqlite has a single writer, one connection keeps writers from failing with SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+
+	sqliteStore := &SQLiteStore{db: db}
+
+	isNew, err := sqliteStore.migrate(ctx)
+	if err != nil {
+		_ = db.Close()
+
+		return nil, fmt.Errorf("migrate sqlite %s error: %w", path, err)
+	}
+
+	if isNew && cfg.FileStoragePath != "" {
+		if err = sqliteStore.importFile(cfg.FileStoragePath); err != nil {
+			_ = db.Close()
+
+			return nil, fmt.Errorf("import %s error: %w", cfg.FileStoragePath, err)
+		}
+	}
+
+	return sqliteStore, nil
+}
+
+func (s *SQLiteStore) AddGauge(gauge service.Metric) error {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	if _, err := s.db.ExecContext(ctx, sqliteUpsertGaugeQuery, gauge.Key(), gauge.ID, encodeLabels(gauge.Labels), *gauge.Value); err != nil {
+		return fmt.Errorf("upsert gauge %s error: %w", gauge.Key(), err)
+	}
+
+	return nil
+}
+
+// AddCounter leaves the stored total in counter.Delta, as MemoryStore does.
+func (s *SQLiteStore) AddCounter(counter service.Metric, increment bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	query := sqliteSetCounterQuery
+	if increment {
+		query = sqliteIncrementCounterQuery
+	}
+
+	if err := s.db.QueryRowContext(ctx, query, counter.Key(), counter.ID, encodeLabels(counter.Labels), *counter.Delta).Scan(counter.Delta); err != nil {
+		return fmt.Errorf("upsert counter %s error: %w", counter.Key(), err)
+	}
+
+	return nil
+}
+
+// AddHistogram leaves the stored histogram in histogram.Histogram.
+func (s *SQLiteStore) AddHistogram(histogram service.Metric, increment bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		return upsertSQLiteHistogram(ctx, tx, histogram, increment)
+	})
+}
+
+// AddSummary leaves the stored summary in summary.Summary.
+func (s *SQLiteStore) AddSummary(summary service.Metric, increment bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		return upsertSQLiteSummary(ctx, tx, summary, increment)
+	})
+}
+
+func (s *SQLiteStore) AddMetrics(metrics []service.Metric) error {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		return addMetricsTx(ctx, tx, metrics, true)
+	})
+}
+
+func (s *SQLiteStore) GetMetric(key string) (service.Metric, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	metric, err := scanSQLiteMetric(s.db.QueryRowContext(ctx, sqliteSelectMetricQuery, key))
+	if errors.Is(err, sql.ErrNoRows) {
+		return service.Metric{}, service.ErrMetricNotFound
+	}
+
+	if err != nil {
+		return service.Metric{}, fmt.Errorf("select metric %s error: %w", key, err)
+	}
+
+	return metric, nil
+}
+
+func (s *SQLiteStore) GetAllMetrics() []service.Metric {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, sqliteSelectAllMetricsQuery)
+	if err != nil {
+		log.Error("select all metrics error",
+			log.ErrAttr(err))
+
+		return []service.Metric{}
+	}
+
+	defer rows.Close()
+
+	metrics := make([]service.Metric, 0)
+
+	for rows.Next() {
+		var metric service.Metric
+
+		if metric, err = scanSQLiteMetric(rows); err != nil {
+			log.Error("scan metric error",
+				log.ErrAttr(err))
+
+			return []service.Metric{}
+		}
+
+		metrics = append(metrics, metric)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Error("select all metrics error",
+			log.ErrAttr(err))
+
+		return []service.Metric{}
+	}
+
+	return metrics
+}
+
+func (s *SQLiteStore) Close() {
+	if err := s.db.Close(); err != nil {
+		log.Error("close sqlite error",
+			log.ErrAttr(err))
+	}
+}
+
+func addMetricsTx(ctx context.Context, tx *sql.Tx, metrics []service.Metric, increment bool) error {
+	counterQuery := sqliteSetCounterQuery
+	if increment {
+		counterQuery = sqliteIncrementCounterQuery
+	}
+
+	for _, metric := range metrics {
+		var err error
+
+		switch metric.MetricType {
+		case service.MetricGauge:
+			_, err = tx.ExecContext(ctx, sqliteUpsertGaugeQuery, metric.Key(), metric.ID, encodeLabels(metric.Labels), *metric.Value)
+		case service.MetricCounter:
+			err = tx.QueryRowContext(ctx, counterQuery, metric.Key(), metric.ID, encodeLabels(metric.Labels), *metric.Delta).Scan(metric.Delta)
+		case service.MetricHistogram:
+			err = upsertSQLiteHistogram(ctx, tx, metric, increment)
+		case service.MetricSummary:
+			err = upsertSQLiteSummary(ctx, tx, metric, increment)
+		default:
+			return fmt.Errorf("metric type: %s, %w", metric.MetricType, service.ErrUnknownMetricType)
+		}
+
+		if err != nil {
+			return fmt.Errorf("upsert metric %s error: %w", metric.Key(), err)
+		}
+	}
+
+	return nil
+}
+
+// upsertSQLiteHistogram merges the update into the stored histogram; the single connection
+// keeps other writers out of the transaction in between.
+func upsertSQLiteHistogram(ctx context.Context, tx *sql.Tx, histogram service.Metric, increment bool) error {
+	if increment {
+		var stored []byte
+
+		err := tx.QueryRowContext(ctx, sqliteSelectHistogramQuery, histogram.Key()).Scan(&stored)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("select histogram %s error: %w", histogram.Key(), err)
+		}
+
+		if err = mergeHistogram(histogram, stored); err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.ExecContext(ctx, sqliteUpsertHistogramQuery, histogram.Key(), histogram.ID, encodeLabels(histogram.Labels), encodeHistogram(histogram.Histogram))
+	if err != nil {
+		return fmt.Errorf("upsert histogram %s error: %w", histogram.Key(), err)
+	}
+
+	return nil
+}
+
+func upsertSQLiteSummary(ctx context.Context, tx *sql.Tx, summary service.Metric, increment bool) error {
+	if increment {
+		var stored []byte
+
+		err := tx.QueryRowContext(ctx, sqliteSelectSummaryQuery, summary.Key()).Scan(&stored)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("select summary %s error: %w", summary.Key(), err)
+		}
+
+		if err = mergeSummary(summary, stored); err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.ExecContext(ctx, sqliteUpsertSummaryQuery, summary.Key(), summary.ID, encodeLabels(summary.Labels), encodeSummary(summary.Summary))
+	if err != nil {
+		return fmt.Errorf("upsert summary %s error: %w", summary.Key(), err)
+	}
+
+	return nil
+}
+
+func scanSQLiteMetric(row interface{ Scan(dest ...any) error }) (service.Metric, error) {
+	var metric service.Metric
+	var labels, histogram, summary []byte
+
+	if err := row.Scan(&metric.ID, &labels, &metric.MetricType, &metric.Delta, &metric.Value, &histogram, &summary); err != nil {
+		return service.Metric{}, err //nolint:wrapcheck // wrapped by the caller
+	}
+
+	var err error
+
+	if metric.Labels, err = decodeLabels(labels); err != nil {
+		return service.Metric{}, err
+	}
+
+	if metric.Histogram, err = decodeHistogram(histogram); err != nil {
+		return service.Metric{}, err
+	}
+
+	metric.Summary, err = decodeSummary(summary)
+
+	return metric, err
+}
+
+func (s *SQLiteStore) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction error: %w", err)
+	}
+
+	if err = fn(tx); err != nil {
+		_ = tx.Rollback()
+
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction error: %w", err)
+	}
+
+	return nil
+}
+
+// migrate applies the embedded migrations newer than PRAGMA user_version and reports
+// whether the database was empty before.
+func (s *SQLiteStore) migrate(ctx context.Context) (bool, error) {
+	names, err := fs.Glob(sqliteMigrations, "migrations/sqlite/*.sql")
+	if err != nil {
+		return false, fmt.Errorf("list migrations error: %w", err)
+	}
+
+	slices.Sort(names)
+
+	var current int
+	if err = s.db.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&current); err != nil {
+		return false, fmt.Errorf("select schema version error: %w", err)
+	}
+
+	err = s.inTx(ctx, func(tx *sql.Tx) error {
+		for _, name := range names {
+			var version int
+			var query []byte
+
+			version, err = migrationVersion(name)
+			if err != nil {
+				return err
+			}
+
+			if version <= current {
+				continue
+			}
+
+			query, err = sqliteMigrations.ReadFile(name)
+			if err != nil {
+				return fmt.Errorf("read migration %s error: %w", name, err)
+			}
+
+			if _, err = tx.ExecContext(ctx, string(query)); err != nil {
+				return fmt.Errorf("apply migration %s error: %w", name, err)
+			}
+
+			// pragma does not take parameters, version is a parsed integer
+			if _, err = tx.ExecContext(ctx, fmt.Sprintf(`PRAGMA user_version = %d`, version)); err != nil {
+				return fmt.Errorf("record migration %s error: %w", name, err)
+			}
+
+			log.Info("migration applied",
+				log.StringAttr("name", name))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return current == 0, nil
+}
+
+// importFile loads the JSON lines file written by FileStore and the autosave.
+func (s *SQLiteStore) importFile(path string) error {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	fileStore, err := NewMemoryStore(config.Store{ //nolint:exhaustruct // only the file is needed
+		FileStoragePath: path,
+		ShouldRestore:   true,
+	})
+	if err != nil {
+		return fmt.Errorf("read file error: %w", err)
+	}
+
+	metrics := fileStore.GetAllMetrics()
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	err = s.inTx(ctx, func(tx *sql.Tx) error {
+		return addMetricsTx(ctx, tx, metrics, false)
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Info("metrics imported",
+		log.StringAttr("file", path),
+		log.IntAttr("count", len(metrics)))
+
+	return nil
+}
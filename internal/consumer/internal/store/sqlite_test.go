@@ -0,0 +1,143 @@
+package store_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"metrics/config"
+	"metrics/internal/consumer/internal/service"
+	"metrics/internal/consumer/internal/store"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "metrics.db")
+	cfg := config.Store{Storage: "sqlite://" + path}
+	ctx := context.Background()
+
+	db, err := store.NewSQLiteStore(ctx, cfg)
+	require.NoError(t, err)
+
+	require.NoError(t, db.AddGauge(service.Metric{ID: "Alloc", MetricType: service.MetricGauge, Value: ptr(1.5), Delta: nil}))
+	require.NoError(t, db.AddGauge(service.Metric{ID: "Alloc", MetricType: service.MetricGauge, Value: ptr(2.5), Delta: nil}))
+
+	counter := service.Metric{ID: "PollCount", MetricType: service.MetricCounter, Value: nil, Delta: ptr(int64(3))}
+	require.NoError(t, db.AddCounter(counter, true))
+
+	counter = service.Metric{ID: "PollCount", MetricType: service.MetricCounter, Value: nil, Delta: ptr(int64(4))}
+	require.NoError(t, db.AddCounter(counter, true))
+	assert.Equal(t, int64(7), *counter.Delta)
+
+	batch := []service.Metric{
+		{ID: "PollCount", MetricType: service.MetricCounter, Value: nil, Delta: ptr(int64(1))},
+		{ID: "PollCount", MetricType: service.MetricCounter, Value: nil, Delta: ptr(int64(2))},
+		{ID: "HeapAlloc", MetricType: service.MetricGauge, Value: ptr(10.0), Delta: nil},
+	}
+	require.NoError(t, db.AddMetrics(batch))
+	assert.Equal(t, int64(10), *batch[1].Delta)
+	assert.FileExists(t, path+"-wal")
+
+	labelled := service.Metric{ID: "PollCount", MetricType: service.MetricCounter, Value: nil, Delta: ptr(int64(5)), Labels: service.Labels{"host": "a"}}
+	require.NoError(t, db.AddCounter(labelled, true))
+
+	histogram := service.Metric{ID: "Latency", MetricType: service.MetricHistogram, Value: nil, Delta: nil,
+		Histogram: &service.Histogram{Bounds: []float64{1, 5}, Counts: []uint64{1, 0, 1}, Sum: 7.5, Count: 2}}
+	require.NoError(t, db.AddHistogram(histogram, true))
+
+	histogram.Histogram = &service.Histogram{Bounds: []float64{1, 5}, Counts: []uint64{0, 2, 0}, Sum: 5, Count: 2}
+	require.NoError(t, db.AddMetrics([]service.Metric{histogram}))
+	assert.Equal(t, []uint64{1, 2, 1}, histogram.Histogram.Counts)
+
+	histogram.Histogram = &service.Histogram{Bounds: []float64{2}, Counts: []uint64{1, 0}, Sum: 1, Count: 1}
+	require.ErrorIs(t, db.AddHistogram(histogram, true), service.ErrBucketsMismatch)
+
+	summary := service.Metric{ID: "Duration", MetricType: service.MetricSummary, Value: nil, Delta: nil,
+		Summary: &service.Summary{Positive: map[int]uint64{10: 1}, Sum: 1.2, Count: 1, Min: 1.2, Max: 1.2}}
+	require.NoError(t, db.AddSummary(summary, true))
+
+	summary.Summary = &service.Summary{Positive: map[int]uint64{10: 1, 20: 1}, Zero: 1, Sum: 3.7, Count: 3, Min: 0, Max: 2.5}
+	require.NoError(t, db.AddSummary(summary, true))
+
+	db.Close()
+
+	db, err = store.NewSQLiteStore(ctx, cfg)
+	require.NoError(t, err)
+
+	t.Cleanup(db.Close)
+
+	metric, err := db.GetMetric("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), *metric.Delta)
+
+	metric, err = db.GetMetric("Alloc")
+	require.NoError(t, err)
+	assert.InDelta(t, 2.5, *metric.Value, 0)
+
+	_, err = db.GetMetric("Unknown")
+	require.ErrorIs(t, err, service.ErrMetricNotFound)
+
+	metric, err = db.GetMetric(`PollCount{host="a"}`)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), *metric.Delta)
+	assert.Equal(t, service.Labels{"host": "a"}, metric.Labels)
+
+	metric, err = db.GetMetric("Latency")
+	require.NoError(t, err)
+	assert.Equal(t, &service.Histogram{Bounds: []float64{1, 5}, Counts: []uint64{1, 2, 1}, Sum: 12.5, Count: 4}, metric.Histogram)
+
+	metric, err = db.GetMetric("Duration")
+	require.NoError(t, err)
+	assert.Equal(t, &service.Summary{Positive: map[int]uint64{10: 2, 20: 1}, Zero: 1, Sum: 4.9, Count: 4, Min: 0, Max: 2.5}, metric.Summary)
+
+	assert.Len(t, db.GetAllMetrics(), 6)
+}
+
+func TestSQLiteStoreImport(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "metrics-db.json")
+
+	lines := `{"id":"Alloc","type":"gauge","value":1}
+{"id":"PollCount","type":"counter","delta":5}
+{"id":"Alloc","type":"gauge","value":2}
+{"id":"PollCount","type":"counter","delta":7}
+`
+	require.NoError(t, os.WriteFile(jsonPath, []byte(lines), 0o600))
+
+	cfg := config.Store{Storage: "sqlite://" + filepath.Join(dir, "metrics.db"), FileStoragePath: jsonPath}
+
+	db, err := store.NewSQLiteStore(context.Background(), cfg)
+	require.NoError(t, err)
+
+	metric, err := db.GetMetric("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), *metric.Delta)
+
+	metric, err = db.GetMetric("Alloc")
+	require.NoError(t, err)
+	assert.InDelta(t, 2.0, *metric.Value, 0)
+
+	require.NoError(t, db.AddGauge(service.Metric{ID: "Alloc", MetricType: service.MetricGauge, Value: ptr(3.0), Delta: nil}))
+	db.Close()
+
+	// the file is imported on the first start only
+	db, err = store.NewSQLiteStore(context.Background(), cfg)
+	require.NoError(t, err)
+
+	t.Cleanup(db.Close)
+
+	metric, err = db.GetMetric("Alloc")
+	require.NoError(t, err)
+	assert.InDelta(t, 3.0, *metric.Value, 0)
+}
@@ -0,0 +1,17 @@
+package store_test
+
+import (
+	"testing"
+
+	"metrics/internal/log"
+)
+
+func prepare(t *testing.T) {
+	t.Helper()
+
+	log.Prepare()
+}
+
+func ptr[T any](value T) *T {
+	return &value
+}
@@ -0,0 +1,47 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"metrics/internal/consumer/internal/service"
+)
+
+// encodeSummary stores the summary sketch as a JSON object, NULL for the other metric types.
+func encodeSummary(summary *service.Summary) any {
+	if summary == nil {
+		return nil
+	}
+
+	data, _ := json.Marshal(summary) //nolint:errchkjson // observations are binned, so no NaN or Inf is left
+
+	return string(data)
+}
+
+func decodeSummary(data []byte) (*service.Summary, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	var summary service.Summary
+
+	if err := json.Unmarshal(data, &summary); err != nil {
+		return nil, fmt.Errorf("decode summary error: %w", err)
+	}
+
+	return &summary, nil
+}
+
+// mergeSummary adds the stored sketch to the update, so the update can be written over the stored one.
+func mergeSummary(summary service.Metric, stored []byte) error {
+	current, err := decodeSummary(stored)
+	if err != nil || current == nil {
+		return err
+	}
+
+	current.Merge(*summary.Summary)
+
+	*summary.Summary = *current
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"metrics/config"
+	"metrics/internal/consumer/internal/service"
+	"metrics/internal/log"
+)
+
+// The file storage keeps a snapshot at FileStoragePath and a write-ahead log next to it.
+// Both are JSON lines of metrics with absolute values, counters included, so replaying
+// the log over a snapshot that already has its records gives the same state.
+const walExt = ".wal"
+
+var ErrCorruptedRecord = errors.New("corrupted record")
+
+func walPath(path string) string {
+	return path + walExt
+}
+
+// restore fills the store from the snapshot and the log and returns the size of the log
+// part that was read completely. Without cfg.ShouldRestore both files are emptied.
+func restore(cfg config.Store, memoryStore *MemoryStore) (int64, error) {
+	if !cfg.ShouldRestore {
+		for _, path := range []string{cfg.FileStoragePath, walPath(cfg.FileStoragePath)} {
+			if err := os.Truncate(path, 0); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return 0, fmt.Errorf("clear File %s error: %w", path, err)
+			}
+		}
+
+		return 0, nil
+	}
+
+	metrics, err := readSnapshot(cfg.FileStoragePath, cfg.SnapshotBackups)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = applyRecords(memoryStore, metrics); err != nil {
+		return 0, err
+	}
+
+	path := walPath(cfg.FileStoragePath)
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("open File %s error: %w", path, err)
+	}
+
+	defer file.Close()
+
+	metrics, size, err := readRecords(file)
+	if err != nil {
+		return 0, fmt.Errorf("read File %s error: %w", path, err)
+	}
+
+	if err = applyRecords(memoryStore, metrics); err != nil {
+		return 0, err
+	}
+
+	return size, nil
+}
+
+func applyRecords(memoryStore *MemoryStore, metrics []service.Metric) error {
+	for _, metric := range metrics {
+		switch metric.MetricType {
+		case service.MetricGauge:
+			_ = memoryStore.AddGauge(metric) // err nil
+		case service.MetricCounter:
+			_ = memoryStore.AddCounter(metric, false) // err nil
+		case service.MetricHistogram:
+			_ = memoryStore.AddHistogram(metric, false) // err nil without merging
+		case service.MetricSummary:
+			_ = memoryStore.AddSummary(metric, false) // err nil
+		default:
+			return fmt.Errorf("metric type: %s, %w", metric.MetricType, service.ErrUnknownMetricType)
+		}
+	}
+
+	return nil
+}
+
+// readRecords reads JSON lines up to the end. A broken last line is what a crash in the middle
+// of an append leaves behind, so it is skipped; a broken line before it is an error.
+// The returned size covers the lines that were read completely.
+func readRecords(reader io.Reader) ([]service.Metric, int64, error) {
+	var metrics []service.Metric
+	var size int64
+	var broken error
+
+	bufReader := bufio.NewReader(reader)
+
+	for {
+		line, err := bufReader.ReadBytes('\n')
+		if len(line) != 0 {
+			if broken != nil {
+				return nil, 0, broken
+			}
+
+			var metric service.Metric
+
+			isComplete := line[len(line)-1] == '\n'
+			if errUnmarshal := json.Unmarshal(bytes.TrimSpace(line), &metric); errUnmarshal != nil || !isComplete {
+				broken = fmt.Errorf("unmarshal json error at offset %d: %w", size, errors.Join(ErrCorruptedRecord, errUnmarshal))
+			} else {
+				metrics = append(metrics, metric)
+				size += int64(len(line))
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, 0, fmt.Errorf("read error: %w", err)
+		}
+	}
+
+	if broken != nil {
+		log.Warn("torn last record skipped",
+			log.Int64Attr("offset", size))
+	}
+
+	return metrics, size, nil
+}
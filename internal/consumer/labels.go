@@ -0,0 +1,78 @@
+package consumer
+
+import (
+	"fmt"
+	"net/url"
+
+	"metrics/internal/consumer/internal/service"
+)
+
+// matchParam is the query parameter holding a label matcher, as in ?match=host=~"web-.*".
+const matchParam = "match"
+
+// labelsFromQuery takes the labels of an update from the query parameters, as in /update/gauge/cpu/1?host=a,
+// so clients without labels keep using the same route.
+func labelsFromQuery(query url.Values) (service.Labels, error) {
+	if len(query) == 0 {
+		return nil, nil
+	}
+
+	labels := make(service.Labels, len(query))
+
+	for name, values := range query {
+		if len(values) != 1 {
+			return nil, fmt.Errorf("label %q has %d values: %w", name, len(values), service.ErrInvalidLabel)
+		}
+
+		labels[name] = values[0]
+	}
+
+	if err := labels.Validate(); err != nil {
+		return nil, err //nolint:wrapcheck // already descriptive
+	}
+
+	return labels, nil
+}
+
+// matchersFromQuery reads label matchers from the query parameters: a plain parameter must equal
+// the label, a match parameter holds any matcher, as in ?region=eu&match=host!="a".
+func matchersFromQuery(query url.Values) ([]service.Matcher, error) {
+	var matchers []service.Matcher
+
+	for name, values := range query {
+		for _, value := range values {
+			var matcher service.Matcher
+			var err error
+
+			if name == matchParam {
+				matcher, err = service.ParseMatcher(value)
+			} else {
+				matcher, err = service.NewMatcher(service.MatchEqual, name, value)
+			}
+
+			if err != nil {
+				return nil, err //nolint:wrapcheck // already descriptive
+			}
+
+			matchers = append(matchers, matcher)
+		}
+	}
+
+	return matchers, nil
+}
+
+// matchersFromLabels asks for the metric with exactly these labels.
+func matchersFromLabels(labels service.Labels) []service.Matcher {
+	matchers := make([]service.Matcher, 0, len(labels))
+
+	for name, value := range labels {
+		matcher, err := service.NewMatcher(service.MatchEqual, name, value)
+		if err != nil {
+			continue // labels are validated with the request
+		}
+
+		matchers = append(matchers, matcher)
+	}
+
+	return matchers
+}
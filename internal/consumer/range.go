@@ -0,0 +1,84 @@
+package consumer
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"time"
+
+	"metrics/internal/consumer/internal/service"
+)
+
+// Query parameters of the range route, the other parameters select the labels as in GetMetric.
+const (
+	fromParam = "from"
+	toParam   = "to"
+	stepParam = "step"
+)
+
+// rangeFromQuery takes the time window and the step out of the query, leaving the label parameters.
+func rangeFromQuery(query url.Values) (time.Time, time.Time, time.Duration, url.Values, error) {
+	from, err := parseRangeTime(query.Get(fromParam))
+	if err != nil {
+		return time.Time{}, time.Time{}, 0, nil, err
+	}
+
+	to, err := parseRangeTime(query.Get(toParam))
+	if err != nil {
+		return time.Time{}, time.Time{}, 0, nil, err
+	}
+
+	step, err := parseStep(query.Get(stepParam))
+	if err != nil {
+		return time.Time{}, time.Time{}, 0, nil, err
+	}
+
+	labels := url.Values{}
+
+	for name, values := range query {
+		if name != fromParam && name != toParam && name != stepParam {
+			labels[name] = values
+		}
+	}
+
+	return from, to, step, labels, nil
+}
+
+// parseRangeTime reads RFC 3339 or unix seconds, as in 2024-05-01T10:00:00Z or 1714557600.5.
+func parseRangeTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if at, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return at, nil
+	}
+
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return time.Time{}, fmt.Errorf("time %q: %w", value, service.ErrInvalidRange)
+	}
+
+	whole, fraction := math.Modf(seconds)
+
+	return time.Unix(int64(whole), int64(fraction*float64(time.Second))), nil
+}
+
+// parseStep reads a duration, as in 30s, or a number of seconds.
+func parseStep(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	if step, err := time.ParseDuration(value); err == nil {
+		return step, nil
+	}
+
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || !(seconds >= 0 && seconds < math.MaxInt64/float64(time.Second)) {
+		return 0, fmt.Errorf("step %q: %w", value, service.ErrInvalidRange)
+	}
+
+	return time.Duration(seconds * float64(time.Second)), nil
+}
@@ -0,0 +1,281 @@
+package consumer
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"metrics/internal/consumer/internal/service"
+)
+
+// Field numbers of the remote write protocol, see prompb/remote.proto and prompb/types.proto of Prometheus.
+// The metadata, exemplars and native histograms of a request are skipped.
+const (
+	pbWriteRequestTimeseries = 1
+	pbTimeSeriesLabels       = 1
+	pbTimeSeriesSamples      = 2
+	pbLabelName              = 1
+	pbLabelValue             = 2
+	pbSampleValue            = 1
+	pbSampleTimestamp        = 2
+
+	metricNameLabel = "__name__"
+
+	// maxRemoteWriteSize bounds the decoded request, Prometheus sends a few megabytes at most.
+	maxRemoteWriteSize = 32 << 20
+)
+
+var ErrInvalidRemoteWrite = errors.New("invalid remote write request")
+
+type (
+	// remoteSeries is a time series of a remote write request, the samples in the order they were sent.
+	remoteSeries struct {
+		labels  map[string]string
+		samples []remoteSample
+	}
+
+	// remoteSample is a value and its timestamp in milliseconds, 0 when the sample has none.
+	remoteSample struct {
+		value     float64
+		timestamp int64
+	}
+)
+
+// decodeWriteRequest reads a snappy compressed protobuf WriteRequest.
+func decodeWriteRequest(body []byte) ([]remoteSeries, error) {
+	size, err := snappy.DecodedLen(body)
+	if err != nil {
+		return nil, fmt.Errorf("snappy: %w: %w", ErrInvalidRemoteWrite, err)
+	}
+
+	if size > maxRemoteWriteSize {
+		return nil, fmt.Errorf("%d bytes: %w", size, ErrInvalidRemoteWrite)
+	}
+
+	request, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, fmt.Errorf("snappy: %w: %w", ErrInvalidRemoteWrite, err)
+	}
+
+	var series []remoteSeries
+
+	err = consumeMessage(request, func(number protowire.Number, value []byte) error {
+		if number != pbWriteRequestTimeseries {
+			return nil
+		}
+
+		timeSeries, errSeries := decodeTimeSeries(value)
+		if errSeries != nil {
+			return errSeries
+		}
+
+		series = append(series, timeSeries)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return series, nil
+}
+
+func decodeTimeSeries(data []byte) (remoteSeries, error) {
+	series := remoteSeries{labels: map[string]string{}, samples: nil}
+
+	err := consumeMessage(data, func(number protowire.Number, field []byte) error {
+		switch number {
+		case pbTimeSeriesLabels:
+			var name, value string
+
+			errLabel := consumeMessage(field, func(number protowire.Number, labelField []byte) error {
+				switch number {
+				case pbLabelName:
+					name = string(labelField)
+				case pbLabelValue:
+					value = string(labelField)
+				}
+
+				return nil
+			})
+			if errLabel != nil {
+				return errLabel
+			}
+
+			series.labels[name] = value
+		case pbTimeSeriesSamples:
+			sample, errSample := decodeSample(field)
+			if errSample != nil {
+				return errSample
+			}
+
+			series.samples = append(series.samples, sample)
+		}
+
+		return nil
+	})
+
+	return series, err
+}
+
+// decodeSample reads the value and the timestamp of a sample.
+func decodeSample(data []byte) (remoteSample, error) {
+	sample := remoteSample{value: 0, timestamp: 0}
+
+	for len(data) != 0 {
+		number, wireType, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return remoteSample{}, fmt.Errorf("sample: %w: %w", ErrInvalidRemoteWrite, protowire.ParseError(n))
+		}
+
+		data = data[n:]
+
+		switch {
+		case number == pbSampleValue && wireType == protowire.Fixed64Type:
+			bits, m := protowire.ConsumeFixed64(data)
+			if m < 0 {
+				return remoteSample{}, fmt.Errorf("sample value: %w: %w", ErrInvalidRemoteWrite, protowire.ParseError(m))
+			}
+
+			sample.value = math.Float64frombits(bits)
+			data = data[m:]
+
+			continue
+		case number == pbSampleTimestamp && wireType == protowire.VarintType:
+			timestamp, m := protowire.ConsumeVarint(data)
+			if m < 0 {
+				return remoteSample{}, fmt.Errorf("sample timestamp: %w: %w", ErrInvalidRemoteWrite, protowire.ParseError(m))
+			}
+
+			sample.timestamp = int64(timestamp) //nolint:gosec // int64 on the wire
+			data = data[m:]
+
+			continue
+		}
+
+		m := protowire.ConsumeFieldValue(number, wireType, data)
+		if m < 0 {
+			return remoteSample{}, fmt.Errorf("sample field %d: %w: %w", number, ErrInvalidRemoteWrite, protowire.ParseError(m))
+		}
+
+		data = data[m:]
+	}
+
+	return sample, nil
+}
+
+// consumeMessage calls field for every length-delimited field of the message, skipping the other ones.
+func consumeMessage(data []byte, field func(number protowire.Number, value []byte) error) error {
+	for len(data) != 0 {
+		number, wireType, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return fmt.Errorf("tag: %w: %w", ErrInvalidRemoteWrite, protowire.ParseError(n))
+		}
+
+		data = data[n:]
+
+		if wireType != protowire.BytesType {
+			m := protowire.ConsumeFieldValue(number, wireType, data)
+			if m < 0 {
+				return fmt.Errorf("field %d: %w: %w", number, ErrInvalidRemoteWrite, protowire.ParseError(m))
+			}
+
+			data = data[m:]
+
+			continue
+		}
+
+		value, m := protowire.ConsumeBytes(data)
+		if m < 0 {
+			return fmt.Errorf("field %d: %w: %w", number, ErrInvalidRemoteWrite, protowire.ParseError(m))
+		}
+
+		if err := field(number, value); err != nil {
+			return err
+		}
+
+		data = data[m:]
+	}
+
+	return nil
+}
+
+// remoteWriteMetrics maps the samples onto gauges and counters. A counter is a series named as Prometheus
+// names cumulative integers, with a _total, _count or _bucket suffix; its value is the running total,
+// rounded. The other series, _sum included, are gauges. Stale markers and other NaN or infinite samples
+// are skipped, and so are counter totals out of the int64 range. A sample keeps its timestamp, as a line of
+// the InfluxDB line protocol does.
+func remoteWriteMetrics(series []remoteSeries) ([]service.Metric, error) {
+	var metrics []service.Metric
+
+	for _, timeSeries := range series {
+		name := timeSeries.labels[metricNameLabel]
+		if name == "" {
+			return nil, fmt.Errorf("series without %s: %w", metricNameLabel, ErrInvalidRemoteWrite)
+		}
+
+		var labels service.Labels
+
+		if len(timeSeries.labels) > 1 {
+			labels = make(service.Labels, len(timeSeries.labels)-1)
+
+			for labelName, value := range timeSeries.labels {
+				if labelName != metricNameLabel {
+					labels[labelName] = value
+				}
+			}
+
+			if err := labels.Validate(); err != nil {
+				return nil, fmt.Errorf("series %s: %w", name, err)
+			}
+		}
+
+		metricType := service.MetricGauge
+		if strings.HasSuffix(name, "_total") || strings.HasSuffix(name, "_count") || strings.HasSuffix(name, "_bucket") {
+			metricType = service.MetricCounter
+		}
+
+		for _, sample := range timeSeries.samples {
+			if math.IsNaN(sample.value) || math.IsInf(sample.value, 0) {
+				continue
+			}
+
+			var timestamp time.Time
+			if sample.timestamp != 0 {
+				timestamp = time.UnixMilli(sample.timestamp)
+			}
+
+			metric := service.Metric{
+				ID:         name,
+				MetricType: metricType,
+				Delta:      nil,
+				Value:      nil,
+				Histogram:  nil,
+				Summary:    nil,
+				Labels:     labels,
+				Timestamp:  timestamp,
+			}
+
+			if metricType == service.MetricCounter {
+				if math.Abs(sample.value) >= math.MaxInt64 {
+					continue
+				}
+
+				total := int64(math.Round(sample.value))
+				metric.Delta = &total
+			} else {
+				value := sample.value
+				metric.Value = &value
+			}
+
+			metrics = append(metrics, metric)
+		}
+	}
+
+	return metrics, nil
+}
@@ -1,10 +1,19 @@
 package consumer_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -13,6 +22,8 @@ import (
 	"metrics/internal/consumer"
 	"metrics/internal/consumer/internal/service"
 	"metrics/internal/consumer/internal/store"
+	"metrics/internal/hybrid"
+	"metrics/internal/sign"
 )
 
 func TestRouting(t *testing.T) {
@@ -108,7 +119,8 @@ func TestRouting(t *testing.T) {
 	var cfg config.ConsumerConfig
 	db := store.NewDummyStore()
 	consumerService := service.NewConsumerService(db, cfg)
-	handler := consumer.NewHandler(consumerService)
+	handler, err := consumer.NewHandler(consumerService, cfg)
+	require.NoError(t, err)
 
 	server := httptest.NewServer(handler.InitRoutes())
 
@@ -132,3 +144,1262 @@ func TestRouting(t *testing.T) {
 		})
 	}
 }
+
+func TestRoutingWithHash(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	const key = "secret"
+
+	body := `[{"id":"Test","type":"gauge","value":1}]`
+
+	var compressedBody bytes.Buffer
+
+	gzipWriter := gzip.NewWriter(&compressedBody)
+	_, err := gzipWriter.Write([]byte(body))
+	require.NoError(t, err)
+	require.NoError(t, gzipWriter.Close())
+
+	testCases := []struct {
+		name      string
+		request   string
+		body      []byte
+		isGzip    bool
+		signature string
+		status    int
+	}{
+		{name: "Post batch without signature", request: "/updates/", body: []byte(body), signature: "", status: 400},
+		{name: "Post batch with wrong signature", request: "/updates/", body: []byte(body), signature: sign.Sign("wrong", []byte(body)), status: 400},
+		{name: "Post batch with signature", request: "/updates/", body: []byte(body), signature: sign.Sign(key, []byte(body)), status: 200},
+		{name: "Post gzip batch with signature", request: "/updates/", body: compressedBody.Bytes(), isGzip: true, signature: sign.Sign(key, []byte(body)), status: 200},
+		{name: "Post url metric without signature", request: "/update/gauge/Test/1", body: nil, signature: "", status: 400},
+		{name: "Post url metric with signature", request: "/update/gauge/Test/1", body: nil, signature: sign.Sign(key, nil), status: 200},
+	}
+
+	cfg := config.ConsumerConfig{Consumer: config.Consumer{Key: key}}
+	db, err := store.NewMemoryStore(cfg.Store)
+	require.NoError(t, err)
+
+	consumerService := service.NewConsumerService(db, cfg)
+	handler, err := consumer.NewHandler(consumerService, cfg)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(handler.InitRoutes())
+
+	t.Cleanup(server.Close)
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			request, err := http.NewRequest(http.MethodPost, server.URL+tt.request, bytes.NewReader(tt.body))
+			require.NoError(t, err)
+
+			request.Header.Set("Accept-Encoding", "identity")
+
+			if tt.isGzip {
+				request.Header.Set("Content-Encoding", "gzip")
+			}
+
+			if tt.signature != "" {
+				request.Header.Set(sign.Header, tt.signature)
+			}
+
+			response, err := http.DefaultClient.Do(request)
+			require.NoError(t, err)
+
+			responseBody, err := io.ReadAll(response.Body)
+			require.NoError(t, err)
+
+			err = response.Body.Close()
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.status, response.StatusCode)
+			assert.True(t, sign.Verify(key, responseBody, response.Header.Get(sign.Header)))
+		})
+	}
+}
+
+func TestRoutingWithDecrypt(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	const keyBits = 2048
+
+	privateKey, err := hybrid.GenerateKey(keyBits)
+	require.NoError(t, err)
+
+	privatePEM, err := hybrid.EncodePrivateKey(privateKey)
+	require.NoError(t, err)
+
+	keyPath := filepath.Join(t.TempDir(), "private.pem")
+	require.NoError(t, os.WriteFile(keyPath, privatePEM, 0o600))
+
+	body := []byte(`[{"id":"Test","type":"gauge","value":1}]`)
+
+	var compressedBody bytes.Buffer
+
+	gzipWriter := gzip.NewWriter(&compressedBody)
+	_, err = gzipWriter.Write(body)
+	require.NoError(t, err)
+	require.NoError(t, gzipWriter.Close())
+
+	encryptedBody, err := hybrid.Encrypt(&privateKey.PublicKey, compressedBody.Bytes())
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name      string
+		body      []byte
+		encrypted bool
+		status    int
+	}{
+		{name: "Post plain batch", body: compressedBody.Bytes(), encrypted: false, status: 400},
+		{name: "Post broken envelope", body: compressedBody.Bytes(), encrypted: true, status: 400},
+		{name: "Post encrypted batch", body: encryptedBody, encrypted: true, status: 200},
+	}
+
+	cfg := config.ConsumerConfig{Consumer: config.Consumer{Key: "secret", CryptoKey: keyPath}}
+	db, err := store.NewMemoryStore(cfg.Store)
+	require.NoError(t, err)
+
+	consumerService := service.NewConsumerService(db, cfg)
+	handler, err := consumer.NewHandler(consumerService, cfg)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(handler.InitRoutes())
+
+	t.Cleanup(server.Close)
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			request, err := http.NewRequest(http.MethodPost, server.URL+"/updates/", bytes.NewReader(tt.body))
+			require.NoError(t, err)
+
+			request.Header.Set("Content-Encoding", "gzip")
+			request.Header.Set(sign.Header, sign.Sign(cfg.Consumer.Key, body))
+
+			if tt.encrypted {
+				request.Header.Set(hybrid.Header, hybrid.Scheme)
+			}
+
+			response, err := http.DefaultClient.Do(request)
+			require.NoError(t, err)
+
+			err = response.Body.Close()
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.status, response.StatusCode)
+		})
+	}
+}
+
+func TestRoutingWithTrustedSubnet(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		method  string
+		request string
+		realIP  string
+		status  int
+	}{
+		{name: "Post from trusted subnet", method: http.MethodPost, request: "/update/gauge/Test/1", realIP: "10.1.2.3", status: 200},
+		{name: "Post from second trusted subnet", method: http.MethodPost, request: "/update/gauge/Test/1", realIP: "192.168.1.7", status: 200},
+		{name: "Post from untrusted subnet", method: http.MethodPost, request: "/update/gauge/Test/1", realIP: "172.16.0.1", status: 403},
+		{name: "Post batch from untrusted subnet", method: http.MethodPost, request: "/updates/", realIP: "172.16.0.1", status: 403},
+		{name: "Post without real ip", method: http.MethodPost, request: "/update/gauge/Test/1", realIP: "", status: 403},
+		{name: "Post with broken real ip", method: http.MethodPost, request: "/update/gauge/Test/1", realIP: "10.1.2", status: 403},
+		{name: "Get value from untrusted subnet", method: http.MethodGet, request: "/value/gauge/Test", realIP: "172.16.0.1", status: 404},
+	}
+
+	var cfg config.ConsumerConfig
+	require.NoError(t, cfg.Consumer.TrustedSubnet.Set("10.0.0.0/8, 192.168.1.0/24"))
+
+	db := store.NewDummyStore()
+	consumerService := service.NewConsumerService(db, cfg)
+	handler, err := consumer.NewHandler(consumerService, cfg)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(handler.InitRoutes())
+
+	t.Cleanup(server.Close)
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			request, err := http.NewRequest(tt.method, server.URL+tt.request, http.NoBody)
+			require.NoError(t, err)
+
+			if tt.realIP != "" {
+				request.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			response, err := http.DefaultClient.Do(request)
+			require.NoError(t, err)
+
+			err = response.Body.Close()
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.status, response.StatusCode)
+		})
+	}
+}
+
+func TestRoutingWithLabels(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	steps := []struct {
+		name     string
+		method   string
+		request  string
+		status   int
+		response string
+	}{
+		{name: "Post with labels", method: http.MethodPost, request: "/update/gauge/cpu/1?host=a&region=eu", status: 200, response: ""},
+		{name: "Post with other labels", method: http.MethodPost, request: "/update/gauge/cpu/2?host=b", status: 200, response: ""},
+		{name: "Post without labels", method: http.MethodPost, request: "/update/gauge/cpu/3", status: 200, response: ""},
+		{name: "Post with invalid label", method: http.MethodPost, request: "/update/gauge/cpu/4?host-name=c", status: 400, response: "Bad Request\n"},
+		{name: "Post with repeated label", method: http.MethodPost, request: "/update/gauge/cpu/4?host=c&host=d", status: 400, response: "Bad Request\n"},
+		{name: "Get without labels", method: http.MethodGet, request: "/value/gauge/cpu", status: 200, response: "3"},
+		{name: "Get by exact labels", method: http.MethodGet, request: "/value/gauge/cpu?region=eu&host=a", status: 200, response: "1"},
+		{name: "Get by label subset", method: http.MethodGet, request: "/value/gauge/cpu?region=eu", status: 200, response: "1"},
+		{name: "Get by regexp", method: http.MethodGet, request: "/value/gauge/cpu?match=" + url.QueryEscape(`host=~"b.*"`), status: 200, response: "2"},
+		{name: "Get ambiguous", method: http.MethodGet, request: "/value/gauge/cpu?match=" + url.QueryEscape(`host!="a"`), status: 400, response: "Bad Request\n"},
+		{name: "Get invalid matcher", method: http.MethodGet, request: "/value/gauge/cpu?match=host", status: 400, response: "Bad Request\n"},
+		{name: "Get unknown labels", method: http.MethodGet, request: "/value/gauge/cpu?host=c", status: 404, response: "Not Found\n"},
+		{name: "List by labels", method: http.MethodGet, request: "/?host=a", status: 200, response: "\n\t\t<!DOCTYPE html>\n\t\t<html lang=\"en\">\n\n\t\t<body>\n\t\t\t<pre>cpu{host=&#34;a&#34;,region=&#34;eu&#34;} 1\n</pre>\n\t\t</body>\n\t\t</html>\n\t"},
+	}
+
+	var cfg config.ConsumerConfig
+
+	db, err := store.NewMemoryStore(cfg.Store)
+	require.NoError(t, err)
+
+	consumerService := service.NewConsumerService(db, cfg)
+	handler, err := consumer.NewHandler(consumerService, cfg)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(handler.InitRoutes())
+
+	t.Cleanup(server.Close)
+
+	// steps depend on the metrics stored before them
+	for _, step := range steps {
+		request, err := http.NewRequest(step.method, server.URL+step.request, http.NoBody)
+		require.NoError(t, err, step.name)
+
+		response, err := http.DefaultClient.Do(request)
+		require.NoError(t, err, step.name)
+
+		body, err := io.ReadAll(response.Body)
+		require.NoError(t, err, step.name)
+		require.NoError(t, response.Body.Close(), step.name)
+
+		assert.Equal(t, step.status, response.StatusCode, step.name)
+		assert.Equal(t, step.response, string(body), step.name)
+	}
+}
+
+func TestRoutingWithHistogram(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	steps := []struct {
+		name     string
+		method   string
+		request  string
+		body     string
+		status   int
+		response string
+	}{
+		{name: "Post observation", method: http.MethodPost, request: "/update/histogram/latency/0.5", body: "", status: 200, response: ""},
+		{name: "Post second observation", method: http.MethodPost, request: "/update/histogram/latency/3", body: "", status: 200, response: ""},
+		{name: "Post observation above bounds", method: http.MethodPost, request: "/update/histogram/latency/7", body: "", status: 200, response: ""},
+		{name: "Post invalid observation", method: http.MethodPost, request: "/update/histogram/latency/fast", body: "", status: 400, response: "Bad Request\n"},
+		{name: "Post other buckets", method: http.MethodPost, request: "/update/", body: `{"id":"latency","type":"histogram","histogram":{"bounds":[2],"counts":[1,0]}}`, status: 400, response: "Bad Request\n"},
+		{name: "Post inconsistent counts", method: http.MethodPost, request: "/update/", body: `{"id":"latency","type":"histogram","histogram":{"bounds":[1,5],"counts":[1,0]}}`, status: 400, response: "Bad Request\n"},
+		{name: "Get histogram", method: http.MethodGet, request: "/value/histogram/latency", body: "", status: 200, response: `{"bounds":[1,5],"counts":[1,1,1],"sum":10.5,"count":3,"quantiles":{"0.5":3,"0.9":5,"0.95":5,"0.99":5}}` + "\n"},
+		{name: "Get as counter", method: http.MethodGet, request: "/value/counter/latency", body: "", status: 404, response: "Not Found\n"},
+		{name: "List histogram", method: http.MethodGet, request: "/", body: "", status: 200, response: "\n\t\t<!DOCTYPE html>\n\t\t<html lang=\"en\">\n\n\t\t<body>\n\t\t\t<pre>latency count=3 sum=10.5\n</pre>\n\t\t</body>\n\t\t</html>\n\t"},
+	}
+
+	var cfg config.ConsumerConfig
+	cfg.Consumer.Buckets = config.Buckets{1, 5}
+
+	db, err := store.NewMemoryStore(cfg.Store)
+	require.NoError(t, err)
+
+	consumerService := service.NewConsumerService(db, cfg)
+	handler, err := consumer.NewHandler(consumerService, cfg)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(handler.InitRoutes())
+
+	t.Cleanup(server.Close)
+
+	// steps depend on the metrics stored before them
+	for _, step := range steps {
+		request, err := http.NewRequest(step.method, server.URL+step.request, strings.NewReader(step.body))
+		require.NoError(t, err, step.name)
+
+		response, err := http.DefaultClient.Do(request)
+		require.NoError(t, err, step.name)
+
+		body, err := io.ReadAll(response.Body)
+		require.NoError(t, err, step.name)
+		require.NoError(t, response.Body.Close(), step.name)
+
+		assert.Equal(t, step.status, response.StatusCode, step.name)
+		assert.Equal(t, step.response, string(body), step.name)
+	}
+
+	// a client taking no gzip gets the histogram as it is
+	header, plain := identityGet(t, server, "/value/histogram/latency")
+	assert.Empty(t, header.Get("Content-Encoding"))
+	assert.Contains(t, string(plain), `"count":3`)
+
+	header, plain = identityPost(t, server, "/value/", `{"id":"latency","type":"histogram"}`)
+	assert.Empty(t, header.Get("Content-Encoding"))
+	assert.Contains(t, string(plain), `"count":3`)
+}
+
+func TestRoutingWithSummary(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	var cfg config.ConsumerConfig
+
+	db, err := store.NewMemoryStore(cfg.Store)
+	require.NoError(t, err)
+
+	consumerService := service.NewConsumerService(db, cfg)
+	handler, err := consumer.NewHandler(consumerService, cfg)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(handler.InitRoutes())
+
+	t.Cleanup(server.Close)
+
+	post := func(path string, body string) int {
+		response, err := http.Post(server.URL+path, "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		require.NoError(t, response.Body.Close())
+
+		return response.StatusCode
+	}
+
+	// two agents observe 1..50 and 51..100
+	var first, second []string
+	for i := 1; i <= 50; i++ {
+		first = append(first, strconv.Itoa(i))
+		second = append(second, strconv.Itoa(i+50))
+	}
+
+	assert.Equal(t, http.StatusOK, post("/update/", `{"id":"latency","type":"summary","summary":{"observations":[`+strings.Join(first, ",")+`]}}`))
+	assert.Equal(t, http.StatusOK, post("/updates/", `[{"id":"latency","type":"summary","summary":{"observations":[`+strings.Join(second, ",")+`]}}]`))
+	assert.Equal(t, http.StatusBadRequest, post("/update/", `{"id":"latency","type":"summary","summary":{"count":3}}`))
+	assert.Equal(t, http.StatusBadRequest, post("/update/summary/latency/slow", ""))
+
+	response, err := http.Get(server.URL + "/value/summary/latency")
+	require.NoError(t, err)
+
+	var summary service.Summary
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&summary))
+	require.NoError(t, response.Body.Close())
+
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, uint64(100), summary.Count)
+	assert.InDelta(t, 5050, summary.Sum, 0)
+	assert.InEpsilon(t, 50, summary.Quantiles["0.5"], 0.01)
+	assert.InEpsilon(t, 90, summary.Quantiles["0.9"], 0.01)
+	assert.InEpsilon(t, 99, summary.Quantiles["0.99"], 0.01)
+
+	// a client taking no gzip gets the summary as it is
+	header, plain := identityGet(t, server, "/value/summary/latency")
+	assert.Empty(t, header.Get("Content-Encoding"))
+	assert.Contains(t, string(plain), `"count":100`)
+
+	header, plain = identityPost(t, server, "/value/", `{"id":"latency","type":"summary"}`)
+	assert.Empty(t, header.Get("Content-Encoding"))
+	assert.Contains(t, string(plain), `"count":100`)
+}
+
+func TestRoutingWithHistory(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	var cfg config.ConsumerConfig
+	cfg.Store.HistoryPoints = 10
+
+	memoryStore, err := store.NewMemoryStore(cfg.Store)
+	require.NoError(t, err)
+
+	consumerService := service.NewConsumerService(store.NewHistoryStore(memoryStore, cfg.Store), cfg)
+	handler, err := consumer.NewHandler(consumerService, cfg)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(handler.InitRoutes())
+
+	t.Cleanup(server.Close)
+
+	for _, request := range []string{"/update/gauge/cpu/1?host=a", "/update/gauge/cpu/2?host=a", "/update/counter/requests/3", "/update/counter/requests/4"} {
+		response, err := http.Post(server.URL+request, "text/plain", http.NoBody)
+		require.NoError(t, err)
+		require.NoError(t, response.Body.Close())
+		require.Equal(t, http.StatusOK, response.StatusCode, request)
+	}
+
+	getRange := func(request string) (int, []service.Point) {
+		response, err := http.Get(server.URL + request)
+		require.NoError(t, err)
+
+		defer response.Body.Close()
+
+		var points []service.Point
+		if response.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(response.Body).Decode(&points))
+		}
+
+		return response.StatusCode, points
+	}
+
+	status, points := getRange("/range/gauge/cpu?host=a")
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, points, 2)
+	assert.InDelta(t, 1, *points[0].Value, 0)
+	assert.InDelta(t, 2, *points[1].Value, 0)
+
+	status, points = getRange("/range/counter/requests")
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, points, 2)
+	assert.Equal(t, int64(7), *points[1].Delta)
+
+	// one step of an hour ending a second from now holds both points, the last one is returned
+	to := time.Now().Add(time.Second).Truncate(time.Second)
+	query := "?host=a&step=1h&from=" + strconv.FormatInt(to.Add(-time.Hour).Unix(), 10) + "&to=" + url.QueryEscape(to.Format(time.RFC3339))
+
+	status, points = getRange("/range/gauge/cpu" + query)
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, points, 1)
+	assert.InDelta(t, 2, *points[0].Value, 0)
+	assert.True(t, to.Equal(points[0].Time))
+
+	status, _ = getRange("/range/gauge/cpu?host=a&step=1ms&from=0")
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = getRange("/range/gauge/cpu?host=a&from=yesterday")
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = getRange("/range/histogram/cpu?host=a")
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = getRange("/range/gauge/requests")
+	assert.Equal(t, http.StatusNotFound, status)
+
+	// the last value is kept as before
+	response, err := http.Get(server.URL + "/value/gauge/cpu?host=a")
+	require.NoError(t, err)
+
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	require.NoError(t, response.Body.Close())
+	assert.Equal(t, "2", string(body))
+
+	// a client taking no gzip gets the points as they are
+	header, plain := identityGet(t, server, "/range/gauge/cpu?host=a")
+	assert.Empty(t, header.Get("Content-Encoding"))
+	require.NoError(t, json.Unmarshal(plain, &points))
+	assert.Len(t, points, 2)
+}
+
+func TestRoutingWithoutHistory(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	var cfg config.ConsumerConfig
+
+	db, err := store.NewMemoryStore(cfg.Store)
+	require.NoError(t, err)
+
+	handler, err := consumer.NewHandler(service.NewConsumerService(db, cfg), cfg)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(handler.InitRoutes())
+
+	t.Cleanup(server.Close)
+
+	response, err := http.Get(server.URL + "/range/gauge/cpu")
+	require.NoError(t, err)
+	require.NoError(t, response.Body.Close())
+	assert.Equal(t, http.StatusNotImplemented, response.StatusCode)
+
+	response, err = http.Get(server.URL + "/rollup/gauge/cpu")
+	require.NoError(t, err)
+	require.NoError(t, response.Body.Close())
+	assert.Equal(t, http.StatusNotImplemented, response.StatusCode)
+}
+
+func TestRoutingWithRollups(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	var cfg config.ConsumerConfig
+	cfg.Store.RollupMinuteRetention = time.Hour
+
+	memoryStore, err := store.NewMemoryStore(cfg.Store)
+	require.NoError(t, err)
+
+	consumerService := service.NewConsumerService(store.NewRollupStore(memoryStore, cfg.Store), cfg)
+	handler, err := consumer.NewHandler(consumerService, cfg)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(handler.InitRoutes())
+
+	t.Cleanup(server.Close)
+
+	for _, request := range []string{"/update/gauge/cpu/1?host=a", "/update/gauge/cpu/3?host=a", "/update/counter/requests/3", "/update/counter/requests/4"} {
+		response, err := http.Post(server.URL+request, "text/plain", http.NoBody)
+		require.NoError(t, err)
+		require.NoError(t, response.Body.Close())
+		require.Equal(t, http.StatusOK, response.StatusCode, request)
+	}
+
+	getRollups := func(request string) (int, []service.Rollup) {
+		response, err := http.Get(server.URL + request)
+		require.NoError(t, err)
+
+		defer response.Body.Close()
+
+		var rollups []service.Rollup
+		if response.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(response.Body).Decode(&rollups))
+		}
+
+		return response.StatusCode, rollups
+	}
+
+	// the per minute rollups merged into a day
+	status, rollups := getRollups("/rollup/gauge/cpu?host=a&step=24h")
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, rollups, 1)
+	assert.Equal(t, uint64(2), rollups[0].Count)
+	assert.InDelta(t, 1, *rollups[0].Min, 0)
+	assert.InDelta(t, 3, *rollups[0].Max, 0)
+	assert.InDelta(t, 3, *rollups[0].Last, 0)
+	assert.True(t, rollups[0].Start.Equal(rollups[0].Start.Truncate(24*time.Hour)))
+
+	status, rollups = getRollups("/rollup/counter/requests?step=24h")
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, rollups, 1)
+	assert.Equal(t, int64(7), *rollups[0].Increase)
+
+	status, rollups = getRollups("/rollup/counter/requests")
+	require.Equal(t, http.StatusOK, status)
+	assert.NotEmpty(t, rollups)
+
+	status, _ = getRollups("/rollup/gauge/cpu?host=a&step=1ms&from=0")
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = getRollups("/rollup/histogram/cpu?host=a")
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = getRollups("/rollup/gauge/requests")
+	assert.Equal(t, http.StatusNotFound, status)
+
+	// a client taking no gzip gets the rollups as they are
+	header, plain := identityGet(t, server, "/rollup/gauge/cpu?host=a&step=24h")
+	assert.Empty(t, header.Get("Content-Encoding"))
+	require.NoError(t, json.Unmarshal(plain, &rollups))
+	assert.Len(t, rollups, 1)
+
+	// no history without the history store
+	status, _ = getRollups("/range/gauge/cpu?host=a")
+	assert.Equal(t, http.StatusNotImplemented, status)
+}
+
+func TestRoutingRollupsWithHistory(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	var cfg config.ConsumerConfig
+	cfg.Store.HistoryPoints = 10
+	cfg.Store.RollupHourRetention = time.Hour
+
+	memoryStore, err := store.NewMemoryStore(cfg.Store)
+	require.NoError(t, err)
+
+	db := store.NewRollupStore(store.NewHistoryStore(memoryStore, cfg.Store), cfg.Store)
+	handler, err := consumer.NewHandler(service.NewConsumerService(db, cfg), cfg)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(handler.InitRoutes())
+
+	t.Cleanup(server.Close)
+
+	response, err := http.Post(server.URL+"/update/gauge/cpu/1", "text/plain", http.NoBody)
+	require.NoError(t, err)
+	require.NoError(t, response.Body.Close())
+
+	// the history is found under the rollups
+	for _, request := range []string{"/range/gauge/cpu", "/rollup/gauge/cpu"} {
+		response, err = http.Get(server.URL + request)
+		require.NoError(t, err)
+		require.NoError(t, response.Body.Close())
+		assert.Equal(t, http.StatusOK, response.StatusCode, request)
+	}
+
+	response, err = http.Get(server.URL + "/value/gauge/cpu")
+	require.NoError(t, err)
+	require.NoError(t, response.Body.Close())
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+}
+
+func TestRoutingExposition(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	var cfg config.ConsumerConfig
+	cfg.Consumer.Buckets = config.Buckets{0.1, 1}
+
+	db, err := store.NewMemoryStore(cfg.Store)
+	require.NoError(t, err)
+
+	handler, err := consumer.NewHandler(service.NewConsumerService(db, cfg), cfg)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(handler.InitRoutes())
+
+	t.Cleanup(server.Close)
+
+	for _, request := range []string{
+		"/update/gauge/cpu.load/0.5?host=" + url.QueryEscape(`a"b`),
+		"/update/gauge/cpu.load/1.5?host=c",
+		"/update/counter/requests/3",
+		"/update/counter/errors_total/1",
+		"/update/histogram/latency/0.3",
+		"/update/summary/size/2",
+		"/update/gauge/1st/7",
+	} {
+		response, err := http.Post(server.URL+request, "text/plain", http.NoBody)
+		require.NoError(t, err)
+		require.NoError(t, response.Body.Close())
+		require.Equal(t, http.StatusOK, response.StatusCode, request)
+	}
+
+	scrape := func(accept string) (string, string) {
+		request, err := http.NewRequest(http.MethodGet, server.URL+"/metrics", http.NoBody)
+		require.NoError(t, err)
+
+		if accept != "" {
+			request.Header.Set("Accept", accept)
+		}
+
+		response, err := http.DefaultClient.Do(request)
+		require.NoError(t, err)
+
+		defer response.Body.Close()
+
+		require.Equal(t, http.StatusOK, response.StatusCode)
+
+		body, err := io.ReadAll(response.Body)
+		require.NoError(t, err)
+
+		return response.Header.Get("Content-Type"), string(body)
+	}
+
+	contentType, body := scrape("")
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", contentType)
+	assert.Equal(t, `# TYPE _1st gauge
+_1st 7
+# TYPE cpu_load gauge
+cpu_load{host="a\"b"} 0.5
+cpu_load{host="c"} 1.5
+# TYPE errors_total counter
+errors_total 1
+# TYPE latency histogram
+latency_bucket{le="0.1"} 0
+latency_bucket{le="1"} 1
+latency_bucket{le="+Inf"} 1
+latency_sum 0.3
+latency_count 1
+# TYPE requests_total counter
+requests_total 3
+# TYPE size summary
+size{quantile="0.5"} 2
+size{quantile="0.9"} 2
+size{quantile="0.99"} 2
+size_sum 2
+size_count 1
+`, body)
+
+	// as Prometheus asks for it
+	contentType, body = scrape("application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5,*/*;q=0.1")
+	assert.Equal(t, "application/openmetrics-text; version=1.0.0; charset=utf-8", contentType)
+	assert.Contains(t, body, "# TYPE requests counter\nrequests_total 3\n")
+	assert.Contains(t, body, "# TYPE errors counter\nerrors_total 1\n")
+	assert.True(t, strings.HasSuffix(body, "# EOF\n"))
+
+	contentType, _ = scrape("text/plain;q=1, application/openmetrics-text;q=0.5")
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", contentType)
+}
+
+func TestRoutingRemoteWrite(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	var cfg config.ConsumerConfig
+	cfg.Consumer.RemoteWrite = true
+	cfg.Store.RollupMinuteRetention = time.Hour
+
+	memoryStore, err := store.NewMemoryStore(cfg.Store)
+	require.NoError(t, err)
+
+	db := store.NewRollupStore(memoryStore, cfg.Store)
+	handler, err := consumer.NewHandler(service.NewConsumerService(db, cfg), cfg)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(handler.InitRoutes())
+
+	t.Cleanup(server.Close)
+
+	write := func(body []byte) int {
+		request, err := http.NewRequest(http.MethodPost, server.URL+"/api/v1/write", bytes.NewReader(body))
+		require.NoError(t, err)
+
+		request.Header.Set("Content-Type", "application/x-protobuf")
+		request.Header.Set("Content-Encoding", "snappy")
+		request.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+		response, err := http.DefaultClient.Do(request)
+		require.NoError(t, err)
+		require.NoError(t, response.Body.Close())
+
+		return response.StatusCode
+	}
+
+	fixture := func(name string) []byte {
+		data, err := os.ReadFile(filepath.Join("testdata", "remotewrite", name))
+		require.NoError(t, err)
+
+		return data
+	}
+
+	value := func(metricType, name string, labels url.Values) (int, string) {
+		labels.Set("instance", "localhost:9090")
+		labels.Set("job", "prometheus")
+
+		response, err := http.Get(server.URL + "/value/" + metricType + "/" + name + "?" + labels.Encode())
+		require.NoError(t, err)
+
+		defer response.Body.Close()
+
+		body, err := io.ReadAll(response.Body)
+		require.NoError(t, err)
+
+		return response.StatusCode, string(body)
+	}
+
+	rollups := func(metricType, name string, labels url.Values) []service.Rollup {
+		labels.Set("instance", "localhost:9090")
+		labels.Set("job", "prometheus")
+
+		response, err := http.Get(server.URL + "/rollup/" + metricType + "/" + name + "?" + labels.Encode())
+		require.NoError(t, err)
+
+		defer response.Body.Close()
+
+		require.Equal(t, http.StatusOK, response.StatusCode, name)
+
+		var rollups []service.Rollup
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&rollups))
+
+		return rollups
+	}
+
+	requests := url.Values{"code": {"200"}, "handler": {"/metrics"}}
+
+	require.Equal(t, http.StatusNoContent, write(fixture("prometheus.bin")))
+
+	for _, tt := range []struct {
+		metricType string
+		name       string
+		labels     url.Values
+		want       string
+	}{
+		{metricType: "gauge", name: "up", labels: url.Values{}, want: "1"},
+		{metricType: "gauge", name: "go_goroutines", labels: url.Values{}, want: "35"},
+		// both samples of the series, as running totals
+		{metricType: "counter", name: "prometheus_http_requests_total", labels: requests, want: "42"},
+		{metricType: "counter", name: "process_cpu_seconds_total", labels: url.Values{}, want: "2"},
+		{metricType: "gauge", name: "go_gc_duration_seconds", labels: url.Values{"quantile": {"0.5"}}, want: "0.000123"},
+		{metricType: "gauge", name: "go_gc_duration_seconds_sum", labels: url.Values{}, want: "0.0123"},
+		{metricType: "counter", name: "go_gc_duration_seconds_count", labels: url.Values{}, want: "17"},
+	} {
+		status, body := value(tt.metricType, tt.name, tt.labels)
+		require.Equal(t, http.StatusOK, status, tt.name)
+		assert.Equal(t, tt.want, body, tt.name)
+	}
+
+	// the stale marker is no sample
+	status, _ := value("gauge", "prometheus_target_interval_length_seconds", url.Values{"interval": {"15s"}, "quantile": {"0.99"}})
+	assert.Equal(t, http.StatusNotFound, status)
+
+	// the counter follows a reset
+	require.Equal(t, http.StatusNoContent, write(fixture("reset.bin")))
+
+	_, body := value("counter", "prometheus_http_requests_total", requests)
+	assert.Equal(t, "5", body)
+
+	_, body = value("gauge", "go_goroutines", url.Values{})
+	assert.Equal(t, "31", body)
+
+	// the increase since the reset is the new total, not a drop
+	var increase int64
+
+	for _, rollup := range rollups("counter", "prometheus_http_requests_total", requests) {
+		assert.GreaterOrEqual(t, *rollup.Increase, int64(0))
+		increase += *rollup.Increase
+	}
+
+	assert.Equal(t, int64(42+5), increase)
+
+	// the rollups keep the time of the samples, a minute apart
+	gauges := rollups("gauge", "go_goroutines", url.Values{})
+	require.Len(t, gauges, 2)
+	assert.True(t, time.UnixMilli(1714557600000).Equal(gauges[0].Start))
+	assert.True(t, time.UnixMilli(1714557660000).Equal(gauges[1].Start))
+
+	// the same totals sent again add nothing, the older ones sent late are dropped
+	require.Equal(t, http.StatusNoContent, write(fixture("reset.bin")))
+	require.Equal(t, http.StatusNoContent, write(fixture("prometheus.bin")))
+
+	_, body = value("counter", "prometheus_http_requests_total", requests)
+	assert.Equal(t, "5", body)
+
+	assert.Equal(t, http.StatusBadRequest, write(fixture("unnamed.bin")))
+	assert.Equal(t, http.StatusBadRequest, write([]byte("not snappy")))
+	assert.Equal(t, http.StatusBadRequest, write(nil))
+}
+
+func TestRoutingRemoteWriteWithKeys(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	server := newKeyedServer(t)
+
+	data, err := os.ReadFile(filepath.Join("testdata", "remotewrite", "prometheus.bin"))
+	require.NoError(t, err)
+
+	request, err := http.NewRequest(http.MethodPost, server.URL+"/api/v1/write", bytes.NewReader(data))
+	require.NoError(t, err)
+
+	request.Header.Set("Content-Type", "application/x-protobuf")
+	request.Header.Set("Content-Encoding", "snappy")
+
+	response, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	require.NoError(t, response.Body.Close())
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	request, err = http.NewRequest(http.MethodPost, server.URL+"/api/v1/write", bytes.NewReader(data))
+	require.NoError(t, err)
+
+	request.Header.Set("Content-Type", "application/x-protobuf")
+	request.Header.Set("Content-Encoding", "snappy")
+	request.Header.Set("Authorization", "Bearer "+keyedWriteToken)
+
+	response, err = http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	require.NoError(t, response.Body.Close())
+
+	// Prometheus neither signs nor encrypts, the agent routes still need both
+	assert.Equal(t, http.StatusNoContent, response.StatusCode)
+	assert.Equal(t, "1", keyedValue(t, server, "/value/gauge/up?instance=localhost:9090&job=prometheus"))
+
+	response, err = http.Post(server.URL+"/update/gauge/up/2", "text/plain", strings.NewReader("x"))
+	require.NoError(t, err)
+	require.NoError(t, response.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+}
+
+func TestRoutingInfluxWriteWithKeys(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	server := newKeyedServer(t)
+
+	request, err := http.NewRequest(http.MethodPost, server.URL+"/write", strings.NewReader("cpu usage_idle=1\n"))
+	require.NoError(t, err)
+
+	request.Header.Set("Authorization", "Token wrong")
+
+	response, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	require.NoError(t, response.Body.Close())
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	var compressed bytes.Buffer
+
+	gzipWriter := gzip.NewWriter(&compressed)
+	_, err = gzipWriter.Write([]byte("cpu,host=web-1 usage_idle=92.5\n"))
+	require.NoError(t, err)
+	require.NoError(t, gzipWriter.Close())
+
+	// Telegraf neither signs nor encrypts, it may compress
+	request, err = http.NewRequest(http.MethodPost, server.URL+"/write", &compressed)
+	require.NoError(t, err)
+
+	request.Header.Set("Content-Encoding", "gzip")
+	request.SetBasicAuth("telegraf", keyedWriteToken)
+
+	response, err = http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	require.NoError(t, response.Body.Close())
+
+	assert.Equal(t, http.StatusNoContent, response.StatusCode)
+	assert.Equal(t, "92.5", keyedValue(t, server, "/value/gauge/cpu_usage_idle?host=web-1"))
+}
+
+func TestNewHandlerUnprotectedWrite(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	var subnets config.Subnets
+	require.NoError(t, subnets.Set("10.0.0.0/8"))
+
+	tests := []struct {
+		name     string
+		consumer config.Consumer
+		err      error
+	}{
+		{name: "keys without token", consumer: config.Consumer{Key: "secret", RemoteWrite: true}, err: consumer.ErrUnprotectedWrite},
+		{name: "keys with token", consumer: config.Consumer{Key: "secret", RemoteWrite: true, WriteToken: "token"}},
+		{name: "keys with subnet", consumer: config.Consumer{Key: "secret", RemoteWrite: true, TrustedSubnet: subnets}},
+		{name: "no keys", consumer: config.Consumer{RemoteWrite: true}},
+		{name: "influx keys without token", consumer: config.Consumer{Key: "secret", InfluxWrite: true}, err: consumer.ErrUnprotectedWrite},
+		{name: "influx keys with token", consumer: config.Consumer{Key: "secret", InfluxWrite: true, WriteToken: "token"}},
+		{name: "disabled", consumer: config.Consumer{Key: "secret"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := config.ConsumerConfig{Consumer: test.consumer}
+
+			db, err := store.NewMemoryStore(cfg.Store)
+			require.NoError(t, err)
+
+			_, err = consumer.NewHandler(service.NewConsumerService(db, cfg), cfg)
+			require.ErrorIs(t, err, test.err)
+		})
+	}
+}
+
+func TestRoutingWritesDisabled(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	var cfg config.ConsumerConfig
+
+	db, err := store.NewMemoryStore(cfg.Store)
+	require.NoError(t, err)
+
+	handler, err := consumer.NewHandler(service.NewConsumerService(db, cfg), cfg)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(handler.InitRoutes())
+
+	t.Cleanup(server.Close)
+
+	for _, path := range []string{"/api/v1/write", "/write"} {
+		response, err := http.Post(server.URL+path, "text/plain", strings.NewReader("x"))
+		require.NoError(t, err)
+		require.NoError(t, response.Body.Close())
+		assert.Equal(t, http.StatusNotFound, response.StatusCode, path)
+	}
+}
+
+// keyedWriteToken is the write token of newKeyedServer.
+const keyedWriteToken = "write-secret"
+
+// newKeyedServer serves a memory store with a signing key and a private key, as set by -k and -crypto-key,
+// and the write routes guarded by keyedWriteToken.
+func newKeyedServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	const keyBits = 2048
+
+	privateKey, err := hybrid.GenerateKey(keyBits)
+	require.NoError(t, err)
+
+	privatePEM, err := hybrid.EncodePrivateKey(privateKey)
+	require.NoError(t, err)
+
+	keyPath := filepath.Join(t.TempDir(), "private.pem")
+	require.NoError(t, os.WriteFile(keyPath, privatePEM, 0o600))
+
+	cfg := config.ConsumerConfig{Consumer: config.Consumer{
+		Key: "secret", CryptoKey: keyPath, RemoteWrite: true, InfluxWrite: true, WriteToken: keyedWriteToken,
+	}}
+
+	db, err := store.NewMemoryStore(cfg.Store)
+	require.NoError(t, err)
+
+	handler, err := consumer.NewHandler(service.NewConsumerService(db, cfg), cfg)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(handler.InitRoutes())
+
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// keyedValue gets the body of a successful GET, which needs neither signature nor encryption.
+func keyedValue(t *testing.T, server *httptest.Server, path string) string {
+	t.Helper()
+
+	response, err := http.Get(server.URL + path)
+	require.NoError(t, err)
+
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode, path)
+
+	return string(body)
+}
+
+// identityGet gets the header and the body of a successful GET that refuses compression.
+func identityGet(t *testing.T, server *httptest.Server, path string) (http.Header, []byte) {
+	t.Helper()
+
+	request, err := http.NewRequest(http.MethodGet, server.URL+path, http.NoBody)
+	require.NoError(t, err)
+
+	request.Header.Set("Accept-Encoding", "identity")
+
+	response, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode, path)
+
+	return response.Header, body
+}
+
+// identityPost posts a JSON body and gets the header and the body of a successful answer that refuses compression.
+func identityPost(t *testing.T, server *httptest.Server, path, body string) (http.Header, []byte) {
+	t.Helper()
+
+	request, err := http.NewRequest(http.MethodPost, server.URL+path, strings.NewReader(body))
+	require.NoError(t, err)
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept-Encoding", "identity")
+
+	response, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+
+	defer response.Body.Close()
+
+	answer, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode, path)
+
+	return response.Header, answer
+}
+
+func TestRoutingInfluxWrite(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	cfg := config.ConsumerConfig{Consumer: config.Consumer{InfluxWrite: true, InfluxRules: config.IntegerRules{
+		{Pattern: "net_bytes_*", MetricType: service.MetricCounter},
+	}}}
+
+	db, err := store.NewMemoryStore(cfg.Store)
+	require.NoError(t, err)
+
+	handler, err := consumer.NewHandler(service.NewConsumerService(db, cfg), cfg)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(handler.InitRoutes())
+
+	t.Cleanup(server.Close)
+
+	write := func(query, body string) (int, []consumer.LineResult) {
+		response, err := http.Post(server.URL+"/write"+query, "text/plain; charset=utf-8", strings.NewReader(body))
+		require.NoError(t, err)
+
+		defer response.Body.Close()
+
+		var results []consumer.LineResult
+		if strings.HasPrefix(response.Header.Get("Content-Type"), "application/json") {
+			require.NoError(t, json.NewDecoder(response.Body).Decode(&results))
+		}
+
+		return response.StatusCode, results
+	}
+
+	value := func(metricType, name, query string) string {
+		response, err := http.Get(server.URL + "/value/" + metricType + "/" + name + query)
+		require.NoError(t, err)
+
+		defer response.Body.Close()
+
+		body, err := io.ReadAll(response.Body)
+		require.NoError(t, err)
+
+		require.Equal(t, http.StatusOK, response.StatusCode, name)
+
+		return string(body)
+	}
+
+	status, _ := write("?precision=s", `# telegraf
+cpu,cpu=cpu-total,host=web-1 usage_idle=92.5,usage_user=3 1700000000
+net,host=web-1,interface=eth0 bytes_recv=1000i,bytes_sent=200u,err_in=0i,up=true 1700000000
+system,host=web-1 uptime_format="1 day,  2:03",load1=0.25
+weather,location=us\,midwest temperature=82,note="a\"b=c"
+`)
+	require.Equal(t, http.StatusNoContent, status)
+
+	assert.Equal(t, "92.5", value(service.MetricGauge, "cpu_usage_idle", "?cpu=cpu-total&host=web-1"))
+	assert.Equal(t, "3", value(service.MetricGauge, "cpu_usage_user", "?cpu=cpu-total&host=web-1"))
+	assert.Equal(t, "1000", value(service.MetricCounter, "net_bytes_recv", "?host=web-1&interface=eth0"))
+	assert.Equal(t, "200", value(service.MetricCounter, "net_bytes_sent", "?host=web-1&interface=eth0"))
+	assert.Equal(t, "0", value(service.MetricGauge, "net_err_in", "?host=web-1&interface=eth0"))
+	assert.Equal(t, "1", value(service.MetricGauge, "net_up", "?host=web-1&interface=eth0"))
+	assert.Equal(t, "0.25", value(service.MetricGauge, "system_load1", "?host=web-1"))
+	assert.Equal(t, "82", value(service.MetricGauge, "weather_temperature", "?location="+url.QueryEscape("us,midwest")))
+
+	// the counters carry running totals, the malformed lines leave the others stored
+	status, results := write("", `net,host=web-1,interface=eth0 bytes_recv=1500i
+cpu,host=web-1
+cpu,host=web-1 usage_idle=abc
+cpu,host-name=web-1 usage_idle=1
+cpu,host=web-1 usage_idle=90 noon
+cpu,host=web-1 usage_idle=91`)
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Len(t, results, 6)
+
+	for i, result := range results {
+		assert.Equal(t, i+1, result.Line)
+
+		if i == 0 || i == 5 {
+			assert.Equal(t, "ok", result.Status)
+			assert.Empty(t, result.Error)
+		} else {
+			assert.Equal(t, "invalid", result.Status, result.Line)
+			assert.NotEmpty(t, result.Error)
+		}
+	}
+
+	assert.Equal(t, "1500", value(service.MetricCounter, "net_bytes_recv", "?host=web-1&interface=eth0"))
+	assert.Equal(t, "91", value(service.MetricGauge, "cpu_usage_idle", "?host=web-1"))
+
+	status, _ = write("?precision=fortnight", "cpu usage_idle=1")
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = write("", "")
+	assert.Equal(t, http.StatusNoContent, status)
+}
+
+func TestRoutingInfluxWriteTimestamps(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	cfg := config.ConsumerConfig{Consumer: config.Consumer{InfluxWrite: true, InfluxRules: config.IntegerRules{
+		{Pattern: "net_bytes_*", MetricType: service.MetricCounter},
+	}}}
+	cfg.Store.HistoryPoints = 10
+	cfg.Store.RollupMinuteRetention = time.Hour
+
+	memoryStore, err := store.NewMemoryStore(cfg.Store)
+	require.NoError(t, err)
+
+	db := store.NewRollupStore(store.NewHistoryStore(memoryStore, cfg.Store), cfg.Store)
+	handler, err := consumer.NewHandler(service.NewConsumerService(db, cfg), cfg)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(handler.InitRoutes())
+
+	t.Cleanup(server.Close)
+
+	// the lines come a minute apart, the later one first: the late counter total is dropped, not taken for a reset
+	for _, write := range []struct{ query, body string }{
+		{"?precision=s", "cpu usage_idle=2 1700000060\nnet bytes_recv=1500i 1700000060"},
+		{"?precision=ms", "cpu usage_idle=1 1700000000000\nnet bytes_recv=1000i 1700000000000"},
+	} {
+		response, err := http.Post(server.URL+"/write"+write.query, "text/plain; charset=utf-8", strings.NewReader(write.body))
+		require.NoError(t, err)
+		require.NoError(t, response.Body.Close())
+		require.Equal(t, http.StatusNoContent, response.StatusCode, write.query)
+	}
+
+	get := func(request string, v any) {
+		response, err := http.Get(server.URL + request)
+		require.NoError(t, err)
+
+		defer response.Body.Close()
+
+		require.Equal(t, http.StatusOK, response.StatusCode, request)
+		require.NoError(t, json.NewDecoder(response.Body).Decode(v))
+	}
+
+	var points []service.Point
+	get("/range/gauge/cpu_usage_idle", &points)
+	require.Len(t, points, 2)
+	assert.True(t, time.Unix(1700000000, 0).Equal(points[0].Time))
+	assert.InDelta(t, 1, *points[0].Value, 0)
+	assert.True(t, time.Unix(1700000060, 0).Equal(points[1].Time))
+	assert.InDelta(t, 2, *points[1].Value, 0)
+
+	assert.Equal(t, "1500", keyedValue(t, server, "/value/counter/net_bytes_recv"))
+
+	var rollups []service.Rollup
+	get("/rollup/counter/net_bytes_recv", &rollups)
+	require.Len(t, rollups, 1)
+	assert.True(t, time.Unix(1700000060, 0).Truncate(time.Minute).Equal(rollups[0].Start))
+	require.NotNil(t, rollups[0].Increase)
+	assert.Equal(t, int64(1500), *rollups[0].Increase)
+
+	get("/rollup/gauge/cpu_usage_idle", &rollups)
+	require.Len(t, rollups, 2)
+	assert.InDelta(t, 1, *rollups[0].Last, 0)
+	assert.InDelta(t, 2, *rollups[1].Last, 0)
+
+	// a timestamp beyond the time the precision can tell is a malformed line
+	response, err := http.Post(server.URL+"/write?precision=h", "text/plain; charset=utf-8", strings.NewReader("cpu usage_idle=1 9223372036854775"))
+	require.NoError(t, err)
+	require.NoError(t, response.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+}
@@ -22,14 +22,31 @@ func Run(cfg config.ConsumerConfig) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	if cfg.Store.FileStoragePath == "" || cfg.Store.StoreInterval != 0 {
+	switch {
+	case cfg.Store.DatabaseDSN != "":
+		db, err = store.NewPostgresStore(ctx, cfg.Store)
+		if err != nil {
+			return fmt.Errorf("create postgres store: %w", err)
+		}
+
+		defer db.Close()
+	case cfg.Store.Storage != "":
+		db, err = newStorage(ctx, cfg.Store)
+		if err != nil {
+			return fmt.Errorf("create storage: %w", err)
+		}
+
+		defer db.Close()
+	case cfg.Store.FileStoragePath == "" || cfg.Store.StoreInterval != 0:
 		db, err = store.NewMemoryStore(cfg.Store)
 		if err != nil {
 			return fmt.Errorf("create memory store: %w", err)
 		}
 
-		go autosave(ctx, cfg.Store, db)
-	} else {
+		if usesSnapshots(cfg.Store) {
+			go autosave(ctx, cfg.Store, db)
+		}
+	default:
 		db, err = store.NewFileStore(cfg.Store)
 		if err != nil {
 			return fmt.Errorf("create file store: %w", err)
@@ -38,11 +55,51 @@ func Run(cfg config.ConsumerConfig) error {
 		defer db.Close()
 	}
 
+	// the chunk store keeps the whole history itself
+	if _, ok := db.(service.RangeStore); !ok && cfg.Store.HistoryRetention > 0 {
+		db = store.NewHistoryStore(db, cfg.Store)
+	}
+
+	if cfg.Store.RollupMinuteRetention > 0 || cfg.Store.RollupHourRetention > 0 {
+		rollupStore := store.NewRollupStore(db, cfg.Store)
+
+		go expireRollups(ctx, rollupStore)
+
+		db = rollupStore
+	}
+
 	go gracefulShutdown(ctx, cancel, cfg.Store, db)
 
 	consumer := service.NewConsumerService(db, cfg)
 
-	handler := NewHandler(consumer)
+	handler, err := NewHandler(consumer, cfg)
+	if err != nil {
+		return fmt.Errorf("create handler: %w", err)
+	}
+
+	if cfg.Consumer.StatsD != "" {
+		statsd, errStatsD := NewStatsD(consumer, cfg.Consumer)
+		if errStatsD != nil {
+			return fmt.Errorf("create statsd listener: %w", errStatsD)
+		}
+
+		served := make(chan struct{})
+
+		go func() {
+			defer close(served)
+
+			if errServe := statsd.Serve(ctx); errServe != nil {
+				log.Error("statsd error", //nolint:contextcheck // no ctx
+					log.ErrAttr(errServe))
+			}
+		}()
+
+		// the last timers and sets are flushed before the store closes
+		defer func() {
+			cancel()
+			<-served
+		}()
+	}
 
 	if err = RunServer(ctx, handler, cfg); err != nil {
 		return fmt.Errorf("run server: %w", err)
@@ -51,6 +108,35 @@ func Run(cfg config.ConsumerConfig) error {
 	return nil
 }
 
+func newStorage(ctx context.Context, cfg config.Store) (service.Store, error) {
+	scheme, path := cfg.StorageURL()
+
+	switch scheme {
+	case "sqlite":
+		db, err := store.NewSQLiteStore(ctx, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("create sqlite store: %w", err)
+		}
+
+		return db, nil
+	case "chunks":
+		db, err := store.NewChunkStore(path, cfg.ChunkFlushInterval)
+		if err != nil {
+			return nil, fmt.Errorf("create chunk store: %w", err)
+		}
+
+		return db, nil
+	default:
+		return nil, fmt.Errorf("storage %s: %w", cfg.Storage, service.ErrUnknownDBType)
+	}
+}
+
+// usesSnapshots reports whether the metrics are kept in memory and saved to FileStoragePath periodically.
+// The file store writes its log on every update and needs no snapshot on shutdown.
+func usesSnapshots(cfg config.Store) bool {
+	return cfg.DatabaseDSN == "" && cfg.Storage == "" && cfg.FileStoragePath != "" && cfg.StoreInterval != 0
+}
+
 func autosave(ctx context.Context, cfg config.Store, db service.Store) {
 	tickSave := time.NewTicker(time.Duration(cfg.StoreInterval) * time.Second)
 	defer tickSave.Stop()
@@ -70,34 +156,24 @@ func autosave(ctx context.Context, cfg config.Store, db service.Store) {
 	}
 }
 
-func saveAll(cfg config.Store, db service.Store) error {
-	fileStore, err := store.NewFileStore(cfg)
-	if err != nil {
-		return fmt.Errorf("create file store: %w", err)
+// expireRollups drops the expired rollups every minute, the step of the finest tier.
+func expireRollups(ctx context.Context, rollupStore *store.RollupStore) {
+	tickExpire := time.NewTicker(time.Minute)
+	defer tickExpire.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-tickExpire.C:
+			rollupStore.Expire(now)
+		}
 	}
+}
 
-	defer fileStore.Close()
-
-	metrics := db.GetAllMetrics()
-
-	for _, metric := range metrics {
-		switch metric.MetricType {
-		case service.MetricCounter:
-			err = fileStore.AddCounter(metric, false)
-			if err != nil {
-				log.Error("add counter to file store",
-					log.ErrAttr(err))
-			}
-		case service.MetricGauge:
-			err = fileStore.AddGauge(metric)
-			if err != nil {
-				log.Error("add gauge to file store",
-					log.ErrAttr(err))
-			}
-		default:
-			log.Error("unknown metric type",
-				log.ErrAttr(service.ErrUnknownMetricType))
-		}
+func saveAll(cfg config.Store, db service.Store) error {
+	if err := store.SaveSnapshot(cfg, db.GetAllMetrics()); err != nil {
+		return fmt.Errorf("save snapshot: %w", err)
 	}
 
 	log.Debug("All metrics saved")
@@ -122,6 +198,10 @@ func gracefulShutdown(ctx context.Context, cancel context.CancelFunc, cfg config
 
 		<-ctx.Done()
 
+		if !usesSnapshots(cfg) {
+			return
+		}
+
 		err := saveAll(cfg, db) //nolint:contextcheck // no ctx
 		if err != nil {
 			log.Error("error saving data", //nolint:contextcheck // no ctx
@@ -0,0 +1,381 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"metrics/config"
+	"metrics/internal/consumer/internal/service"
+	"metrics/internal/log"
+)
+
+const (
+	statsdCounter   = "c"
+	statsdGauge     = "g"
+	statsdTimer     = "ms"
+	statsdHistogram = "h"
+	statsdDistrib   = "d"
+	statsdSet       = "s"
+
+	// maxStatsDPacket is the largest UDP payload, StatsD clients keep theirs under the MTU.
+	maxStatsDPacket = 65535
+
+	// minStatsDTimerRate bounds the observations a sampled timer stands for, 1000 at most.
+	minStatsDTimerRate = 0.001
+)
+
+var (
+	ErrInvalidStatsD       = errors.New("invalid statsd line")
+	ErrUnknownStatsDScheme = errors.New("unknown statsd scheme")
+)
+
+// StatsD receives StatsD datagrams and stores their metrics through the consumer service.
+// Counters and gauges are stored as they arrive. Timers, DogStatsD histograms and distributions
+// are collected into summaries and sets into the gauge of their size, both stored every flush interval.
+type StatsD struct {
+	service  service.Consumer
+	conn     net.PacketConn
+	socket   string
+	interval time.Duration
+	mu       sync.Mutex
+	timers   map[string]*statsdAggregate
+	sets     map[string]*statsdAggregate
+}
+
+// statsdAggregate is a timer or a set collected since the last flush.
+type statsdAggregate struct {
+	name         string
+	labels       service.Labels
+	observations []float64
+	members      map[string]struct{}
+}
+
+// statsdLine is a parsed line, as in requests:1|c|@0.5|#host:a.
+type statsdLine struct {
+	name       string
+	value      string
+	metricType string
+	rate       float64
+	labels     service.Labels
+}
+
+// NewStatsD listens on the URL of cfg.StatsD. A unix socket left over by a previous run is replaced.
+func NewStatsD(consumer service.Consumer, cfg config.Consumer) (*StatsD, error) {
+	network, address := cfg.StatsDURL()
+
+	statsd := &StatsD{
+		service:  consumer,
+		conn:     nil,
+		socket:   "",
+		interval: cfg.StatsDFlush,
+		mu:       sync.Mutex{},
+		timers:   map[string]*statsdAggregate{},
+		sets:     map[string]*statsdAggregate{},
+	}
+
+	switch network {
+	case "udp":
+	case "unixgram":
+		if err := os.Remove(address); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("remove statsd socket: %w", err)
+		}
+
+		statsd.socket = address
+	default:
+		return nil, fmt.Errorf("statsd %s: %w", cfg.StatsD, ErrUnknownStatsDScheme)
+	}
+
+	conn, err := net.ListenPacket(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("listen statsd: %w", err)
+	}
+
+	statsd.conn = conn
+
+	return statsd, nil
+}
+
+// Addr is the address the listener is bound to, with the port chosen for :0.
+func (s *StatsD) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Serve reads datagrams until the context is done, then flushes the collected timers and sets
+// and closes the listener.
+func (s *StatsD) Serve(ctx context.Context) error {
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		s.flushEvery(ctx)
+
+		// unblocks ReadFrom
+		if err := s.conn.Close(); err != nil {
+			log.Error("error closing statsd listener", //nolint:contextcheck // no ctx
+				log.ErrAttr(err))
+		}
+	}()
+
+	log.Info("statsd starting",
+		log.StringAttr("address", s.Addr().String()),
+		log.DurationAttr("flush interval", s.interval))
+
+	err := s.read()
+
+	wg.Wait()
+
+	s.flush()
+
+	if s.socket != "" {
+		if errRemove := os.Remove(s.socket); errRemove != nil && !errors.Is(errRemove, os.ErrNotExist) {
+			log.Error("error removing statsd socket",
+				log.ErrAttr(errRemove))
+		}
+	}
+
+	return err
+}
+
+func (s *StatsD) read() error {
+	packet := make([]byte, maxStatsDPacket)
+
+	for {
+		n, _, err := s.conn.ReadFrom(packet)
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("read statsd: %w", err)
+		}
+
+		for _, line := range strings.Split(string(packet[:n]), "\n") {
+			if line = strings.TrimSpace(line); line == "" {
+				continue
+			}
+
+			if err = s.handleLine(line); err != nil {
+				log.Debug("statsd line skipped",
+					log.StringAttr("line", line),
+					log.ErrAttr(err))
+			}
+		}
+	}
+}
+
+func (s *StatsD) flushEvery(ctx context.Context) {
+	tickFlush := time.NewTicker(s.interval)
+	defer tickFlush.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tickFlush.C:
+			s.flush()
+		}
+	}
+}
+
+func (s *StatsD) handleLine(line string) error {
+	parsed, err := parseStatsDLine(line)
+	if err != nil {
+		return err
+	}
+
+	switch parsed.metricType {
+	case statsdCounter:
+		value, errParse := strconv.ParseFloat(parsed.value, 64)
+		if errParse != nil || math.IsNaN(value) || math.Abs(value/parsed.rate) >= math.MaxInt64 {
+			return fmt.Errorf("counter value %q: %w", parsed.value, ErrInvalidStatsD)
+		}
+
+		if _, err = s.service.AddCounter(parsed.name, int64(math.Round(value/parsed.rate)), parsed.labels); err != nil {
+			return fmt.Errorf("statsd counter: %w", err)
+		}
+	case statsdGauge:
+		return s.addGauge(parsed)
+	case statsdTimer, statsdHistogram, statsdDistrib:
+		value, errParse := strconv.ParseFloat(parsed.value, 64)
+		if errParse != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+			return fmt.Errorf("timer value %q: %w", parsed.value, ErrInvalidStatsD)
+		}
+
+		if parsed.rate < minStatsDTimerRate {
+			return fmt.Errorf("timer sample rate %v: %w", parsed.rate, ErrInvalidStatsD)
+		}
+
+		// a sampled observation also stands for the ones not sent, latency:12|ms|@0.1 is observed 10 times
+		weight := int(math.Round(1 / parsed.rate))
+
+		s.mu.Lock()
+		timer := s.aggregate(s.timers, parsed)
+
+		for range weight {
+			timer.observations = append(timer.observations, value)
+		}
+
+		s.mu.Unlock()
+	case statsdSet:
+		s.mu.Lock()
+		set := s.aggregate(s.sets, parsed)
+		set.members[parsed.value] = struct{}{}
+		s.mu.Unlock()
+	default:
+		return fmt.Errorf("type %q: %w", parsed.metricType, ErrInvalidStatsD)
+	}
+
+	return nil
+}
+
+// addGauge sets the gauge, or changes it when the value is signed, as in temperature:-2|g.
+// A change of a gauge not stored yet starts from zero.
+func (s *StatsD) addGauge(parsed statsdLine) error {
+	value, err := strconv.ParseFloat(parsed.value, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return fmt.Errorf("gauge value %q: %w", parsed.value, ErrInvalidStatsD)
+	}
+
+	if strings.HasPrefix(parsed.value, "+") || strings.HasPrefix(parsed.value, "-") {
+		matchers := make([]service.Matcher, 0, len(parsed.labels))
+
+		for name, labelValue := range parsed.labels {
+			matcher, errMatcher := service.NewMatcher(service.MatchEqual, name, labelValue)
+			if errMatcher != nil {
+				return fmt.Errorf("gauge label: %w", errMatcher)
+			}
+
+			matchers = append(matchers, matcher)
+		}
+
+		// the matchers also select metrics with more labels, only the gauge itself counts
+		stored, errGet := s.service.GetMetric(parsed.name, matchers...)
+		if errGet == nil && stored.MetricType == service.MetricGauge && stored.Key() == parsed.key() {
+			value += *stored.Value
+		}
+	}
+
+	if _, err = s.service.AddGauge(parsed.name, value, parsed.labels); err != nil {
+		return fmt.Errorf("statsd gauge: %w", err)
+	}
+
+	return nil
+}
+
+// aggregate returns the timer or set of the line, adding it if it is the first since the flush. Needs s.mu.
+func (s *StatsD) aggregate(aggregates map[string]*statsdAggregate, parsed statsdLine) *statsdAggregate {
+	aggregate, ok := aggregates[parsed.key()]
+	if !ok {
+		aggregate = &statsdAggregate{name: parsed.name, labels: parsed.labels, observations: nil, members: map[string]struct{}{}}
+		aggregates[parsed.key()] = aggregate
+	}
+
+	return aggregate
+}
+
+// flush stores the timers as summaries and the sets as gauges of the distinct values seen, then starts over.
+func (s *StatsD) flush() {
+	s.mu.Lock()
+	timers, sets := s.timers, s.sets
+	s.timers, s.sets = map[string]*statsdAggregate{}, map[string]*statsdAggregate{}
+	s.mu.Unlock()
+
+	for _, timer := range timers {
+		summary := service.Summary{Observations: timer.observations} //nolint:exhaustruct // raw observations
+
+		if _, err := s.service.AddSummary(timer.name, summary, timer.labels); err != nil {
+			log.Error("error adding statsd timer",
+				log.StringAttr("name", timer.name),
+				log.ErrAttr(err))
+		}
+	}
+
+	for _, set := range sets {
+		if _, err := s.service.AddGauge(set.name, float64(len(set.members)), set.labels); err != nil {
+			log.Error("error adding statsd set",
+				log.StringAttr("name", set.name),
+				log.ErrAttr(err))
+		}
+	}
+
+	log.Debug("statsd flushed",
+		log.IntAttr("timers", len(timers)),
+		log.IntAttr("sets", len(sets)))
+}
+
+func (l statsdLine) key() string {
+	return service.Metric{ID: l.name, Labels: l.labels}.Key() //nolint:exhaustruct // key only
+}
+
+// parseStatsDLine parses name:value|type with the optional sample rate @rate and DogStatsD tags #name:value,...
+// A tag without a value is dropped, as a label needs one.
+func parseStatsDLine(line string) (statsdLine, error) {
+	parsed := statsdLine{name: "", value: "", metricType: "", rate: 1, labels: nil}
+
+	nameValue, rest, found := strings.Cut(line, "|")
+	if !found {
+		return statsdLine{}, fmt.Errorf("no type: %w", ErrInvalidStatsD)
+	}
+
+	parsed.name, parsed.value, found = strings.Cut(nameValue, ":")
+	if !found || parsed.name == "" || parsed.value == "" {
+		return statsdLine{}, fmt.Errorf("no name or value: %w", ErrInvalidStatsD)
+	}
+
+	fields := strings.Split(rest, "|")
+	parsed.metricType = fields[0]
+
+	for _, field := range fields[1:] {
+		switch {
+		case strings.HasPrefix(field, "@"):
+			rate, err := strconv.ParseFloat(field[1:], 64)
+			if err != nil || !(rate > 0 && rate <= 1) {
+				return statsdLine{}, fmt.Errorf("sample rate %q: %w", field, ErrInvalidStatsD)
+			}
+
+			parsed.rate = rate
+		case strings.HasPrefix(field, "#"):
+			labels, err := parseStatsDTags(field[1:])
+			if err != nil {
+				return statsdLine{}, err
+			}
+
+			parsed.labels = labels
+		}
+	}
+
+	return parsed, nil
+}
+
+func parseStatsDTags(tags string) (service.Labels, error) {
+	var labels service.Labels
+
+	for _, tag := range strings.Split(tags, ",") {
+		name, value, found := strings.Cut(tag, ":")
+		if !found || value == "" {
+			continue
+		}
+
+		if labels == nil {
+			labels = service.Labels{}
+		}
+
+		labels[name] = value
+	}
+
+	if err := labels.Validate(); err != nil {
+		return nil, fmt.Errorf("tags: %w", err)
+	}
+
+	return labels, nil
+}
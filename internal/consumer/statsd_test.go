@@ -0,0 +1,168 @@
+package consumer_test
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"metrics/config"
+	"metrics/internal/consumer"
+	"metrics/internal/consumer/internal/service"
+	"metrics/internal/consumer/internal/store"
+)
+
+func TestStatsD(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	cfg := config.ConsumerConfig{Consumer: config.Consumer{StatsD: "udp://127.0.0.1:0", StatsDFlush: time.Hour}}
+	db, err := store.NewMemoryStore(cfg.Store)
+	require.NoError(t, err)
+
+	consumerService := service.NewConsumerService(db, cfg)
+
+	statsd, err := consumer.NewStatsD(consumerService, cfg.Consumer)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	served := make(chan error, 1)
+
+	go func() {
+		served <- statsd.Serve(ctx)
+	}()
+
+	conn, err := net.Dial("udp", statsd.Addr().String())
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = conn.Close() })
+
+	for _, packet := range []string{
+		"requests:1|c\nrequests:2|c|@0.5",
+		"requests:1|c|#host:a,canary",
+		"temperature:20|g",
+		"temperature:+3|g\ntemperature:-1.5|g",
+		"queue:-4|g|#host:a",
+		"latency:10|ms\nlatency:30|ms\nlatency:20|h|#host:a",
+		"queries:12|ms|@0.1\nqueries:5|d|@0.0001",
+		"users:alice|s\nusers:bob|s\nusers:alice|s",
+		"broken\nrequests:x|c\nrequests:1|c|@2\nrequests:1|c|#bad-tag:a\nrequests:1|x",
+		"done:1|c",
+	} {
+		_, err = conn.Write([]byte(packet))
+		require.NoError(t, err)
+	}
+
+	// a datagram is read after the previous ones
+	require.Eventually(t, func() bool {
+		_, errGet := consumerService.GetMetric("done")
+
+		return errGet == nil
+	}, time.Second, 10*time.Millisecond)
+
+	requests, err := consumerService.GetMetric("requests")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), *requests.Delta, "the sample rate scales the increment")
+
+	requests, err = consumerService.GetMetric("requests", labelEqual(t, "host", "a"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), *requests.Delta, "a tag without a value is dropped")
+
+	temperature, err := consumerService.GetMetric("temperature")
+	require.NoError(t, err)
+	assert.InDelta(t, 21.5, *temperature.Value, 0)
+
+	queue, err := consumerService.GetMetric("queue", labelEqual(t, "host", "a"))
+	require.NoError(t, err)
+	assert.InDelta(t, -4, *queue.Value, 0)
+
+	// timers and sets wait for the flush
+	_, err = consumerService.GetMetric("latency")
+	require.ErrorIs(t, err, service.ErrMetricNotFound)
+
+	cancel()
+	require.NoError(t, <-served)
+
+	latency, err := consumerService.GetMetric("latency")
+	require.NoError(t, err)
+	assert.Equal(t, service.MetricSummary, latency.MetricType)
+	assert.Equal(t, uint64(2), latency.Summary.Count)
+	assert.InDelta(t, 40, latency.Summary.Sum, 0)
+
+	latency, err = consumerService.GetMetric("latency", labelEqual(t, "host", "a"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), latency.Summary.Count)
+
+	queries, err := consumerService.GetMetric("queries")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(10), queries.Summary.Count, "the sample rate scales the observations")
+	assert.InDelta(t, 120, queries.Summary.Sum, 1e-9)
+
+	users, err := consumerService.GetMetric("users")
+	require.NoError(t, err)
+	assert.InDelta(t, 2, *users.Value, 0)
+}
+
+func TestStatsDUnixgram(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	socket := filepath.Join(t.TempDir(), "statsd.sock")
+
+	cfg := config.ConsumerConfig{Consumer: config.Consumer{StatsD: "unixgram://" + socket, StatsDFlush: 10 * time.Millisecond}}
+	db, err := store.NewMemoryStore(cfg.Store)
+	require.NoError(t, err)
+
+	consumerService := service.NewConsumerService(db, cfg)
+
+	statsd, err := consumer.NewStatsD(consumerService, cfg.Consumer)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	served := make(chan error, 1)
+
+	go func() {
+		served <- statsd.Serve(ctx)
+	}()
+
+	conn, err := net.Dial("unixgram", socket)
+	require.NoError(t, err)
+
+	_, err = conn.Write([]byte("latency:10|ms"))
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	// the flush interval stores the timer without a shutdown
+	require.Eventually(t, func() bool {
+		_, errGet := consumerService.GetMetric("latency")
+
+		return errGet == nil
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-served)
+
+	assert.NoFileExists(t, socket)
+
+	_, err = consumer.NewStatsD(consumerService, config.Consumer{StatsD: "tcp://127.0.0.1:0"}) //nolint:exhaustruct // statsd only
+	require.ErrorIs(t, err, consumer.ErrUnknownStatsDScheme)
+}
+
+func labelEqual(t *testing.T, name, value string) service.Matcher {
+	t.Helper()
+
+	matcher, err := service.NewMatcher(service.MatchEqual, name, value)
+	require.NoError(t, err)
+
+	return matcher
+}
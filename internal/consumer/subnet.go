@@ -0,0 +1,36 @@
+package consumer
+
+import (
+	"net/http"
+	"net/netip"
+
+	"metrics/config"
+	"metrics/internal/log"
+)
+
+const headerRealIP = "X-Real-IP"
+
+// WithTrustedSubnet lets through only agents whose X-Real-IP is inside the trusted subnets.
+// An empty list disables the check.
+func WithTrustedSubnet(subnets config.Subnets) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(subnets) == 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			addr, err := netip.ParseAddr(r.Header.Get(headerRealIP))
+			if err != nil || !subnets.Contains(addr) {
+				log.Debug("agent is not trusted", //nolint:contextcheck // no ctx
+					log.StringAttr("real ip", r.Header.Get(headerRealIP)),
+					log.StringAttr("remote addr", r.RemoteAddr))
+
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package consumer
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"metrics/config"
+	"metrics/internal/certs"
+)
+
+// newTLSConfig returns nil when TLS is not configured. The certificate is reloaded on change,
+// and with a client CA bundle every client must present a certificate signed by it.
+func newTLSConfig(cfg config.Consumer) (*tls.Config, error) {
+	if cfg.TLSCert == "" {
+		return nil, nil //nolint:nilnil // plain http
+	}
+
+	reloader, err := certs.NewReloader(cfg.TLSCert, cfg.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("load server certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{ //nolint:exhaustruct // defaults are fine
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+		ClientAuth:     tls.NoClientCert,
+	}
+
+	if cfg.TLSClientCA != "" {
+		clientCAs, err := certs.LoadCertPool(cfg.TLSClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("load client ca: %w", err)
+		}
+
+		tlsConfig.ClientCAs = clientCAs
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,46 @@
+package consumer
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"metrics/internal/log"
+)
+
+// WithWriteToken lets through only writers sending the token as a bearer token, an InfluxDB token
+// or the basic auth password, the credentials Prometheus and Telegraf can send. An empty token disables the check.
+func WithWriteToken(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if token == "" {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if subtle.ConstantTimeCompare([]byte(writeToken(r)), []byte(token)) != 1 {
+				log.Debug("writer is not authorized", //nolint:contextcheck // no ctx
+					log.StringAttr("remote addr", r.RemoteAddr))
+
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// writeToken gets the credential of a request, empty when there is none.
+func writeToken(r *http.Request) string {
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if strings.EqualFold(scheme, "Bearer") || strings.EqualFold(scheme, "Token") {
+		return token
+	}
+
+	return ""
+}
@@ -0,0 +1,196 @@
+// Package hybrid encrypts agent payloads for the server: every payload is sealed with a random
+// AES-256-GCM key, which is wrapped with the server RSA public key using OAEP with SHA-256.
+//
+// Envelope layout: version (1 byte) | wrapped key length (2 bytes, big endian) | wrapped key | nonce | sealed payload.
+package hybrid
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+const (
+	// Header marks encrypted requests, its value names the scheme.
+	Header = "X-Encryption"
+	Scheme = "rsa-oaep-sha256+aes-256-gcm"
+
+	version       = 1
+	aesKeySize    = 32
+	keyLengthSize = 2
+	headerSize    = 1 + keyLengthSize
+
+	privateKeyType = "PRIVATE KEY"
+	publicKeyType  = "PUBLIC KEY"
+)
+
+var (
+	ErrInvalidEnvelope = errors.New("invalid envelope")
+	ErrInvalidKey      = errors.New("invalid key")
+)
+
+func GenerateKey(bits int) (*rsa.PrivateKey, error) {
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, fmt.Errorf("generate rsa key: %w", err)
+	}
+
+	return key, nil
+}
+
+func EncodePrivateKey(key *rsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("marshal private key: %w", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: privateKeyType, Headers: nil, Bytes: der}), nil
+}
+
+func EncodePublicKey(key *rsa.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("marshal public key: %w", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: publicKeyType, Headers: nil, Bytes: der}), nil
+}
+
+func LoadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	der, err := readPEM(path, privateKeyType)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("parse private key %s: %w", path, err)
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key %s is not rsa: %w", path, ErrInvalidKey)
+	}
+
+	return rsaKey, nil
+}
+
+func LoadPublicKey(path string) (*rsa.PublicKey, error) {
+	der, err := readPEM(path, publicKeyType)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("parse public key %s: %w", path, err)
+	}
+
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key %s is not rsa: %w", path, ErrInvalidKey)
+	}
+
+	return rsaKey, nil
+}
+
+func Encrypt(key *rsa.PublicKey, plaintext []byte) ([]byte, error) {
+	aesKey := make([]byte, aesKeySize)
+	if _, err := rand.Read(aesKey); err != nil {
+		return nil, fmt.Errorf("generate aes key: %w", err)
+	}
+
+	wrappedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, key, aesKey, nil)
+	if err != nil {
+		return nil, fmt.Errorf("wrap aes key: %w", err)
+	}
+
+	aead, err := newAEAD(aesKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+
+	envelope := make([]byte, headerSize, headerSize+len(wrappedKey)+len(nonce)+len(plaintext)+aead.Overhead())
+	envelope[0] = version
+	binary.BigEndian.PutUint16(envelope[1:], uint16(len(wrappedKey))) //nolint:gosec // rsa key size fits
+	envelope = append(envelope, wrappedKey...)
+	envelope = append(envelope, nonce...)
+
+	return aead.Seal(envelope, nonce, plaintext, nil), nil
+}
+
+func Decrypt(key *rsa.PrivateKey, envelope []byte) ([]byte, error) {
+	if len(envelope) < headerSize || envelope[0] != version {
+		return nil, ErrInvalidEnvelope
+	}
+
+	keyLength := int(binary.BigEndian.Uint16(envelope[1:headerSize]))
+	if len(envelope) < headerSize+keyLength {
+		return nil, ErrInvalidEnvelope
+	}
+
+	wrappedKey := envelope[headerSize : headerSize+keyLength]
+
+	aesKey, err := rsa.DecryptOAEP(sha256.New(), nil, key, wrappedKey, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap aes key: %w", err)
+	}
+
+	aead, err := newAEAD(aesKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := envelope[headerSize+keyLength:]
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrInvalidEnvelope
+	}
+
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("open payload: %w", err)
+	}
+
+	return plaintext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("create aes cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("create gcm: %w", err)
+	}
+
+	return aead, nil
+}
+
+func readPEM(path string, blockType string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key %s: %w", path, err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("key %s has no %s pem block: %w", path, blockType, ErrInvalidKey)
+	}
+
+	return block.Bytes, nil
+}
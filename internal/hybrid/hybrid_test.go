@@ -0,0 +1,58 @@
+package hybrid_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"metrics/internal/hybrid"
+)
+
+const testKeyBits = 2048
+
+func TestEncryptDecrypt(t *testing.T) {
+	t.Parallel()
+
+	key, err := hybrid.GenerateKey(testKeyBits)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+
+	privatePEM, err := hybrid.EncodePrivateKey(key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "private.pem"), privatePEM, 0o600))
+
+	publicPEM, err := hybrid.EncodePublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "public.pem"), publicPEM, 0o600))
+
+	privateKey, err := hybrid.LoadPrivateKey(filepath.Join(dir, "private.pem"))
+	require.NoError(t, err)
+
+	publicKey, err := hybrid.LoadPublicKey(filepath.Join(dir, "public.pem"))
+	require.NoError(t, err)
+
+	_, err = hybrid.LoadPublicKey(filepath.Join(dir, "private.pem"))
+	require.ErrorIs(t, err, hybrid.ErrInvalidKey)
+
+	plaintext := []byte(`[{"id":"Alloc","type":"gauge","value":1}]`)
+
+	envelope, err := hybrid.Encrypt(publicKey, plaintext)
+	require.NoError(t, err)
+	assert.NotContains(t, string(envelope), "Alloc")
+
+	decrypted, err := hybrid.Decrypt(privateKey, envelope)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, decrypted)
+
+	envelope[len(envelope)-1] ^= 0xff
+
+	_, err = hybrid.Decrypt(privateKey, envelope)
+	require.Error(t, err)
+
+	_, err = hybrid.Decrypt(privateKey, []byte{1, 0})
+	require.ErrorIs(t, err, hybrid.ErrInvalidEnvelope)
+}
@@ -0,0 +1,229 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const cgroupCollectorName = "cgroup"
+
+// CgroupCollector reports every cgroup of the cgroup v2 hierarchy mounted at root, /sys/fs/cgroup on Linux,
+// labeled with its path from the root: the memory used and its limit, the number of tasks, the CPU time
+// and throttling from cpu.stat and the IO of every device from io.stat. A cgroup reports the files
+// of its enabled controllers only and an unlimited memory.max is left out. A counter is reported
+// from the second poll on, as an increase.
+type CgroupCollector struct {
+	root     string
+	interval time.Duration
+	counters counterTotals
+}
+
+func NewCgroupCollector(root string, interval time.Duration) *CgroupCollector {
+	return &CgroupCollector{
+		root:     root,
+		interval: interval,
+		counters: newCounterTotals(),
+	}
+}
+
+func (*CgroupCollector) Name() string {
+	return cgroupCollectorName
+}
+
+func (c *CgroupCollector) Interval() time.Duration {
+	return c.interval
+}
+
+func (c *CgroupCollector) Collect(ctx context.Context) ([]Metric, error) {
+	var metrics []Metric
+
+	err := filepath.WalkDir(c.root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// a cgroup removed during the walk
+			if path != c.root && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+
+			return fmt.Errorf("walk cgroups: %w", err)
+		}
+
+		if !entry.IsDir() {
+			return nil
+		}
+
+		if err = ctx.Err(); err != nil {
+			return fmt.Errorf("walk cgroups: %w", err)
+		}
+
+		relative, err := filepath.Rel(c.root, path)
+		if err != nil {
+			return fmt.Errorf("walk cgroups: %w", err)
+		}
+
+		cgroup := "/"
+		if relative != "." {
+			cgroup += filepath.ToSlash(relative)
+		}
+
+		collected, err := c.collectCgroup(path, map[string]string{"cgroup": cgroup})
+		if err != nil {
+			return fmt.Errorf("cgroup %s: %w", path, err)
+		}
+
+		metrics = append(metrics, collected...)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.counters.rotate()
+
+	return metrics, nil
+}
+
+// collectCgroup reads the files of the cgroup in the dir, those missing are of disabled controllers or of
+// a cgroup removed meanwhile.
+func (c *CgroupCollector) collectCgroup(dir string, labels map[string]string) ([]Metric, error) {
+	var metrics []Metric
+
+	for file, id := range map[string]string{
+		"memory.current": "CgroupMemoryCurrent",
+		"memory.max":     "CgroupMemoryMax",
+		"pids.current":   "CgroupPids",
+	} {
+		value, ok, err := readCgroupValue(filepath.Join(dir, file))
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			metrics = append(metrics, newGauge(id, float64(value), labels))
+		}
+	}
+
+	for _, collect := range []func(string, map[string]string) ([]Metric, error){c.collectCPU, c.collectIO} {
+		collected, err := collect(dir, labels)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+
+		metrics = append(metrics, collected...)
+	}
+
+	return metrics, nil
+}
+
+// collectCPU reads cpu.stat, as in usage_usec 123456, the throttling lines being there with the cpu controller only.
+func (c *CgroupCollector) collectCPU(dir string, labels map[string]string) ([]Metric, error) {
+	counters := map[string]string{
+		"usage_usec":     "CgroupCPUUsageUs",
+		"user_usec":      "CgroupCPUUserUs",
+		"system_usec":    "CgroupCPUSystemUs",
+		"nr_periods":     "CgroupCPUPeriods",
+		"nr_throttled":   "CgroupCPUThrottledPeriods",
+		"throttled_usec": "CgroupCPUThrottledUs",
+	}
+
+	var metrics []Metric
+
+	err := scanFields(filepath.Join(dir, "cpu.stat"), func(fields []string) error {
+		id, ok := counters[fields[0]]
+		if !ok {
+			return nil
+		}
+
+		if len(fields) < 2 { //nolint:mnd // name and value
+			return fmt.Errorf("cpu.stat %s: %w", fields[0], ErrInvalidProcFile)
+		}
+
+		values, err := parseCounters(fields[1:2])
+		if err != nil {
+			return fmt.Errorf("cpu.stat %s: %w", fields[0], err)
+		}
+
+		if counter, ok := c.counters.increase(id, values[0], labels); ok {
+			metrics = append(metrics, counter)
+		}
+
+		return nil
+	})
+
+	return metrics, err
+}
+
+// collectIO reads io.stat, as in 8:0 rbytes=1024 wbytes=4096 rios=1 wios=2 dbytes=0 dios=0,
+// labeling the counters of every device with its major and minor numbers.
+func (c *CgroupCollector) collectIO(dir string, labels map[string]string) ([]Metric, error) {
+	counters := map[string]string{
+		"rbytes": "CgroupIOReadBytes",
+		"wbytes": "CgroupIOWriteBytes",
+		"rios":   "CgroupIOReads",
+		"wios":   "CgroupIOWrites",
+	}
+
+	var metrics []Metric
+
+	err := scanFields(filepath.Join(dir, "io.stat"), func(fields []string) error {
+		device := map[string]string{"device": fields[0]}
+		maps.Copy(device, labels)
+
+		for _, field := range fields[1:] {
+			key, value, found := strings.Cut(field, "=")
+			if !found {
+				return fmt.Errorf("io.stat %s: %w", fields[0], ErrInvalidProcFile)
+			}
+
+			id, ok := counters[key]
+			if !ok {
+				continue
+			}
+
+			values, err := parseCounters([]string{value})
+			if err != nil {
+				return fmt.Errorf("io.stat %s %s: %w", fields[0], key, err)
+			}
+
+			if counter, ok := c.counters.increase(id, values[0], device); ok {
+				metrics = append(metrics, counter)
+			}
+		}
+
+		return nil
+	})
+
+	return metrics, err
+}
+
+// readCgroupValue reads a file of a single value, as memory.current. A missing file or a max value is no value.
+func readCgroupValue(path string) (int64, bool, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, false, nil
+	}
+
+	if err != nil {
+		return 0, false, fmt.Errorf("read %s: %w", filepath.Base(path), err)
+	}
+
+	text := strings.TrimSpace(string(data))
+	if text == "max" {
+		return 0, false, nil
+	}
+
+	value, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s: %w", filepath.Base(path), errors.Join(ErrInvalidProcFile, err))
+	}
+
+	return value, true, nil
+}
@@ -0,0 +1,103 @@
+package producer_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"metrics/internal/producer"
+)
+
+func TestCgroupCollector(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	root := t.TempDir()
+	copyTree(t, filepath.Join("testdata", "cgroup", "first"), root)
+
+	collector := producer.NewCgroupCollector(root, time.Second)
+	assert.Equal(t, "cgroup", collector.Name())
+
+	metrics, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+
+	// the first poll has no increases yet
+	slice := map[string]string{"cgroup": "/system.slice"}
+	nginx := map[string]string{"cgroup": "/system.slice/nginx.service"}
+
+	assert.InDelta(t, 2*268435456, *findMetric(t, metrics, "CgroupMemoryCurrent", slice).Value, 0)
+	assert.InDelta(t, 36, *findMetric(t, metrics, "CgroupPids", slice).Value, 0)
+	assert.InDelta(t, 268435456, *findMetric(t, metrics, "CgroupMemoryCurrent", nginx).Value, 0)
+	assert.InDelta(t, 536870912, *findMetric(t, metrics, "CgroupMemoryMax", nginx).Value, 0)
+	assert.InDelta(t, 12, *findMetric(t, metrics, "CgroupPids", nginx).Value, 0)
+
+	for _, metric := range metrics {
+		assert.Equal(t, producer.MetricGauge, metric.MetricType, metric.ID)
+		assert.False(t, metric.ID == "CgroupMemoryMax" && metric.Labels["cgroup"] == "/system.slice", "max is no limit")
+	}
+
+	copyTree(t, filepath.Join("testdata", "cgroup", "second"), root)
+
+	metrics, err = collector.Collect(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(500000), *findMetric(t, metrics, "CgroupCPUUsageUs", nginx).Delta)
+	assert.Equal(t, int64(200000), *findMetric(t, metrics, "CgroupCPUUserUs", nginx).Delta)
+	assert.Equal(t, int64(300000), *findMetric(t, metrics, "CgroupCPUSystemUs", nginx).Delta)
+	assert.Equal(t, int64(100), *findMetric(t, metrics, "CgroupCPUPeriods", nginx).Delta)
+	assert.Equal(t, int64(3), *findMetric(t, metrics, "CgroupCPUThrottledPeriods", nginx).Delta)
+	assert.Equal(t, int64(15000), *findMetric(t, metrics, "CgroupCPUThrottledUs", nginx).Delta)
+	assert.InDelta(t, 14, *findMetric(t, metrics, "CgroupPids", nginx).Value, 0)
+
+	sda := map[string]string{"cgroup": "/system.slice/nginx.service", "device": "8:0"}
+	assert.Equal(t, int64(1048576), *findMetric(t, metrics, "CgroupIOReadBytes", sda).Delta)
+	assert.Equal(t, int64(0), *findMetric(t, metrics, "CgroupIOWriteBytes", sda).Delta)
+	assert.Equal(t, int64(0), *findMetric(t, metrics, "CgroupIOReads", sda).Delta)
+	assert.Equal(t, int64(0), *findMetric(t, metrics, "CgroupIOWrites", sda).Delta)
+	assert.NotNil(t, findMetric(t, metrics, "CgroupIOReads", map[string]string{"cgroup": "/system.slice", "device": "253:0"}))
+	assert.Equal(t, int64(2000000), *findMetric(t, metrics, "CgroupCPUUsageUs", map[string]string{"cgroup": "/"}).Delta)
+
+	// the new cgroup has its gauges
+	postgres := map[string]string{"cgroup": "/system.slice/postgresql.service"}
+	assert.InDelta(t, 104857600, *findMetric(t, metrics, "CgroupMemoryCurrent", postgres).Value, 0)
+
+	// the removed cgroup is dropped, the new one has its increases
+	require.NoError(t, os.RemoveAll(filepath.Join(root, "system.slice", "nginx.service")))
+
+	metrics, err = collector.Collect(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(0), *findMetric(t, metrics, "CgroupCPUUsageUs", postgres).Delta)
+
+	for _, metric := range metrics {
+		assert.NotEqual(t, "/system.slice/nginx.service", metric.Labels["cgroup"])
+	}
+}
+
+func TestCgroupCollectorErrors(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	_, err := producer.NewCgroupCollector(filepath.Join(t.TempDir(), "missing"), time.Second).Collect(context.Background())
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	root := t.TempDir()
+	copyTree(t, filepath.Join("testdata", "cgroup", "first"), root)
+	require.NoError(t, os.WriteFile(filepath.Join(root, "system.slice", "memory.current"), []byte("lots\n"), 0o600))
+
+	_, err = producer.NewCgroupCollector(root, time.Second).Collect(context.Background())
+	require.ErrorIs(t, err, producer.ErrInvalidProcFile)
+
+	require.NoError(t, os.WriteFile(filepath.Join(root, "system.slice", "memory.current"), []byte("1024\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "io.stat"), []byte("8:0 rbytes\n"), 0o600))
+
+	_, err = producer.NewCgroupCollector(root, time.Second).Collect(context.Background())
+	require.ErrorIs(t, err, producer.ErrInvalidProcFile)
+}
@@ -0,0 +1,176 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"metrics/config"
+	"metrics/internal/log"
+)
+
+var ErrUnknownCollector = errors.New("unknown collector")
+
+// Collector is a source of metrics polled by the agent. Collect returns gauges with their current values,
+// counters with the increase since the previous Collect and histograms with the new observations.
+type Collector interface {
+	Name() string
+	Interval() time.Duration
+	Collect(ctx context.Context) ([]Metric, error)
+}
+
+// collectorFactory creates a collector polled every interval.
+type collectorFactory func(cfg config.Producer, interval time.Duration) (Collector, error)
+
+// collectorFactories are the collectors config.Producer.Collectors may name.
+var collectorFactories = map[string]collectorFactory{ //nolint:gochecknoglobals // read only
+	runtimeCollectorName: func(_ config.Producer, interval time.Duration) (Collector, error) {
+		return NewRuntimeCollector(interval), nil
+	},
+	hostCollectorName: func(cfg config.Producer, interval time.Duration) (Collector, error) {
+		return NewHostCollector(cfg.ProcRoot, interval), nil
+	},
+	processCollectorName: func(cfg config.Producer, interval time.Duration) (Collector, error) {
+		return NewProcessCollector(cfg.ProcRoot, cfg.ProcessPIDs, cfg.ProcessPIDFiles, cfg.ProcessPattern, interval)
+	},
+	cgroupCollectorName: func(cfg config.Producer, interval time.Duration) (Collector, error) {
+		return NewCgroupCollector(cfg.CgroupRoot, interval), nil
+	},
+}
+
+// Registry runs every collector on its own schedule. A collector that fails or hangs
+// delays its own metrics only.
+type Registry struct {
+	collectors []Collector
+}
+
+// NewRegistry creates the collectors the config names, in its order.
+func NewRegistry(cfg config.Producer) (*Registry, error) {
+	registry := &Registry{collectors: nil}
+
+	for _, schedule := range cfg.Collectors {
+		factory, ok := collectorFactories[schedule.Name]
+		if !ok {
+			return nil, fmt.Errorf("collector %s: %w", schedule.Name, ErrUnknownCollector)
+		}
+
+		interval := schedule.Interval
+		if interval == 0 {
+			interval = time.Duration(cfg.PollInterval) * time.Second
+		}
+
+		collector, err := factory(cfg, interval)
+		if err != nil {
+			return nil, fmt.Errorf("create collector %s: %w", schedule.Name, err)
+		}
+
+		registry.Register(collector)
+	}
+
+	return registry, nil
+}
+
+func (r *Registry) Register(collector Collector) {
+	r.collectors = append(r.collectors, collector)
+}
+
+func (r *Registry) Collectors() []Collector {
+	return r.collectors
+}
+
+// Run polls every collector in a goroutine of its own and sends the collected metrics to the channel,
+// which is closed once the context is done and the collectors stopped. A poll gets the interval
+// of its collector to finish.
+func (r *Registry) Run(ctx context.Context) <-chan []Metric {
+	collected := make(chan []Metric)
+
+	var wg sync.WaitGroup
+
+	for _, collector := range r.collectors {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			poll(ctx, collector, collected)
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(collected)
+	}()
+
+	return collected
+}
+
+func poll(ctx context.Context, collector Collector, collected chan<- []Metric) {
+	tickPoll := time.NewTicker(collector.Interval())
+	defer tickPoll.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tickPoll.C:
+		}
+
+		pollCtx, cancel := context.WithTimeout(ctx, collector.Interval())
+		metrics, err := collector.Collect(pollCtx)
+
+		cancel()
+
+		if err != nil {
+			log.Error("collect error",
+				log.StringAttr("collector", collector.Name()),
+				log.ErrAttr(err))
+
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case collected <- metrics:
+		}
+	}
+}
+
+// counterTotals are the totals of the counters of a collector at its previous poll, by metric key, to report
+// their increases. The counters not seen at a poll, as those of a removed interface, are forgotten then.
+type counterTotals struct {
+	previous map[string]int64
+	current  map[string]int64
+}
+
+func newCounterTotals() counterTotals {
+	return counterTotals{previous: map[string]int64{}, current: map[string]int64{}}
+}
+
+// increase returns the counter of the increase of the total since the previous poll, none at the first one.
+// A total smaller than the previous one means the counter restarted, as when an interface is recreated,
+// and counts whole.
+func (t *counterTotals) increase(id string, total int64, labels map[string]string) (Metric, bool) {
+	counter := newCounter(id, total, labels)
+	key := metricKey(counter)
+
+	t.current[key] = total
+
+	previous, ok := t.previous[key]
+	if !ok {
+		return Metric{}, false
+	}
+
+	if total >= previous {
+		*counter.Delta = total - previous
+	}
+
+	return counter, true
+}
+
+// rotate ends a poll, its totals become the previous ones.
+func (t *counterTotals) rotate() {
+	t.previous, t.current = t.current, map[string]int64{}
+}
@@ -0,0 +1,192 @@
+package producer_test
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"metrics/config"
+	"metrics/internal/producer"
+)
+
+var errBroken = errors.New("broken")
+
+// stubCollector returns its metrics, fails or blocks until the poll times out.
+type stubCollector struct {
+	name    string
+	metrics []producer.Metric
+	err     error
+	hang    bool
+}
+
+func (c stubCollector) Name() string {
+	return c.name
+}
+
+func (stubCollector) Interval() time.Duration {
+	return 5 * time.Millisecond
+}
+
+func (c stubCollector) Collect(ctx context.Context) ([]producer.Metric, error) {
+	if c.hang {
+		<-ctx.Done()
+
+		return nil, ctx.Err()
+	}
+
+	return c.metrics, c.err
+}
+
+func TestNewRegistry(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	var cfg config.Producer
+
+	cfg.PollInterval = 2
+	require.NoError(t, cfg.Collectors.Set("runtime"))
+
+	registry, err := producer.NewRegistry(cfg)
+	require.NoError(t, err)
+	require.Len(t, registry.Collectors(), 1)
+	assert.Equal(t, "runtime", registry.Collectors()[0].Name())
+	assert.Equal(t, 2*time.Second, registry.Collectors()[0].Interval(), "the poll interval by default")
+
+	require.NoError(t, cfg.Collectors.Set("runtime=250ms,host,cgroup=10s"))
+
+	registry, err = producer.NewRegistry(cfg)
+	require.NoError(t, err)
+	require.Len(t, registry.Collectors(), 3)
+	assert.Equal(t, 250*time.Millisecond, registry.Collectors()[0].Interval())
+	assert.Equal(t, "host", registry.Collectors()[1].Name())
+	assert.Equal(t, "cgroup", registry.Collectors()[2].Name())
+	assert.Equal(t, 10*time.Second, registry.Collectors()[2].Interval())
+
+	require.NoError(t, cfg.Collectors.Set(""))
+
+	registry, err = producer.NewRegistry(cfg)
+	require.NoError(t, err)
+	assert.Empty(t, registry.Collectors(), "every collector may be disabled")
+
+	require.NoError(t, cfg.Collectors.Set("runtime,unknown"))
+
+	_, err = producer.NewRegistry(cfg)
+	require.ErrorIs(t, err, producer.ErrUnknownCollector)
+
+	require.NoError(t, cfg.Collectors.Set("process"))
+
+	_, err = producer.NewRegistry(cfg)
+	require.ErrorIs(t, err, producer.ErrNoProcess, "the process collector needs processes to watch")
+
+	cfg.ProcessPattern = "nginx"
+
+	registry, err = producer.NewRegistry(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, "process", registry.Collectors()[0].Name())
+
+	require.ErrorIs(t, cfg.Collectors.Set("runtime=0s"), config.ErrInvalidCollector)
+	require.ErrorIs(t, cfg.Collectors.Set("runtime,runtime=1s"), config.ErrInvalidCollector)
+}
+
+func TestRegistryRun(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	registry := &producer.Registry{}
+	registry.Register(stubCollector{name: "broken", metrics: nil, err: errBroken, hang: false})
+	registry.Register(stubCollector{name: "hanging", metrics: nil, err: nil, hang: true})
+	registry.Register(stubCollector{name: "working", metrics: []producer.Metric{
+		{ID: "Up", MetricType: producer.MetricGauge, Value: ptr(1.0)},
+	}, err: nil, hang: false})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	collected := registry.Run(ctx)
+
+	// the working collector is polled again and again despite the others
+	for range 3 {
+		select {
+		case metrics := <-collected:
+			require.Len(t, metrics, 1)
+			assert.Equal(t, "Up", metrics[0].ID)
+		case <-time.After(time.Second):
+			require.FailNow(t, "no metrics collected")
+		}
+	}
+
+	cancel()
+
+	// closed once the collectors stopped
+	for metrics := range collected {
+		assert.Len(t, metrics, 1)
+	}
+}
+
+func TestMetricsStoreAdd(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	var metrics []producer.Metric
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metrics = nil
+
+		gzipReader, err := gzip.NewReader(r.Body)
+		assert.NoError(t, err)
+		assert.NoError(t, json.NewDecoder(gzipReader).Decode(&metrics))
+
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	t.Cleanup(server.Close)
+
+	var cfg config.Producer
+	require.NoError(t, cfg.Address.Set(strings.TrimPrefix(server.URL, "http://")))
+
+	sender, err := producer.NewSender(cfg)
+	require.NoError(t, err)
+
+	eth0 := map[string]string{"interface": "eth0"}
+	eth1 := map[string]string{"interface": "eth1"}
+
+	stats := producer.NewMetrics()
+
+	for _, value := range []float64{1, 2} {
+		stats.Add([]producer.Metric{
+			{ID: "Load", MetricType: producer.MetricGauge, Value: ptr(value)},
+			{ID: "Bytes", MetricType: producer.MetricCounter, Delta: ptr(int64(10)), Labels: eth0},
+			{ID: "Bytes", MetricType: producer.MetricCounter, Delta: ptr(int64(1)), Labels: eth1},
+			{ID: "Pause", MetricType: producer.MetricHistogram, Histogram: &producer.Histogram{Bounds: []float64{1}, Observations: []float64{value}}},
+		})
+	}
+
+	require.NoError(t, stats.Report(context.Background(), sender))
+
+	assert.ElementsMatch(t, []producer.Metric{
+		{ID: "Load", MetricType: producer.MetricGauge, Value: ptr(2.0)},
+		{ID: "Bytes", MetricType: producer.MetricCounter, Delta: ptr(int64(20)), Labels: eth0},
+		{ID: "Bytes", MetricType: producer.MetricCounter, Delta: ptr(int64(2)), Labels: eth1},
+		{ID: "Pause", MetricType: producer.MetricHistogram, Histogram: &producer.Histogram{Bounds: []float64{1}, Observations: []float64{1, 2}}},
+	}, metrics)
+
+	// the gauges are sent again, the counters and histograms start over
+	stats.Add([]producer.Metric{{ID: "Bytes", MetricType: producer.MetricCounter, Delta: ptr(int64(5)), Labels: eth0}})
+
+	require.NoError(t, stats.Report(context.Background(), sender))
+
+	assert.ElementsMatch(t, []producer.Metric{
+		{ID: "Load", MetricType: producer.MetricGauge, Value: ptr(2.0)},
+		{ID: "Bytes", MetricType: producer.MetricCounter, Delta: ptr(int64(5)), Labels: eth0},
+	}, metrics)
+}
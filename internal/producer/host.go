@@ -0,0 +1,349 @@
+package producer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	hostCollectorName = "host"
+
+	// diskSectorSize is the unit of the sector counts of /proc/diskstats, whatever the disk.
+	diskSectorSize = 512
+	kibibyte       = 1024
+
+	// fields of a /proc/stat cpu line after the name: user, nice, system, idle, iowait, irq, softirq,
+	// steal, guest and guest_nice
+	statIdle   = 3
+	statIOWait = 4
+	statSteal  = 7
+
+	loadavgFields = 3
+
+	// fields of a /proc/net/dev line after the name, the transmit counters follow the receive ones
+	netDevFields   = 16
+	netDevTransmit = 8
+	netDevPackets  = 1
+	netDevErrors   = 2
+	netDevDrops    = 3
+
+	// fields of a /proc/diskstats line, from the major number on
+	diskstatsFields  = 14
+	diskstatsName    = 2
+	diskstatsReads   = 3
+	diskstatsRead    = 5
+	diskstatsWrites  = 7
+	diskstatsWritten = 9
+	diskstatsIOTime  = 12
+)
+
+var ErrInvalidProcFile = errors.New("invalid proc file")
+
+// HostCollector reports the host from the proc filesystem mounted at root, /proc on Linux:
+// the utilization of every CPU since the previous poll, the memory and load averages as gauges,
+// and the network and disk counters. A counter is reported from the second poll on, as an increase.
+type HostCollector struct {
+	root     string
+	interval time.Duration
+	cpus     map[string]cpuTimes
+	counters counterTotals
+}
+
+// cpuTimes are the jiffies a CPU spent idle, waiting for IO included, and in total.
+type cpuTimes struct {
+	idle  uint64
+	total uint64
+}
+
+func NewHostCollector(root string, interval time.Duration) *HostCollector {
+	return &HostCollector{
+		root:     root,
+		interval: interval,
+		cpus:     map[string]cpuTimes{},
+		counters: newCounterTotals(),
+	}
+}
+
+func (*HostCollector) Name() string {
+	return hostCollectorName
+}
+
+func (c *HostCollector) Interval() time.Duration {
+	return c.interval
+}
+
+func (c *HostCollector) Collect(_ context.Context) ([]Metric, error) {
+	var metrics []Metric
+
+	for _, collect := range []func() ([]Metric, error){c.collectCPU, c.collectMemory, c.collectLoad, c.collectNetwork, c.collectDisks} {
+		collected, err := collect()
+		if err != nil {
+			return nil, err
+		}
+
+		metrics = append(metrics, collected...)
+	}
+
+	c.counters.rotate()
+
+	return metrics, nil
+}
+
+// collectCPU reads the cpu lines of /proc/stat, as in cpu0 4705 150 1120 16250 520 0 30 0 0 0,
+// the aggregate cpu line is labeled total.
+func (c *HostCollector) collectCPU() ([]Metric, error) {
+	var metrics []Metric
+
+	err := c.scan("stat", func(fields []string) error {
+		if !strings.HasPrefix(fields[0], "cpu") {
+			return nil
+		}
+
+		jiffies := make([]uint64, 0, len(fields)-1)
+
+		for _, field := range fields[1:] {
+			value, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return fmt.Errorf("stat %s: %w", fields[0], errors.Join(ErrInvalidProcFile, err))
+			}
+
+			jiffies = append(jiffies, value)
+		}
+
+		if len(jiffies) <= statIOWait {
+			return fmt.Errorf("stat %s: %w", fields[0], ErrInvalidProcFile)
+		}
+
+		times := cpuTimes{idle: jiffies[statIdle] + jiffies[statIOWait], total: 0}
+
+		// the guest times that follow steal are counted in user and nice already
+		for _, value := range jiffies[:min(len(jiffies), statSteal+1)] {
+			times.total += value
+		}
+
+		cpu := fields[0]
+		if cpu == "cpu" {
+			cpu = "total"
+		}
+
+		previous, ok := c.cpus[cpu]
+		c.cpus[cpu] = times
+
+		if !ok || times.total <= previous.total || times.idle < previous.idle {
+			return nil
+		}
+
+		busy := 1 - float64(times.idle-previous.idle)/float64(times.total-previous.total)
+		metrics = append(metrics, newGauge("CPUUtilization", 100*busy, map[string]string{"cpu": cpu})) //nolint:mnd // percent
+
+		return nil
+	})
+
+	return metrics, err
+}
+
+// collectMemory reads /proc/meminfo, as in MemTotal: 16303044 kB.
+func (c *HostCollector) collectMemory() ([]Metric, error) {
+	gauges := map[string]string{
+		"MemTotal:":     "TotalMemory",
+		"MemFree:":      "FreeMemory",
+		"MemAvailable:": "AvailableMemory",
+		"Buffers:":      "BuffersMemory",
+		"Cached:":       "CachedMemory",
+		"SwapTotal:":    "TotalSwap",
+		"SwapFree:":     "FreeSwap",
+	}
+
+	var metrics []Metric
+
+	err := c.scan("meminfo", func(fields []string) error {
+		id, ok := gauges[fields[0]]
+		if !ok {
+			return nil
+		}
+
+		if len(fields) < 2 { //nolint:mnd // name and value
+			return fmt.Errorf("meminfo %s: %w", fields[0], ErrInvalidProcFile)
+		}
+
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("meminfo %s: %w", fields[0], errors.Join(ErrInvalidProcFile, err))
+		}
+
+		metrics = append(metrics, newGauge(id, float64(value*kibibyte), nil))
+
+		return nil
+	})
+
+	return metrics, err
+}
+
+// collectLoad reads /proc/loadavg, as in 0.52 0.58 0.59 1/467 12345.
+func (c *HostCollector) collectLoad() ([]Metric, error) {
+	var metrics []Metric
+
+	err := c.scan("loadavg", func(fields []string) error {
+		if len(fields) < loadavgFields {
+			return fmt.Errorf("loadavg: %w", ErrInvalidProcFile)
+		}
+
+		for i, id := range []string{"Load1", "Load5", "Load15"} {
+			load, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				return fmt.Errorf("loadavg: %w", errors.Join(ErrInvalidProcFile, err))
+			}
+
+			metrics = append(metrics, newGauge(id, load, nil))
+		}
+
+		return nil
+	})
+
+	return metrics, err
+}
+
+// collectNetwork reads the interfaces of /proc/net/dev, as in eth0: 1234 12 0 0 0 0 0 0 5678 34 0 0 0 0 0 0,
+// the receive fields coming first and the transmit ones after them.
+func (c *HostCollector) collectNetwork() ([]Metric, error) {
+	var metrics []Metric
+
+	err := c.scan(filepath.Join("net", "dev"), func(fields []string) error {
+		name, first, found := strings.Cut(fields[0], ":")
+		if !found {
+			return nil // the headers
+		}
+
+		// the counters may follow the colon without a space
+		if first != "" {
+			fields = append([]string{name, first}, fields[1:]...)
+		} else {
+			fields[0] = name
+		}
+
+		if len(fields) != netDevFields+1 {
+			return fmt.Errorf("net/dev %s: %w", name, ErrInvalidProcFile)
+		}
+
+		counters, err := parseCounters(fields[1:])
+		if err != nil {
+			return fmt.Errorf("net/dev %s: %w", name, err)
+		}
+
+		labels := map[string]string{"interface": name}
+
+		for id, value := range map[string]int64{
+			"NetReceiveBytes":    counters[0],
+			"NetReceivePackets":  counters[netDevPackets],
+			"NetReceiveErrors":   counters[netDevErrors],
+			"NetReceiveDrops":    counters[netDevDrops],
+			"NetTransmitBytes":   counters[netDevTransmit],
+			"NetTransmitPackets": counters[netDevTransmit+netDevPackets],
+			"NetTransmitErrors":  counters[netDevTransmit+netDevErrors],
+			"NetTransmitDrops":   counters[netDevTransmit+netDevDrops],
+		} {
+			if counter, ok := c.counters.increase(id, value, labels); ok {
+				metrics = append(metrics, counter)
+			}
+		}
+
+		return nil
+	})
+
+	return metrics, err
+}
+
+// collectDisks reads /proc/diskstats, as in 8 0 sda 1234 0 5678 100 4321 0 8765 200 0 300 300,
+// skipping the loop and ram devices.
+func (c *HostCollector) collectDisks() ([]Metric, error) {
+	var metrics []Metric
+
+	err := c.scan("diskstats", func(fields []string) error {
+		if len(fields) < diskstatsFields {
+			return fmt.Errorf("diskstats: %w", ErrInvalidProcFile)
+		}
+
+		name := fields[diskstatsName]
+		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") {
+			return nil
+		}
+
+		counters, err := parseCounters(fields[diskstatsReads:diskstatsFields])
+		if err != nil {
+			return fmt.Errorf("diskstats %s: %w", name, err)
+		}
+
+		labels := map[string]string{"device": name}
+
+		for id, value := range map[string]int64{
+			"DiskReads":        counters[0],
+			"DiskReadBytes":    counters[diskstatsRead-diskstatsReads] * diskSectorSize,
+			"DiskWrites":       counters[diskstatsWrites-diskstatsReads],
+			"DiskWrittenBytes": counters[diskstatsWritten-diskstatsReads] * diskSectorSize,
+			"DiskIOTimeMs":     counters[diskstatsIOTime-diskstatsReads],
+		} {
+			if counter, ok := c.counters.increase(id, value, labels); ok {
+				metrics = append(metrics, counter)
+			}
+		}
+
+		return nil
+	})
+
+	return metrics, err
+}
+
+// scan calls line with the fields of every non-empty line of the proc file.
+func (c *HostCollector) scan(name string, line func(fields []string) error) error {
+	return scanFields(filepath.Join(c.root, name), line)
+}
+
+func scanFields(path string, line func(fields []string) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", path, err)
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		if err = line(fields); err != nil {
+			return err
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func parseCounters(fields []string) ([]int64, error) {
+	counters := make([]int64, 0, len(fields))
+
+	for _, field := range fields {
+		counter, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, errors.Join(ErrInvalidProcFile, err)
+		}
+
+		counters = append(counters, counter)
+	}
+
+	return counters, nil
+}
@@ -0,0 +1,136 @@
+package producer_test
+
+import (
+	"context"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"metrics/internal/producer"
+)
+
+func TestHostCollector(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	root := t.TempDir()
+	copyTree(t, filepath.Join("testdata", "proc", "first"), root)
+
+	collector := producer.NewHostCollector(root, time.Second)
+	assert.Equal(t, "host", collector.Name())
+
+	metrics, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+
+	// the first poll has no utilization nor increases yet
+	assert.InDelta(t, 16303044*1024, *findMetric(t, metrics, "TotalMemory", nil).Value, 0)
+	assert.InDelta(t, 1234567*1024, *findMetric(t, metrics, "FreeMemory", nil).Value, 0)
+	assert.InDelta(t, 8765432*1024, *findMetric(t, metrics, "AvailableMemory", nil).Value, 0)
+	assert.InDelta(t, 2097148*1024, *findMetric(t, metrics, "FreeSwap", nil).Value, 0)
+	assert.InDelta(t, 0.52, *findMetric(t, metrics, "Load1", nil).Value, 0)
+	assert.InDelta(t, 0.59, *findMetric(t, metrics, "Load15", nil).Value, 0)
+
+	for _, metric := range metrics {
+		assert.Equal(t, producer.MetricGauge, metric.MetricType, metric.ID)
+		assert.NotEqual(t, "CPUUtilization", metric.ID)
+	}
+
+	copyTree(t, filepath.Join("testdata", "proc", "second"), root)
+
+	metrics, err = collector.Collect(context.Background())
+	require.NoError(t, err)
+
+	assert.InDelta(t, 1200000*1024, *findMetric(t, metrics, "FreeMemory", nil).Value, 0)
+	assert.InDelta(t, 1.25, *findMetric(t, metrics, "Load1", nil).Value, 0)
+
+	assert.InDelta(t, 40, *findMetric(t, metrics, "CPUUtilization", map[string]string{"cpu": "total"}).Value, 1e-9)
+	assert.InDelta(t, 75, *findMetric(t, metrics, "CPUUtilization", map[string]string{"cpu": "cpu0"}).Value, 1e-9)
+	assert.InDelta(t, 100*(1-450.0/550), *findMetric(t, metrics, "CPUUtilization", map[string]string{"cpu": "cpu1"}).Value, 1e-9,
+		"the guest time is in the user time already")
+
+	eth0 := map[string]string{"interface": "eth0"}
+	assert.Equal(t, int64(1500), *findMetric(t, metrics, "NetReceiveBytes", eth0).Delta)
+	assert.Equal(t, int64(10), *findMetric(t, metrics, "NetReceivePackets", eth0).Delta)
+	assert.Equal(t, int64(0), *findMetric(t, metrics, "NetReceiveErrors", eth0).Delta)
+	assert.Equal(t, int64(1), *findMetric(t, metrics, "NetReceiveDrops", eth0).Delta)
+	assert.Equal(t, int64(300), *findMetric(t, metrics, "NetTransmitBytes", eth0).Delta)
+	assert.Equal(t, int64(2), *findMetric(t, metrics, "NetTransmitPackets", eth0).Delta)
+	assert.Equal(t, int64(400), *findMetric(t, metrics, "NetReceiveBytes", map[string]string{"interface": "lo"}).Delta,
+		"a restarted counter counts whole")
+
+	sda := map[string]string{"device": "sda"}
+	assert.Equal(t, int64(10), *findMetric(t, metrics, "DiskReads", sda).Delta)
+	assert.Equal(t, int64(200*512), *findMetric(t, metrics, "DiskReadBytes", sda).Delta)
+	assert.Equal(t, int64(20), *findMetric(t, metrics, "DiskWrites", sda).Delta)
+	assert.Equal(t, int64(1000*512), *findMetric(t, metrics, "DiskWrittenBytes", sda).Delta)
+	assert.Equal(t, int64(100), *findMetric(t, metrics, "DiskIOTimeMs", sda).Delta)
+	assert.NotNil(t, findMetric(t, metrics, "DiskReads", map[string]string{"device": "sda1"}))
+
+	for _, metric := range metrics {
+		assert.NotEqual(t, "loop0", metric.Labels["device"])
+	}
+}
+
+func TestHostCollectorErrors(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	_, err := producer.NewHostCollector(t.TempDir(), time.Second).Collect(context.Background())
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	root := t.TempDir()
+	copyTree(t, filepath.Join("testdata", "proc", "first"), root)
+	require.NoError(t, os.WriteFile(filepath.Join(root, "loadavg"), []byte("0.52 high 0.59 1/467 12345\n"), 0o600))
+
+	_, err = producer.NewHostCollector(root, time.Second).Collect(context.Background())
+	require.ErrorIs(t, err, producer.ErrInvalidProcFile)
+}
+
+// findMetric returns the metric with the id and exactly the labels.
+func findMetric(t *testing.T, metrics []producer.Metric, id string, labels map[string]string) *producer.Metric {
+	t.Helper()
+
+	index := slices.IndexFunc(metrics, func(metric producer.Metric) bool {
+		return metric.ID == id && maps.Equal(metric.Labels, labels)
+	})
+	require.NotEqual(t, -1, index, "%s %v not found", id, labels)
+
+	return &metrics[index]
+}
+
+// copyTree copies the files of the fixture directory into dir, replacing the ones there.
+func copyTree(t *testing.T, fixture, dir string) {
+	t.Helper()
+
+	err := filepath.WalkDir(fixture, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relative, err := filepath.Rel(fixture, path)
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			return os.MkdirAll(filepath.Join(dir, relative), 0o755)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		return os.WriteFile(filepath.Join(dir, relative), data, 0o600)
+	})
+	require.NoError(t, err)
+}
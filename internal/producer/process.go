@@ -0,0 +1,365 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	processCollectorName = "process"
+
+	// clockTicks is the USER_HZ of the times in /proc/<pid>/stat, 100 on every Linux platform.
+	clockTicks = 100
+
+	// fields of /proc/<pid>/stat after the command name: state, ppid, pgrp, session, tty_nr, tpgid, flags,
+	// minflt, cminflt, majflt, cmajflt, utime, stime, cutime, cstime, priority, nice, num_threads,
+	// itrealvalue and starttime
+	processStatUTime     = 11
+	processStatSTime     = 12
+	processStatThreads   = 17
+	processStatStartTime = 19
+)
+
+var (
+	ErrNoProcess      = errors.New("no process selected")
+	ErrInvalidPIDFile = errors.New("invalid pidfile")
+)
+
+// ProcessCollector reports the processes selected by their ids, pidfiles or a regexp of their command lines,
+// each labeled with its pid and name: the CPU time and the bytes read and written as counters, the resident
+// memory, the threads and the open files as gauges. The selection is made again at every poll, so processes
+// that start are reported from then on and the ones that exit are dropped. The io and fd metrics of processes
+// the agent may not inspect are left out.
+type ProcessCollector struct {
+	root      string
+	interval  time.Duration
+	pids      []int
+	pidfiles  []string
+	pattern   *regexp.Regexp
+	processes map[int]processTotals
+}
+
+// processTotals are the counter totals of a process at the previous poll, with its start time
+// to tell a pid reused by another process.
+type processTotals struct {
+	startTime int64
+	totals    map[string]int64
+}
+
+// NewProcessCollector creates the collector of the processes with the pids, the ones in the pidfiles
+// and the ones whose command line matches the pattern, an empty pattern matching none.
+func NewProcessCollector(root string, pids []int, pidfiles []string, pattern string, interval time.Duration) (*ProcessCollector, error) {
+	collector := &ProcessCollector{
+		root:      root,
+		interval:  interval,
+		pids:      pids,
+		pidfiles:  pidfiles,
+		pattern:   nil,
+		processes: map[int]processTotals{},
+	}
+
+	if pattern != "" {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("compile process pattern: %w", err)
+		}
+
+		collector.pattern = compiled
+	}
+
+	if len(pids) == 0 && len(pidfiles) == 0 && collector.pattern == nil {
+		return nil, ErrNoProcess
+	}
+
+	return collector, nil
+}
+
+func (*ProcessCollector) Name() string {
+	return processCollectorName
+}
+
+func (c *ProcessCollector) Interval() time.Duration {
+	return c.interval
+}
+
+func (c *ProcessCollector) Collect(ctx context.Context) ([]Metric, error) {
+	pids, err := c.selectPIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var metrics []Metric
+
+	seen := make(map[int]bool, len(pids))
+
+	for _, pid := range pids {
+		if err = ctx.Err(); err != nil {
+			return nil, fmt.Errorf("collect processes: %w", err)
+		}
+
+		collected, err := c.collectProcess(pid)
+		if err != nil {
+			if vanished(err) {
+				continue
+			}
+
+			return nil, fmt.Errorf("process %d: %w", pid, err)
+		}
+
+		seen[pid] = true
+		metrics = append(metrics, collected...)
+	}
+
+	for pid := range c.processes {
+		if !seen[pid] {
+			delete(c.processes, pid)
+		}
+	}
+
+	return metrics, nil
+}
+
+// selectPIDs returns the pids configured, read from the pidfiles and matching the pattern, once each.
+// A missing pidfile means its process is not running.
+func (c *ProcessCollector) selectPIDs(ctx context.Context) ([]int, error) {
+	pids := make([]int, 0, len(c.pids))
+	selected := map[int]bool{}
+
+	add := func(pid int) {
+		if !selected[pid] {
+			selected[pid] = true
+			pids = append(pids, pid)
+		}
+	}
+
+	for _, pid := range c.pids {
+		add(pid)
+	}
+
+	for _, pidfile := range c.pidfiles {
+		data, err := os.ReadFile(pidfile)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("read pidfile: %w", err)
+		}
+
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil || pid <= 0 {
+			return nil, fmt.Errorf("pidfile %s: %w", pidfile, ErrInvalidPIDFile)
+		}
+
+		add(pid)
+	}
+
+	if c.pattern == nil {
+		return pids, nil
+	}
+
+	entries, err := os.ReadDir(c.root)
+	if err != nil {
+		return nil, fmt.Errorf("list processes: %w", err)
+	}
+
+	for _, entry := range entries {
+		if err = ctx.Err(); err != nil {
+			return nil, fmt.Errorf("list processes: %w", err)
+		}
+
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+
+		cmdline, err := os.ReadFile(filepath.Join(c.root, entry.Name(), "cmdline"))
+		if err != nil {
+			continue // exited since the listing, or not ours to read
+		}
+
+		// the arguments are separated by NULs, the kernel threads have none
+		command := strings.TrimSpace(strings.ReplaceAll(string(cmdline), "\x00", " "))
+		if command != "" && c.pattern.MatchString(command) {
+			add(pid)
+		}
+	}
+
+	return pids, nil
+}
+
+// collectProcess reads the stat, status, io and fd of the process.
+func (c *ProcessCollector) collectProcess(pid int) ([]Metric, error) {
+	dir := filepath.Join(c.root, strconv.Itoa(pid))
+
+	name, stat, err := readProcessStat(filepath.Join(dir, "stat"))
+	if err != nil {
+		return nil, err
+	}
+
+	counters, err := parseCounters([]string{
+		stat[processStatUTime], stat[processStatSTime], stat[processStatThreads], stat[processStatStartTime],
+	})
+	if err != nil {
+		return nil, fmt.Errorf("stat: %w", err)
+	}
+
+	cpuTicks, threads, startTime := counters[0]+counters[1], counters[2], counters[3]
+
+	labels := map[string]string{"pid": strconv.Itoa(pid), "name": name}
+	totals := c.totalsOf(pid, startTime)
+
+	metrics := []Metric{newGauge("ProcessThreads", float64(threads), labels)}
+
+	if counter, ok := totals.increase("ProcessCPUTimeMs", cpuTicks*int64(time.Second/time.Millisecond)/clockTicks, labels); ok {
+		metrics = append(metrics, counter)
+	}
+
+	rss, err := readProcessRSS(filepath.Join(dir, "status"))
+	if err != nil {
+		return nil, err
+	}
+
+	if rss != nil {
+		metrics = append(metrics, newGauge("ProcessRSS", float64(*rss), labels))
+	}
+
+	read, written, err := readProcessIO(filepath.Join(dir, "io"))
+	if err == nil {
+		for id, total := range map[string]int64{"ProcessReadBytes": read, "ProcessWriteBytes": written} {
+			if counter, ok := totals.increase(id, total, labels); ok {
+				metrics = append(metrics, counter)
+			}
+		}
+	} else if !errors.Is(err, fs.ErrPermission) {
+		return nil, err
+	}
+
+	fds, err := os.ReadDir(filepath.Join(dir, "fd"))
+	if err == nil {
+		metrics = append(metrics, newGauge("ProcessOpenFDs", float64(len(fds)), labels))
+	} else if !errors.Is(err, fs.ErrPermission) {
+		return nil, fmt.Errorf("list fds: %w", err)
+	}
+
+	return metrics, nil
+}
+
+// totalsOf returns the previous totals of the process, starting over when the pid is another process now.
+func (c *ProcessCollector) totalsOf(pid int, startTime int64) processTotals {
+	totals, ok := c.processes[pid]
+	if !ok || totals.startTime != startTime {
+		totals = processTotals{startTime: startTime, totals: map[string]int64{}}
+		c.processes[pid] = totals
+	}
+
+	return totals
+}
+
+// increase returns the counter of the increase of the total since the previous poll of the process.
+func (t processTotals) increase(id string, total int64, labels map[string]string) (Metric, bool) {
+	previous, ok := t.totals[id]
+	t.totals[id] = total
+
+	if !ok {
+		return Metric{}, false
+	}
+
+	return newCounter(id, max(total-previous, 0), labels), true
+}
+
+// readProcessStat reads /proc/<pid>/stat, as in 1234 (nginx) S 1 1234 1234 0 -1 4194560 ..., returning the command
+// name and the fields after it. The name is in parentheses and may hold spaces and parentheses itself.
+func readProcessStat(path string) (string, []string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("read stat: %w", err)
+	}
+
+	stat := string(data)
+	open := strings.IndexByte(stat, '(')
+	closing := strings.LastIndexByte(stat, ')')
+
+	if open < 0 || closing < open {
+		return "", nil, fmt.Errorf("stat: %w", ErrInvalidProcFile)
+	}
+
+	fields := strings.Fields(stat[closing+1:])
+	if len(fields) <= processStatStartTime {
+		return "", nil, fmt.Errorf("stat: %w", ErrInvalidProcFile)
+	}
+
+	return stat[open+1 : closing], fields, nil
+}
+
+// readProcessRSS reads the VmRSS line of /proc/<pid>/status, as in VmRSS: 10240 kB, and returns it in bytes.
+// A kernel thread or a zombie has none.
+func readProcessRSS(path string) (*int64, error) {
+	var rss *int64
+
+	err := scanFields(path, func(fields []string) error {
+		if fields[0] != "VmRSS:" {
+			return nil
+		}
+
+		if len(fields) < 2 { //nolint:mnd // name and value
+			return fmt.Errorf("status VmRSS: %w", ErrInvalidProcFile)
+		}
+
+		counters, err := parseCounters(fields[1:2])
+		if err != nil {
+			return fmt.Errorf("status VmRSS: %w", err)
+		}
+
+		bytes := counters[0] * kibibyte
+		rss = &bytes
+
+		return nil
+	})
+
+	return rss, err
+}
+
+// readProcessIO reads the read_bytes and write_bytes lines of /proc/<pid>/io, the bytes the process
+// had read from and written to the storage.
+func readProcessIO(path string) (int64, int64, error) {
+	totals := map[string]int64{}
+
+	err := scanFields(path, func(fields []string) error {
+		if fields[0] != "read_bytes:" && fields[0] != "write_bytes:" || len(fields) < 2 { //nolint:mnd // name and value
+			return nil
+		}
+
+		counters, err := parseCounters(fields[1:2])
+		if err != nil {
+			return fmt.Errorf("io %s: %w", fields[0], err)
+		}
+
+		totals[fields[0]] = counters[0]
+
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if len(totals) != 2 { //nolint:mnd // read and written
+		return 0, 0, fmt.Errorf("io: %w", ErrInvalidProcFile)
+	}
+
+	return totals["read_bytes:"], totals["write_bytes:"], nil
+}
+
+// vanished tells whether the error comes from a process that exited while being read.
+func vanished(err error) bool {
+	return errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ESRCH)
+}
@@ -0,0 +1,114 @@
+package producer_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"metrics/internal/producer"
+)
+
+func TestProcessCollector(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	root := t.TempDir()
+	copyTree(t, filepath.Join("testdata", "proc", "first"), root)
+
+	pidfile := filepath.Join(t.TempDir(), "nginx.pid")
+	require.NoError(t, os.WriteFile(pidfile, []byte("1234\n"), 0o600))
+
+	collector, err := producer.NewProcessCollector(root, []int{4321}, []string{pidfile, filepath.Join(root, "missing.pid")},
+		"postgres", time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "process", collector.Name())
+
+	metrics, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+
+	// the first poll has the gauges only, the pid 4321 does not exist
+	nginx := map[string]string{"pid": "1234", "name": "nginx"}
+	postgres := map[string]string{"pid": "2345", "name": "postgres"}
+
+	assert.InDelta(t, 10240*1024, *findMetric(t, metrics, "ProcessRSS", nginx).Value, 0)
+	assert.InDelta(t, 4, *findMetric(t, metrics, "ProcessThreads", nginx).Value, 0)
+	assert.InDelta(t, 6, *findMetric(t, metrics, "ProcessOpenFDs", nginx).Value, 0)
+	assert.InDelta(t, 20480*1024, *findMetric(t, metrics, "ProcessRSS", postgres).Value, 0)
+	assert.InDelta(t, 10, *findMetric(t, metrics, "ProcessOpenFDs", postgres).Value, 0)
+	assert.Len(t, metrics, 6)
+
+	copyTree(t, filepath.Join("testdata", "proc", "second"), root)
+	require.NoError(t, os.RemoveAll(filepath.Join(root, "2345")))
+
+	metrics, err = collector.Collect(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(500), *findMetric(t, metrics, "ProcessCPUTimeMs", nginx).Delta)
+	assert.Equal(t, int64(8192), *findMetric(t, metrics, "ProcessReadBytes", nginx).Delta)
+	assert.Equal(t, int64(0), *findMetric(t, metrics, "ProcessWriteBytes", nginx).Delta)
+	assert.InDelta(t, 7, *findMetric(t, metrics, "ProcessOpenFDs", nginx).Value, 0)
+
+	// the exited postgres is dropped, the started one has its gauges
+	postgres = map[string]string{"pid": "3456", "name": "postgres"}
+	assert.InDelta(t, 15360*1024, *findMetric(t, metrics, "ProcessRSS", postgres).Value, 0)
+	assert.Len(t, metrics, 6+3)
+
+	for _, metric := range metrics {
+		assert.NotEqual(t, "2345", metric.Labels["pid"])
+	}
+
+	// the pid of nginx is reused by another process, its counters start over
+	stat, err := os.ReadFile(filepath.Join(root, "1234", "stat"))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(root, "1234", "stat"),
+		[]byte(strings.Replace(string(stat), " 5000 ", " 7000 ", 1)), 0o600))
+
+	metrics, err = collector.Collect(context.Background())
+	require.NoError(t, err)
+
+	for _, metric := range metrics {
+		if metric.Labels["pid"] == "1234" {
+			assert.Equal(t, producer.MetricGauge, metric.MetricType, metric.ID)
+		}
+	}
+
+	assert.NotNil(t, findMetric(t, metrics, "ProcessCPUTimeMs", postgres))
+}
+
+func TestProcessCollectorErrors(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	_, err := producer.NewProcessCollector("/proc", nil, nil, "", time.Second)
+	require.ErrorIs(t, err, producer.ErrNoProcess)
+
+	_, err = producer.NewProcessCollector("/proc", nil, nil, "(nginx", time.Second)
+	require.Error(t, err)
+
+	pidfile := filepath.Join(t.TempDir(), "nginx.pid")
+	require.NoError(t, os.WriteFile(pidfile, []byte("nginx\n"), 0o600))
+
+	collector, err := producer.NewProcessCollector(t.TempDir(), nil, []string{pidfile}, "", time.Second)
+	require.NoError(t, err)
+
+	_, err = collector.Collect(context.Background())
+	require.ErrorIs(t, err, producer.ErrInvalidPIDFile)
+
+	root := t.TempDir()
+	copyTree(t, filepath.Join("testdata", "proc", "first"), root)
+	require.NoError(t, os.WriteFile(filepath.Join(root, "1234", "stat"), []byte("1234 (nginx) S 1\n"), 0o600))
+
+	collector, err = producer.NewProcessCollector(root, []int{1234}, nil, "", time.Second)
+	require.NoError(t, err)
+
+	_, err = collector.Collect(context.Background())
+	require.ErrorIs(t, err, producer.ErrInvalidProcFile)
+}
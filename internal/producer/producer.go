@@ -1,354 +1,236 @@
 package producer
 
 import (
-	"bytes"
-	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"math/rand/v2"
-	"net/http"
-	"net/url"
-	"runtime"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	"metrics/config"
 	"metrics/internal/log"
 )
 
 const (
-	clientTimeout      = 10 * time.Second
-	baseProtocol       = "http://"
-	methodCompressGzip = "gzip"
+	MetricCounter   = "counter"
+	MetricGauge     = "gauge"
+	MetricHistogram = "histogram"
 )
 
-const (
-	MetricCounter = "counter"
-	MetricGauge   = "gauge"
+var (
+	ErrUnknownMetricType   = errors.New("unknown metric type")
+	ErrUnexpectedStatus    = errors.New("server returned unexpected status code")
+	ErrUnexpectedTransport = errors.New("unexpected default transport")
 )
 
-var ErrUnknownMetricType = errors.New("unknown metric type")
-
 type (
 	Metric struct {
-		ID         string   `json:"id"              validate:"required"`
-		MetricType string   `json:"type"            validate:"required,oneof=gauge counter"`
-		Delta      *int64   `json:"delta,omitempty"`
-		Value      *float64 `json:"value,omitempty"`
+		ID         string            `json:"id"              validate:"required"`
+		MetricType string            `json:"type"            validate:"required,oneof=gauge counter histogram"`
+		Delta      *int64            `json:"delta,omitempty"`
+		Value      *float64          `json:"value,omitempty"`
+		Histogram  *Histogram        `json:"histogram,omitempty"`
+		Labels     map[string]string `json:"labels,omitempty"`
+	}
+
+	// Histogram carries raw observations, the server counts them in the buckets with the given bounds.
+	Histogram struct {
+		Bounds       []float64 `json:"bounds"`
+		Observations []float64 `json:"observations"`
 	}
 
+	// MetricsStore gathers the collected metrics between the reports. It is safe for concurrent use,
+	// the collectors adding to it while the reports are sent.
 	MetricsStore struct {
+		mu     sync.Mutex
 		memory map[string]Metric
 	}
 )
 
 func NewMetrics() *MetricsStore {
 	return &MetricsStore{
+		mu:     sync.Mutex{},
 		memory: map[string]Metric{},
 	}
 }
 
-func (m *MetricsStore) Update() { //nolint:funlen // true
-	var memMetrics runtime.MemStats
-	runtime.ReadMemStats(&memMetrics)
-
-	currentDelta := m.memory["PollCount"].Delta
-	if currentDelta == nil {
-		currentDelta = new(int64)
+// Update polls the collector and adds its metrics.
+func (m *MetricsStore) Update(ctx context.Context, collector Collector) error {
+	metrics, err := collector.Collect(ctx)
+	if err != nil {
+		return fmt.Errorf("collect %s: %w", collector.Name(), err)
 	}
 
-	*currentDelta++
-
-	m.memory["PollCount"] = Metric{ID: "PollCount", MetricType: MetricCounter, Value: nil, Delta: currentDelta}
-
-	memMetricsAlloc := float64(memMetrics.Alloc)
-	m.memory["Alloc"] = Metric{ID: "Alloc", MetricType: MetricGauge, Value: &memMetricsAlloc, Delta: nil}
-
-	memMetricsBuckHashSys := float64(memMetrics.BuckHashSys)
-	m.memory["BuckHashSys"] = Metric{ID: "BuckHashSys", MetricType: MetricGauge, Value: &memMetricsBuckHashSys, Delta: nil}
-
-	memMetricsFrees := float64(memMetrics.Frees)
-	m.memory["Frees"] = Metric{ID: "Frees", MetricType: MetricGauge, Value: &memMetricsFrees, Delta: nil}
-
-	memMetricsGCCPUFraction := memMetrics.GCCPUFraction
-	m.memory["GCCPUFraction"] = Metric{ID: "GCCPUFraction", MetricType: MetricGauge, Value: &memMetricsGCCPUFraction, Delta: nil}
-
-	memMetricsGCSys := float64(memMetrics.GCSys)
-	m.memory["GCSys"] = Metric{ID: "GCSys", MetricType: MetricGauge, Value: &memMetricsGCSys, Delta: nil}
-
-	memMetricsHeapAlloc := float64(memMetrics.HeapAlloc)
-	m.memory["HeapAlloc"] = Metric{ID: "HeapAlloc", MetricType: MetricGauge, Value: &memMetricsHeapAlloc, Delta: nil}
-
-	memMetricsHeapIdle := float64(memMetrics.HeapIdle)
-	m.memory["HeapIdle"] = Metric{ID: "HeapIdle", MetricType: MetricGauge, Value: &memMetricsHeapIdle, Delta: nil}
-
-	memMetricsHeapInuse := float64(memMetrics.HeapInuse)
-	m.memory["HeapInuse"] = Metric{ID: "HeapInuse", MetricType: MetricGauge, Value: &memMetricsHeapInuse, Delta: nil}
-
-	memMetricsHeapObjects := float64(memMetrics.HeapObjects)
-	m.memory["HeapObjects"] = Metric{ID: "HeapObjects", MetricType: MetricGauge, Value: &memMetricsHeapObjects, Delta: nil}
-
-	memMetricsHeapReleased := float64(memMetrics.HeapReleased)
-	m.memory["HeapReleased"] = Metric{ID: "HeapReleased", MetricType: MetricGauge, Value: &memMetricsHeapReleased, Delta: nil}
-
-	memMetricsHeapSys := float64(memMetrics.HeapSys)
-	m.memory["HeapSys"] = Metric{ID: "HeapSys", MetricType: MetricGauge, Value: &memMetricsHeapSys, Delta: nil}
-
-	memMetricsLastGC := float64(memMetrics.LastGC)
-	m.memory["LastGC"] = Metric{ID: "LastGC", MetricType: MetricGauge, Value: &memMetricsLastGC, Delta: nil}
-
-	memMetricsLookups := float64(memMetrics.Lookups)
-	m.memory["Lookups"] = Metric{ID: "Lookups", MetricType: MetricGauge, Value: &memMetricsLookups, Delta: nil}
-
-	memMetricsMCacheInuse := float64(memMetrics.MCacheInuse)
-	m.memory["MCacheInuse"] = Metric{ID: "MCacheInuse", MetricType: MetricGauge, Value: &memMetricsMCacheInuse, Delta: nil}
-
-	memMetricsMCacheSys := float64(memMetrics.MCacheSys)
-	m.memory["MCacheSys"] = Metric{ID: "MCacheSys", MetricType: MetricGauge, Value: &memMetricsMCacheSys, Delta: nil}
-
-	memMetricsMSpanInuse := float64(memMetrics.MSpanInuse)
-	m.memory["MSpanInuse"] = Metric{ID: "MSpanInuse", MetricType: MetricGauge, Value: &memMetricsMSpanInuse, Delta: nil}
+	m.Add(metrics)
 
-	memMetricsMSpanSys := float64(memMetrics.MSpanSys)
-	m.memory["MSpanSys"] = Metric{ID: "MSpanSys", MetricType: MetricGauge, Value: &memMetricsMSpanSys, Delta: nil}
-
-	memMetricsMallocs := float64(memMetrics.Mallocs)
-	m.memory["Mallocs"] = Metric{ID: "Mallocs", MetricType: MetricGauge, Value: &memMetricsMallocs, Delta: nil}
-
-	memMetricsNextGC := float64(memMetrics.NextGC)
-	m.memory["NextGC"] = Metric{ID: "NextGC", MetricType: MetricGauge, Value: &memMetricsNextGC, Delta: nil}
-
-	memMetricsNumForcedGC := float64(memMetrics.NumForcedGC)
-	m.memory["NumForcedGC"] = Metric{ID: "NumForcedGC", MetricType: MetricGauge, Value: &memMetricsNumForcedGC, Delta: nil}
-
-	memMetricsNumGC := float64(memMetrics.NumGC)
-	m.memory["NumGC"] = Metric{ID: "NumGC", MetricType: MetricGauge, Value: &memMetricsNumGC, Delta: nil}
-
-	memMetricsOtherSys := float64(memMetrics.OtherSys)
-	m.memory["OtherSys"] = Metric{ID: "OtherSys", MetricType: MetricGauge, Value: &memMetricsOtherSys, Delta: nil}
-
-	memMetricsPauseTotalNs := float64(memMetrics.PauseTotalNs)
-	m.memory["PauseTotalNs"] = Metric{ID: "PauseTotalNs", MetricType: MetricGauge, Value: &memMetricsPauseTotalNs, Delta: nil}
-
-	memMetricsStackInuse := float64(memMetrics.StackInuse)
-	m.memory["StackInuse"] = Metric{ID: "StackInuse", MetricType: MetricGauge, Value: &memMetricsStackInuse, Delta: nil}
-
-	memMetricsStackSys := float64(memMetrics.StackSys)
-	m.memory["StackSys"] = Metric{ID: "StackSys", MetricType: MetricGauge, Value: &memMetricsStackSys, Delta: nil}
-
-	memMetricsSys := float64(memMetrics.Sys)
-	m.memory["Sys"] = Metric{ID: "Sys", MetricType: MetricGauge, Value: &memMetricsSys, Delta: nil}
+	return nil
+}
 
-	memMetricsTotalAlloc := float64(memMetrics.TotalAlloc)
-	m.memory["TotalAlloc"] = Metric{ID: "TotalAlloc", MetricType: MetricGauge, Value: &memMetricsTotalAlloc, Delta: nil}
+// Add keeps the latest value of a gauge, sums the deltas of a counter and gathers
+// the observations of a histogram until they are reported.
+func (m *MetricsStore) Add(metrics []Metric) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, metric := range metrics {
+		key := metricKey(metric)
+		stored, ok := m.memory[key]
+
+		switch {
+		case !ok || stored.MetricType != metric.MetricType:
+		case metric.MetricType == MetricCounter:
+			delta := *stored.Delta + *metric.Delta
+			metric.Delta = &delta
+		case metric.MetricType == MetricHistogram:
+			histogram := &Histogram{
+				Bounds:       metric.Histogram.Bounds,
+				Observations: append(slices.Clip(stored.Histogram.Observations), metric.Histogram.Observations...),
+			}
+			metric.Histogram = histogram
+		}
 
-	randomValue := float64(rand.Int()) //nolint:gosec // i know
-	m.memory["RandomValue"] = Metric{ID: "RandomValue", MetricType: MetricGauge, Value: &randomValue, Delta: nil}
+		m.memory[key] = metric
+	}
 }
 
-func (m *MetricsStore) Report(cfg config.Producer) error {
-	err := m.reportURL(cfg)
-	if err != nil {
-		return fmt.Errorf("reporting url metrics: %w", err)
+// metricKey tells the metrics of a name apart by their labels, as in cpu{cpu=cpu0}.
+func metricKey(metric Metric) string {
+	if len(metric.Labels) == 0 {
+		return metric.ID
 	}
 
-	err = m.reportJSON(cfg)
-	if err != nil {
-		return fmt.Errorf("reporting json metrics: %w", err)
+	names := make([]string, 0, len(metric.Labels))
+	for name := range metric.Labels {
+		names = append(names, name)
 	}
 
-	delete(m.memory, "PollCount")
+	slices.Sort(names)
 
-	return nil
-}
-
-func (m *MetricsStore) reportURL(cfg config.Producer) error {
-	urls, err := m.prepareURLs(cfg)
-	if err != nil {
-		return fmt.Errorf("prepare urls: %w", err)
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, name+"="+strconv.Quote(metric.Labels[name]))
 	}
 
-	m.sendRequest(urls)
-
-	return nil
+	return metric.ID + "{" + strings.Join(pairs, ",") + "}"
 }
 
-func (m *MetricsStore) reportJSON(cfg config.Producer) error {
-	jsons, err := m.prepareJSONs()
-	if err != nil {
-		return fmt.Errorf("prepare urls: %w", err)
+// Report sends the collected metrics. Counter deltas are reset only when the server confirmed
+// the report, otherwise they keep accumulating until the next report. With a spool, a report
+// that could not be delivered keeps its deltas there until the server confirms it; a full spool
+// merges its oldest report into the next one, so none are lost.
+func (m *MetricsStore) Report(ctx context.Context, sender *Sender) error {
+	metrics := m.take()
+	if len(metrics) == 0 {
+		return nil
 	}
 
-	m.sendRequestJSON(cfg, jsons)
-
-	return nil
-}
-
-func (m *MetricsStore) prepareURLs(cfg config.Producer) ([]string, error) {
-	urls := make([]string, 0, len(m.memory))
+	batch, err := prepareBatch(metrics)
+	if err != nil {
+		m.restore(metrics)
 
-	for _, metric := range m.memory {
-		var err error
-		var urlMetric string
+		return fmt.Errorf("prepare batch: %w", err)
+	}
 
-		switch metric.MetricType {
-		case MetricCounter:
-			urlMetric, err = url.JoinPath(baseProtocol+cfg.Address.String(), "update", metric.MetricType, metric.ID, strconv.FormatInt(*metric.Delta, 10))
-			if err != nil {
-				return nil, fmt.Errorf("prepare urls: %w", err)
-			}
-		case MetricGauge:
-			urlMetric, err = url.JoinPath(baseProtocol+cfg.Address.String(), "update", metric.MetricType, metric.ID, strconv.FormatFloat(*metric.Value, 'f', -1, 64))
-			if err != nil {
-				return nil, fmt.Errorf("prepare urls: %w", err)
-			}
-		default:
-			return nil, ErrUnknownMetricType
-		}
+	err = sender.Send(ctx, batch)
+	if err != nil {
+		m.restore(metrics)
 
-		urls = append(urls, urlMetric)
+		return fmt.Errorf("reporting batch metrics: %w", err)
 	}
 
-	return urls, nil
+	return nil
 }
 
-func (m *MetricsStore) prepareJSONs() ([][]byte, error) {
-	jsons := make([][]byte, 0, len(m.memory))
+// ReportEvery reports the metrics every interval until the context is done, the reports being sent
+// by as many workers as the limit, so at most that many requests are in flight. While every worker
+// is busy the metrics keep gathering, they are taken once a worker is free.
+func (m *MetricsStore) ReportEvery(ctx context.Context, sender *Sender, interval time.Duration, limit int) {
+	reports := make(chan struct{})
 
-	for _, metric := range m.memory {
-		jsonGauge, err := json.Marshal(metric)
-		if err != nil {
-			return nil, fmt.Errorf("prepare jsons: %w", err)
-		}
+	var wg sync.WaitGroup
 
-		jsons = append(jsons, jsonGauge)
-	}
+	for range limit {
+		wg.Add(1)
 
-	return jsons, nil
-}
+		go func() {
+			defer wg.Done()
 
-func (*MetricsStore) sendRequest(urls []string) {
-	const contentType = "text/plain"
-	var client = &http.Client{
-		Transport:     nil,
-		CheckRedirect: nil,
-		Jar:           nil,
-		Timeout:       clientTimeout,
-	}
+			for range reports {
+				if err := m.Report(ctx, sender); err != nil {
+					log.Error("report error",
+						log.ErrAttr(err))
 
-	for _, urlMetric := range urls {
-		request, err := http.NewRequest(http.MethodPost, urlMetric, http.NoBody) //nolint:noctx //TODO: добавить контекст
-		if err != nil {
-			log.Error("Failed to create request",
-				log.ErrAttr(err),
-				log.StringAttr("url", urlMetric))
-		}
+					continue
+				}
 
-		request.Header.Set("Content-Type", contentType)
-		request.Header.Del("Accept-Encoding")
+				log.Debug("Reported metrics")
+			}
+		}()
+	}
 
-		response, err := client.Do(request)
-		if err != nil {
-			log.Error("Failed to send request",
-				log.ErrAttr(err),
-				log.StringAttr("url", urlMetric),
-			)
+	tickReport := time.NewTicker(interval)
+	defer tickReport.Stop()
 
-			continue
-		}
-
-		if response.StatusCode == http.StatusServiceUnavailable || response.StatusCode == http.StatusNotFound {
-			log.Error("server returned unexpected status code after sending url",
-				log.StringAttr("status", response.Status))
-		}
+	for {
+		select {
+		case <-ctx.Done():
+			close(reports)
+			wg.Wait()
 
-		_, err = io.Copy(io.Discard, response.Body)
-		if err != nil {
-			log.Error("Failed to send request with body to discard",
-				log.ErrAttr(err))
+			return
+		case <-tickReport.C:
 		}
 
-		err = response.Body.Close()
-		if err != nil {
-			log.Error("Failed to close response body",
-				log.ErrAttr(err))
+		select {
+		case <-ctx.Done():
+		case reports <- struct{}{}:
 		}
 	}
 }
 
-func (*MetricsStore) compress(data []byte) ([]byte, error) {
-	var buf bytes.Buffer
+// take returns the metrics to report. The counters and histograms start over, so what is collected
+// while the report is sent goes to the next one; the gauges keep their values until the next poll.
+func (m *MetricsStore) take() []Metric {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	gzipWriter := gzip.NewWriter(&buf)
+	metrics := make([]Metric, 0, len(m.memory))
 
-	if _, err := gzipWriter.Write(data); err != nil {
-		return nil, fmt.Errorf("compressing data: %w", err)
-	}
+	for key, metric := range m.memory {
+		metrics = append(metrics, metric)
 
-	if err := gzipWriter.Close(); err != nil {
-		return nil, fmt.Errorf("closing gzip writer: %w", err)
+		if metric.MetricType != MetricGauge {
+			delete(m.memory, key)
+		}
 	}
 
-	return buf.Bytes(), nil
+	return metrics
 }
 
-func (m *MetricsStore) sendRequestJSON(cfg config.Producer, jsons [][]byte) {
-	const contentType = "application/json"
-	var client = &http.Client{
-		Transport:     nil,
-		CheckRedirect: nil,
-		Jar:           nil,
-		Timeout:       clientTimeout,
-	}
-
-	for _, jsonMetric := range jsons {
-		compressedJSONMetric, err := m.compress(jsonMetric)
-		if err != nil {
-			log.Error("Failed to compress json",
-				log.ErrAttr(err),
-				log.JSONAttr("url", jsonMetric))
-
-			continue
-		}
-
-		request, err := http.NewRequest(http.MethodPost, baseProtocol+cfg.Address.String()+"/update/", bytes.NewReader(compressedJSONMetric)) //nolint:noctx //TODO: добавить контекст
-		if err != nil {
-			log.Error("Failed to create request",
-				log.ErrAttr(err),
-				log.StringAttr("url", string(jsonMetric)))
-
-			continue
-		}
-
-		request.Header.Set("Content-Type", contentType)
-		request.Header.Set("Content-Encoding", methodCompressGzip)
-
-		response, err := client.Do(request)
-		if err != nil {
-			log.Error("Failed to send request with body",
-				log.ErrAttr(err),
-				log.StringAttr("json", string(jsonMetric)))
-
-			continue
-		}
-
-		if response.StatusCode == http.StatusServiceUnavailable || response.StatusCode == http.StatusNotFound {
-			log.Error("server returned unexpected status code after sending json",
-				log.StringAttr("status", response.Status))
-		}
+// restore adds back the counters and histograms of a report that was not sent. The gauges are left out,
+// newer values may have been collected since.
+func (m *MetricsStore) restore(metrics []Metric) {
+	m.Add(slices.DeleteFunc(metrics, func(metric Metric) bool {
+		return metric.MetricType == MetricGauge
+	}))
+}
 
-		_, err = io.Copy(io.Discard, response.Body)
-		if err != nil {
-			log.Error("Failed to send request with body to discard",
-				log.ErrAttr(err))
+func prepareBatch(metrics []Metric) ([]byte, error) {
+	for _, metric := range metrics {
+		switch metric.MetricType {
+		case MetricCounter, MetricGauge, MetricHistogram:
+		default:
+			return nil, fmt.Errorf("metric %s: %w", metric.ID, ErrUnknownMetricType)
 		}
+	}
 
-		err = response.Body.Close()
-		if err != nil {
-			log.Error("Failed to close response body",
-				log.ErrAttr(err))
-		}
+	batch, err := json.Marshal(metrics)
+	if err != nil {
+		return nil, fmt.Errorf("marshal batch: %w", err)
 	}
+
+	return batch, nil
 }
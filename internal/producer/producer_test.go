@@ -1,10 +1,31 @@
 package producer_test
 
 import (
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"metrics/config"
+	"metrics/internal/certs"
+	"metrics/internal/certs/certstest"
 	"metrics/internal/log"
 	"metrics/internal/producer"
+	"metrics/internal/sign"
 )
 
 func prepare(t *testing.T) {
@@ -38,3 +59,450 @@ func TestNewMetrics(t *testing.T) {
 		})
 	}
 }
+
+func TestReport(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	var requests atomic.Int32
+	var metrics []producer.Metric
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+
+		assert.Equal(t, "/updates/", r.URL.Path)
+		assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+		assert.Equal(t, "127.0.0.1", r.Header.Get("X-Real-IP"))
+
+		gzipReader, err := gzip.NewReader(r.Body)
+		assert.NoError(t, err)
+
+		assert.NoError(t, json.NewDecoder(gzipReader).Decode(&metrics))
+
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	t.Cleanup(server.Close)
+
+	var cfg config.Producer
+	require.NoError(t, cfg.Address.Set(strings.TrimPrefix(server.URL, "http://")))
+
+	stats := producer.NewMetrics()
+	collector := producer.NewRuntimeCollector(time.Second)
+	require.NoError(t, stats.Update(context.Background(), collector))
+	runtime.GC()
+	require.NoError(t, stats.Update(context.Background(), collector))
+
+	sender, err := producer.NewSender(cfg)
+	require.NoError(t, err)
+
+	require.NoError(t, stats.Report(context.Background(), sender))
+
+	assert.Equal(t, int32(1), requests.Load())
+	assert.Contains(t, metrics, producer.Metric{ID: "PollCount", MetricType: producer.MetricCounter, Delta: ptr(int64(2)), Value: nil})
+
+	index := slices.IndexFunc(metrics, func(metric producer.Metric) bool { return metric.ID == "GCPause" })
+	require.NotEqual(t, -1, index)
+	assert.Equal(t, producer.MetricHistogram, metrics[index].MetricType)
+	assert.NotEmpty(t, metrics[index].Histogram.Observations)
+}
+
+func ptr[T any](value T) *T {
+	return &value
+}
+
+func TestReportRetry(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	var requests atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if requests.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	t.Cleanup(server.Close)
+
+	cfg := config.Producer{RetryCount: 3, RetryDelay: time.Millisecond, RetryMaxDelay: time.Millisecond}
+	require.NoError(t, cfg.Address.Set(strings.TrimPrefix(server.URL, "http://")))
+
+	sender, err := producer.NewSender(cfg)
+	require.NoError(t, err)
+
+	stats := producer.NewMetrics()
+	collector := producer.NewRuntimeCollector(time.Second)
+	require.NoError(t, stats.Update(context.Background(), collector))
+
+	require.NoError(t, stats.Report(context.Background(), sender))
+	assert.Equal(t, int32(3), requests.Load())
+}
+
+func TestReportSpool(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	var isAvailable atomic.Bool
+	var pollCounts []int64
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isAvailable.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+
+			return
+		}
+
+		var metrics []producer.Metric
+
+		gzipReader, err := gzip.NewReader(r.Body)
+		assert.NoError(t, err)
+		assert.NoError(t, json.NewDecoder(gzipReader).Decode(&metrics))
+
+		for _, metric := range metrics {
+			if metric.ID == "PollCount" {
+				pollCounts = append(pollCounts, *metric.Delta)
+			}
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	t.Cleanup(server.Close)
+
+	cfg := config.Producer{SpoolDir: t.TempDir(), SpoolLimit: 10}
+	require.NoError(t, cfg.Address.Set(strings.TrimPrefix(server.URL, "http://")))
+
+	sender, err := producer.NewSender(cfg)
+	require.NoError(t, err)
+
+	stats := producer.NewMetrics()
+	collector := producer.NewRuntimeCollector(time.Second)
+
+	require.NoError(t, stats.Update(context.Background(), collector))
+	require.NoError(t, stats.Report(context.Background(), sender))
+
+	require.NoError(t, stats.Update(context.Background(), collector))
+	require.NoError(t, stats.Update(context.Background(), collector))
+	require.NoError(t, stats.Report(context.Background(), sender))
+
+	spooled, err := os.ReadDir(cfg.SpoolDir)
+	require.NoError(t, err)
+	assert.Len(t, spooled, 2)
+
+	isAvailable.Store(true)
+
+	require.NoError(t, stats.Update(context.Background(), collector))
+	require.NoError(t, stats.Update(context.Background(), collector))
+	require.NoError(t, stats.Update(context.Background(), collector))
+	require.NoError(t, stats.Report(context.Background(), sender))
+
+	assert.Equal(t, []int64{1, 2, 3}, pollCounts)
+
+	spooled, err = os.ReadDir(cfg.SpoolDir)
+	require.NoError(t, err)
+	assert.Empty(t, spooled)
+}
+
+func TestReportSpoolOverLimit(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	var isAvailable atomic.Bool
+	var pollCounts []int64
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isAvailable.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+
+			return
+		}
+
+		var metrics []producer.Metric
+
+		gzipReader, err := gzip.NewReader(r.Body)
+		assert.NoError(t, err)
+		assert.NoError(t, json.NewDecoder(gzipReader).Decode(&metrics))
+
+		for _, metric := range metrics {
+			if metric.ID == "PollCount" {
+				pollCounts = append(pollCounts, *metric.Delta)
+			}
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	t.Cleanup(server.Close)
+
+	cfg := config.Producer{SpoolDir: t.TempDir(), SpoolLimit: 2}
+	require.NoError(t, cfg.Address.Set(strings.TrimPrefix(server.URL, "http://")))
+
+	sender, err := producer.NewSender(cfg)
+	require.NoError(t, err)
+
+	stats := producer.NewMetrics()
+
+	for poll := range int64(5) {
+		stats.Add([]producer.Metric{
+			{ID: "PollCount", MetricType: producer.MetricCounter, Delta: ptr(poll + 1)},
+			{ID: "RandomValue", MetricType: producer.MetricGauge, Value: ptr(float64(poll))},
+		})
+		require.NoError(t, stats.Report(context.Background(), sender))
+	}
+
+	spooled, err := os.ReadDir(cfg.SpoolDir)
+	require.NoError(t, err)
+	assert.Len(t, spooled, 2)
+
+	isAvailable.Store(true)
+
+	stats.Add([]producer.Metric{{ID: "PollCount", MetricType: producer.MetricCounter, Delta: ptr(int64(6))}})
+	require.NoError(t, stats.Report(context.Background(), sender))
+
+	// the three oldest reports were merged into the fourth one, no delta is lost
+	assert.Equal(t, []int64{1 + 2 + 3 + 4, 5, 6}, pollCounts)
+
+	spooled, err = os.ReadDir(cfg.SpoolDir)
+	require.NoError(t, err)
+	assert.Empty(t, spooled)
+}
+
+func TestReportKeepsCounterOnFailure(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	var pollCount int64
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var metrics []producer.Metric
+
+		gzipReader, err := gzip.NewReader(r.Body)
+		assert.NoError(t, err)
+		assert.NoError(t, json.NewDecoder(gzipReader).Decode(&metrics))
+
+		for _, metric := range metrics {
+			if metric.ID == "PollCount" {
+				pollCount = *metric.Delta
+			}
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+
+	t.Cleanup(server.Close)
+
+	var cfg config.Producer
+	require.NoError(t, cfg.Address.Set(strings.TrimPrefix(server.URL, "http://")))
+
+	sender, err := producer.NewSender(cfg)
+	require.NoError(t, err)
+
+	stats := producer.NewMetrics()
+	collector := producer.NewRuntimeCollector(time.Second)
+
+	require.NoError(t, stats.Update(context.Background(), collector))
+	require.Error(t, stats.Report(context.Background(), sender))
+
+	require.NoError(t, stats.Update(context.Background(), collector))
+	require.Error(t, stats.Report(context.Background(), sender))
+
+	assert.Equal(t, int64(2), pollCount)
+}
+
+func TestReportSigned(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	const key = "secret"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gzipReader, err := gzip.NewReader(r.Body)
+		assert.NoError(t, err)
+
+		body, err := io.ReadAll(gzipReader)
+		assert.NoError(t, err)
+
+		if !sign.Verify(key, body, r.Header.Get(sign.Header)) {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	t.Cleanup(server.Close)
+
+	cfg := config.Producer{Key: key}
+	require.NoError(t, cfg.Address.Set(strings.TrimPrefix(server.URL, "http://")))
+
+	sender, err := producer.NewSender(cfg)
+	require.NoError(t, err)
+
+	stats := producer.NewMetrics()
+	collector := producer.NewRuntimeCollector(time.Second)
+	require.NoError(t, stats.Update(context.Background(), collector))
+
+	require.NoError(t, stats.Report(context.Background(), sender))
+}
+
+func TestReportMutualTLS(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	dir := t.TempDir()
+	ca := certstest.NewCA(t, dir)
+	serverCert, serverKey := ca.Issue(t, dir, "server")
+	clientCert, clientKey := ca.Issue(t, dir, "client")
+
+	serverPair, err := tls.LoadX509KeyPair(serverCert, serverKey)
+	require.NoError(t, err)
+
+	clientCAs, err := certs.LoadCertPool(ca.CertPath)
+	require.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = &tls.Config{ //nolint:exhaustruct // test server
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{serverPair},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	server.StartTLS()
+
+	t.Cleanup(server.Close)
+
+	testCases := []struct {
+		name    string
+		cfg     config.Producer
+		wantErr bool
+	}{
+		{name: "with client certificate", cfg: config.Producer{TLS: true, TLSCA: ca.CertPath, TLSCert: clientCert, TLSKey: clientKey}, wantErr: false},
+		{name: "without client certificate", cfg: config.Producer{TLS: true, TLSCA: ca.CertPath}, wantErr: true},
+		{name: "without trusted ca", cfg: config.Producer{TLS: true, TLSCert: clientCert, TLSKey: clientKey}, wantErr: true},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := tt.cfg
+			require.NoError(t, cfg.Address.Set(strings.TrimPrefix(server.URL, "https://")))
+
+			sender, err := producer.NewSender(cfg)
+			require.NoError(t, err)
+
+			stats := producer.NewMetrics()
+			collector := producer.NewRuntimeCollector(time.Second)
+			require.NoError(t, stats.Update(context.Background(), collector))
+
+			err = stats.Report(context.Background(), sender)
+			if tt.wantErr {
+				require.Error(t, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestReportEvery(t *testing.T) {
+	prepare(t)
+
+	t.Parallel()
+
+	var inFlight, maxInFlight, received atomic.Int64
+
+	// the first request is held until a second one is in flight alongside it
+	concurrent := make(chan struct{})
+
+	var once sync.Once
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := inFlight.Add(1)
+		defer inFlight.Add(-1)
+
+		for peak := maxInFlight.Load(); current > peak && !maxInFlight.CompareAndSwap(peak, current); {
+			peak = maxInFlight.Load()
+		}
+
+		if current == 2 {
+			once.Do(func() { close(concurrent) })
+		}
+
+		select {
+		case <-concurrent:
+		case <-time.After(time.Second):
+		}
+
+		var metrics []producer.Metric
+
+		gzipReader, err := gzip.NewReader(r.Body)
+		assert.NoError(t, err)
+		assert.NoError(t, json.NewDecoder(gzipReader).Decode(&metrics))
+
+		for _, metric := range metrics {
+			received.Add(*metric.Delta)
+		}
+
+		// a slow server does not hold the collection back
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	t.Cleanup(server.Close)
+
+	var cfg config.Producer
+	require.NoError(t, cfg.Address.Set(strings.TrimPrefix(server.URL, "http://")))
+
+	sender, err := producer.NewSender(cfg)
+	require.NoError(t, err)
+
+	stats := producer.NewMetrics()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		stats.ReportEvery(ctx, sender, 2*time.Millisecond, 2)
+	}()
+
+	const polls = 100
+
+	for range polls {
+		stats.Add([]producer.Metric{{ID: "Polls", MetricType: producer.MetricCounter, Delta: ptr(int64(1))}})
+		time.Sleep(time.Millisecond)
+	}
+
+	assert.Eventually(t, func() bool { return received.Load() == polls }, time.Second, 5*time.Millisecond,
+		"every poll is reported once")
+
+	cancel()
+	<-done
+
+	select {
+	case <-concurrent:
+	default:
+		assert.Fail(t, "no two requests in flight")
+	}
+
+	assert.LessOrEqual(t, maxInFlight.Load(), int64(2), "no more requests in flight than the limit")
+}
@@ -0,0 +1,27 @@
+package producer
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+const headerRealIP = "X-Real-IP"
+
+// outboundIP finds the local address the agent uses to reach the server. Dialing udp
+// sends no packets, it only asks the kernel to pick the route and the source address.
+func outboundIP(address string) (netip.Addr, error) {
+	conn, err := net.Dial("udp", address) //nolint:noctx // udp dial does not block
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("dial %s: %w", address, err)
+	}
+
+	defer conn.Close()
+
+	localAddr, err := netip.ParseAddrPort(conn.LocalAddr().String())
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("parse local address: %w", err)
+	}
+
+	return localAddr.Addr().Unmap(), nil
+}
@@ -0,0 +1,105 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"metrics/config"
+	"metrics/internal/log"
+)
+
+type (
+	// StatusError is returned when the server answers with a non-2xx status.
+	StatusError struct {
+		StatusCode int
+		Status     string
+	}
+
+	retryPolicy struct {
+		attempts     int
+		initialDelay time.Duration
+		maxDelay     time.Duration
+	}
+)
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("status %s", e.Status)
+}
+
+func (*StatusError) Unwrap() error {
+	return ErrUnexpectedStatus
+}
+
+func newRetryPolicy(cfg config.Producer) retryPolicy {
+	return retryPolicy{
+		attempts:     cfg.RetryCount,
+		initialDelay: cfg.RetryDelay,
+		maxDelay:     cfg.RetryMaxDelay,
+	}
+}
+
+// do calls fn until it succeeds, fails with a non-retriable error or the retries are exhausted.
+// The delay between attempts grows exponentially up to maxDelay with equal jitter.
+func (p retryPolicy) do(ctx context.Context, fn func(ctx context.Context) error) error {
+	delay := p.initialDelay
+
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil || !isRetriable(err) || attempt > p.attempts {
+			return err
+		}
+
+		wait := jitter(delay)
+
+		log.Debug("retrying request",
+			log.IntAttr("attempt", attempt),
+			log.DurationAttr("wait", wait),
+			log.ErrAttr(err))
+
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return fmt.Errorf("retry canceled: %w", errors.Join(err, ctx.Err()))
+		case <-timer.C:
+		}
+
+		delay = min(delay*2, p.maxDelay) //nolint:mnd // exponential
+	}
+}
+
+func jitter(delay time.Duration) time.Duration {
+	if delay <= 1 {
+		return delay
+	}
+
+	half := delay / 2 //nolint:mnd // equal jitter
+
+	return half + rand.N(half) //nolint:gosec // jitter does not need crypto
+}
+
+// isRetriable reports whether the failure is temporary: the server is unreachable, overloaded or broken.
+func isRetriable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= http.StatusInternalServerError || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var netErr net.Error
+
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
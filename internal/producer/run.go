@@ -1,37 +1,43 @@
 package producer
 
 import (
+	"context"
+	"fmt"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"metrics/config"
 	"metrics/internal/log"
 )
 
-func Run(cfg config.ProducerConfig) {
-	tickReport := time.NewTicker(time.Duration(cfg.Producer.ReportInterval) * time.Second)
-	defer tickReport.Stop()
+func Run(cfg config.ProducerConfig) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
 
-	tickPool := time.NewTicker(time.Duration(cfg.Producer.PollInterval) * time.Second)
-	defer tickPool.Stop()
+	sender, err := NewSender(cfg.Producer)
+	if err != nil {
+		return fmt.Errorf("create sender: %w", err)
+	}
+
+	registry, err := NewRegistry(cfg.Producer)
+	if err != nil {
+		return fmt.Errorf("create collectors: %w", err)
+	}
 
 	stats := NewMetrics()
 
-	for {
-		select {
-		case <-tickPool.C:
-			stats.Update()
+	collected := registry.Run(ctx)
+
+	go func() {
+		for metrics := range collected {
+			stats.Add(metrics)
 			log.Debug("Updated metrics",
-				log.AnyAttr("PollCount", *stats.memory["PollCount"].Delta))
-		case <-tickReport.C:
-			err := stats.Report(cfg.Producer)
-			if err != nil {
-				log.Error("report error",
-					log.ErrAttr(err))
+				log.IntAttr("count", len(metrics)))
+		}
+	}()
 
-				continue
-			}
+	stats.ReportEvery(ctx, sender, time.Duration(cfg.Producer.ReportInterval)*time.Second, cfg.Producer.RateLimit)
 
-			log.Debug("Reported metrics")
-		}
-	}
+	return nil
 }
@@ -0,0 +1,123 @@
+package producer
+
+import (
+	"context"
+	"math/rand/v2"
+	"runtime"
+	"time"
+)
+
+const runtimeCollectorName = "runtime"
+
+// gcPauseBuckets are the GCPause bucket bounds in seconds, from 10µs to 100ms.
+var gcPauseBuckets = []float64{1e-5, 5e-5, 1e-4, 5e-4, 1e-3, 5e-3, 1e-2, 5e-2, 1e-1} //nolint:gochecknoglobals // read only
+
+// RuntimeCollector reports the runtime.MemStats of the agent, the PollCount counter of its polls,
+// a RandomValue gauge and the GCPause histogram of the collections since the previous poll.
+type RuntimeCollector struct {
+	interval time.Duration
+	numGC    uint32
+}
+
+func NewRuntimeCollector(interval time.Duration) *RuntimeCollector {
+	return &RuntimeCollector{
+		interval: interval,
+		numGC:    0,
+	}
+}
+
+func (*RuntimeCollector) Name() string {
+	return runtimeCollectorName
+}
+
+func (c *RuntimeCollector) Interval() time.Duration {
+	return c.interval
+}
+
+func (c *RuntimeCollector) Collect(_ context.Context) ([]Metric, error) {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	gauges := []struct {
+		id    string
+		value float64
+	}{
+		{"Alloc", float64(memStats.Alloc)},
+		{"BuckHashSys", float64(memStats.BuckHashSys)},
+		{"Frees", float64(memStats.Frees)},
+		{"GCCPUFraction", memStats.GCCPUFraction},
+		{"GCSys", float64(memStats.GCSys)},
+		{"HeapAlloc", float64(memStats.HeapAlloc)},
+		{"HeapIdle", float64(memStats.HeapIdle)},
+		{"HeapInuse", float64(memStats.HeapInuse)},
+		{"HeapObjects", float64(memStats.HeapObjects)},
+		{"HeapReleased", float64(memStats.HeapReleased)},
+		{"HeapSys", float64(memStats.HeapSys)},
+		{"LastGC", float64(memStats.LastGC)},
+		{"Lookups", float64(memStats.Lookups)},
+		{"MCacheInuse", float64(memStats.MCacheInuse)},
+		{"MCacheSys", float64(memStats.MCacheSys)},
+		{"MSpanInuse", float64(memStats.MSpanInuse)},
+		{"MSpanSys", float64(memStats.MSpanSys)},
+		{"Mallocs", float64(memStats.Mallocs)},
+		{"NextGC", float64(memStats.NextGC)},
+		{"NumForcedGC", float64(memStats.NumForcedGC)},
+		{"NumGC", float64(memStats.NumGC)},
+		{"OtherSys", float64(memStats.OtherSys)},
+		{"PauseTotalNs", float64(memStats.PauseTotalNs)},
+		{"StackInuse", float64(memStats.StackInuse)},
+		{"StackSys", float64(memStats.StackSys)},
+		{"Sys", float64(memStats.Sys)},
+		{"TotalAlloc", float64(memStats.TotalAlloc)},
+		{"RandomValue", float64(rand.Int())}, //nolint:gosec // i know
+	}
+
+	metrics := make([]Metric, 0, len(gauges)+2) //nolint:mnd // PollCount and GCPause
+
+	for _, gauge := range gauges {
+		metrics = append(metrics, newGauge(gauge.id, gauge.value, nil))
+	}
+
+	metrics = append(metrics, newCounter("PollCount", 1, nil))
+
+	if gcPause, ok := c.gcPause(&memStats); ok {
+		metrics = append(metrics, gcPause)
+	}
+
+	return metrics, nil
+}
+
+// gcPause returns the GCPause histogram of the collections since the last poll.
+// MemStats keeps the last 256 pauses only, older ones are lost when the polls are too rare.
+func (c *RuntimeCollector) gcPause(memStats *runtime.MemStats) (Metric, bool) {
+	const pauseBufferSize = uint32(len(memStats.PauseNs))
+
+	first := c.numGC
+	if memStats.NumGC-first > pauseBufferSize {
+		first = memStats.NumGC - pauseBufferSize
+	}
+
+	c.numGC = memStats.NumGC
+
+	if first == memStats.NumGC {
+		return Metric{}, false
+	}
+
+	histogram := &Histogram{Bounds: gcPauseBuckets, Observations: nil}
+
+	// the pause of the n-th collection, counting from one, is at PauseNs[(n+255)%256]
+	for n := first; n < memStats.NumGC; n++ {
+		pause := memStats.PauseNs[n%pauseBufferSize]
+		histogram.Observations = append(histogram.Observations, float64(pause)/1e9) //nolint:mnd // ns to s
+	}
+
+	return Metric{ID: "GCPause", MetricType: MetricHistogram, Value: nil, Delta: nil, Histogram: histogram, Labels: nil}, true
+}
+
+func newGauge(id string, value float64, labels map[string]string) Metric {
+	return Metric{ID: id, MetricType: MetricGauge, Value: &value, Delta: nil, Histogram: nil, Labels: labels}
+}
+
+func newCounter(id string, delta int64, labels map[string]string) Metric {
+	return Metric{ID: id, MetricType: MetricCounter, Value: nil, Delta: &delta, Histogram: nil, Labels: labels}
+}
@@ -0,0 +1,201 @@
+package producer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rsa"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"metrics/config"
+	"metrics/internal/hybrid"
+	"metrics/internal/log"
+	"metrics/internal/sign"
+)
+
+const (
+	clientTimeout      = 10 * time.Second
+	protocolHTTP       = "http://"
+	protocolHTTPS      = "https://"
+	methodCompressGzip = "gzip"
+)
+
+// Sender delivers reports to the server, retrying temporary failures and spooling
+// the reports it could not deliver.
+type Sender struct {
+	cfg       config.Producer
+	baseURL   string
+	realIP    string
+	client    *http.Client
+	retry     retryPolicy
+	spool     *spool
+	publicKey *rsa.PublicKey
+}
+
+func NewSender(cfg config.Producer) (*Sender, error) {
+	transport, err := newTransport(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("create transport: %w", err)
+	}
+
+	baseURL := protocolHTTP + cfg.Address.String()
+	if cfg.TLS {
+		baseURL = protocolHTTPS + cfg.Address.String()
+	}
+
+	realIP, err := outboundIP(cfg.Address.String())
+	if err != nil {
+		log.Error("Failed to detect outbound ip, X-Real-IP is not sent",
+			log.ErrAttr(err))
+	}
+
+	sender := &Sender{
+		cfg:     cfg,
+		baseURL: baseURL,
+		realIP:  "",
+		client: &http.Client{
+			Transport:     transport,
+			CheckRedirect: nil,
+			Jar:           nil,
+			Timeout:       clientTimeout,
+		},
+		retry:     newRetryPolicy(cfg),
+		spool:     nil,
+		publicKey: nil,
+	}
+
+	if realIP.IsValid() {
+		sender.realIP = realIP.String()
+	}
+
+	if cfg.CryptoKey != "" {
+		publicKey, err := hybrid.LoadPublicKey(cfg.CryptoKey)
+		if err != nil {
+			return nil, fmt.Errorf("load public key: %w", err)
+		}
+
+		sender.publicKey = publicKey
+	}
+
+	if cfg.SpoolDir != "" {
+		reportSpool, err := newSpool(cfg.SpoolDir, cfg.SpoolLimit)
+		if err != nil {
+			return nil, fmt.Errorf("create spool: %w", err)
+		}
+
+		sender.spool = reportSpool
+	}
+
+	return sender, nil
+}
+
+// Send delivers the report. Spooled reports go first; while they cannot be delivered
+// the new report is queued behind them. A report that exhausted its retries is spooled,
+// in which case Send returns nil as the spool is now responsible for it.
+func (s *Sender) Send(ctx context.Context, report []byte) error {
+	if s.spool == nil {
+		return s.retry.do(ctx, func(ctx context.Context) error {
+			return s.sendBatch(ctx, report)
+		})
+	}
+
+	if err := s.spool.replay(ctx, s.sendBatch); err != nil {
+		log.Error("server is still unreachable",
+			log.ErrAttr(err))
+
+		return s.spool.push(report)
+	}
+
+	err := s.retry.do(ctx, func(ctx context.Context) error {
+		return s.sendBatch(ctx, report)
+	})
+	if err != nil && isRetriable(err) {
+		log.Error("report not delivered",
+			log.ErrAttr(err))
+
+		return s.spool.push(report)
+	}
+
+	return err
+}
+
+func (*Sender) compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	gzipWriter := gzip.NewWriter(&buf)
+
+	if _, err := gzipWriter.Write(data); err != nil {
+		return nil, fmt.Errorf("compressing data: %w", err)
+	}
+
+	if err := gzipWriter.Close(); err != nil {
+		return nil, fmt.Errorf("closing gzip writer: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (s *Sender) sendBatch(ctx context.Context, batch []byte) error {
+	const contentType = "application/json"
+
+	body, err := s.compress(batch)
+	if err != nil {
+		return fmt.Errorf("compress batch: %w", err)
+	}
+
+	if s.publicKey != nil {
+		body, err = hybrid.Encrypt(s.publicKey, body)
+		if err != nil {
+			return fmt.Errorf("encrypt batch: %w", err)
+		}
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, s.baseURL+"/updates/", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+
+	request.Header.Set("Content-Type", contentType)
+	request.Header.Set("Content-Encoding", methodCompressGzip)
+
+	if s.publicKey != nil {
+		request.Header.Set(hybrid.Header, hybrid.Scheme)
+	}
+
+	if s.realIP != "" {
+		request.Header.Set(headerRealIP, s.realIP)
+	}
+
+	if s.cfg.Key != "" {
+		request.Header.Set(sign.Header, sign.Sign(s.cfg.Key, batch))
+	}
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("send request: %w", err)
+	}
+
+	_, err = io.Copy(io.Discard, response.Body)
+	if err != nil {
+		log.Error("Failed to send request with body to discard",
+			log.ErrAttr(err))
+	}
+
+	err = response.Body.Close()
+	if err != nil {
+		log.Error("Failed to close response body",
+			log.ErrAttr(err))
+	}
+
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+		return &StatusError{StatusCode: response.StatusCode, Status: response.Status}
+	}
+
+	log.Debug("batch sent",
+		log.IntAttr("size", len(batch)))
+
+	return nil
+}
@@ -0,0 +1,225 @@
+package producer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"metrics/internal/log"
+)
+
+const spoolExt = ".json"
+
+// spool keeps undelivered reports on disk, one file per report, named by a growing sequence number,
+// so they can be replayed in the order they were made. The reports of concurrent senders are pushed
+// and replayed one at a time. Beyond the limit the oldest report is merged into the next one,
+// so its counter deltas and histogram observations are delivered with it.
+type spool struct {
+	mu    sync.Mutex
+	dir   string
+	limit int
+	seq   uint64
+}
+
+func newSpool(dir string, limit int) (*spool, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("create spool dir %s: %w", dir, err)
+	}
+
+	s := &spool{mu: sync.Mutex{}, dir: dir, limit: limit, seq: 0}
+
+	names, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(names) != 0 {
+		last := strings.TrimSuffix(names[len(names)-1], spoolExt)
+
+		s.seq, err = strconv.ParseUint(last, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse spool file %s: %w", last, err)
+		}
+	}
+
+	return s, nil
+}
+
+// push persists the report and merges the oldest ones into the next if the spool is over its limit.
+func (s *spool) push(report []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+
+	name := filepath.Join(s.dir, fmt.Sprintf("%020d%s", s.seq, spoolExt))
+
+	if err := s.write(name, report); err != nil {
+		return err
+	}
+
+	log.Info("report spooled",
+		log.StringAttr("file", name))
+
+	return s.trim()
+}
+
+// write replaces the file at name with the report, atomically so a crash leaves the old or the new one.
+func (s *spool) write(name string, report []byte) error {
+	tmpFile, err := os.CreateTemp(s.dir, "report-*.tmp")
+	if err != nil {
+		return fmt.Errorf("create spool file: %w", err)
+	}
+
+	if _, err = tmpFile.Write(report); err != nil {
+		_ = tmpFile.Close()
+		_ = os.Remove(tmpFile.Name())
+
+		return fmt.Errorf("write spool file: %w", err)
+	}
+
+	if err = tmpFile.Sync(); err != nil {
+		_ = tmpFile.Close()
+		_ = os.Remove(tmpFile.Name())
+
+		return fmt.Errorf("sync spool file: %w", err)
+	}
+
+	if err = tmpFile.Close(); err != nil {
+		_ = os.Remove(tmpFile.Name())
+
+		return fmt.Errorf("close spool file: %w", err)
+	}
+
+	if err = os.Rename(tmpFile.Name(), name); err != nil {
+		return fmt.Errorf("rename spool file: %w", err)
+	}
+
+	return nil
+}
+
+// replay sends spooled reports from the oldest one and stops at the first retriable failure,
+// so the order is kept. Reports rejected by the server for good are dropped.
+func (s *spool) replay(ctx context.Context, send func(ctx context.Context, report []byte) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names, err := s.list()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		path := filepath.Join(s.dir, name)
+
+		report, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("read spool file %s: %w", path, err)
+		}
+
+		err = send(ctx, report)
+		if err != nil && isRetriable(err) {
+			return fmt.Errorf("replay spool file %s: %w", path, err)
+		}
+
+		if err != nil {
+			log.Error("spooled report rejected, dropping",
+				log.StringAttr("file", path),
+				log.ErrAttr(err))
+		}
+
+		if err = os.Remove(path); err != nil {
+			return fmt.Errorf("remove spool file %s: %w", path, err)
+		}
+
+		log.Info("spooled report replayed",
+			log.StringAttr("file", path))
+	}
+
+	return nil
+}
+
+// trim merges the oldest report into the next one while the spool is over its limit.
+// A report that cannot be merged is dropped.
+func (s *spool) trim() error {
+	names, err := s.list()
+	if err != nil {
+		return err
+	}
+
+	for len(names) > s.limit {
+		path := filepath.Join(s.dir, names[0])
+
+		if len(names) > 1 {
+			if err = s.merge(path, filepath.Join(s.dir, names[1])); err != nil {
+				log.Error("spool is full, oldest report dropped",
+					log.StringAttr("file", path),
+					log.ErrAttr(err))
+			} else {
+				log.Warn("spool is full, oldest report merged into the next one",
+					log.StringAttr("file", path))
+			}
+		}
+
+		if err = os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove spool file %s: %w", path, err)
+		}
+
+		names = names[1:]
+	}
+
+	return nil
+}
+
+// merge adds the older report to the newer one as if both were collected between the same reports:
+// the counters sum up, the observations of the histograms are gathered and the newer gauges win.
+func (s *spool) merge(olderPath, newerPath string) error {
+	merged := NewMetrics()
+
+	for _, path := range []string{olderPath, newerPath} {
+		report, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("read spool file %s: %w", path, err)
+		}
+
+		var metrics []Metric
+		if err = json.Unmarshal(report, &metrics); err != nil {
+			return fmt.Errorf("decode spool file %s: %w", path, err)
+		}
+
+		merged.Add(metrics)
+	}
+
+	report, err := prepareBatch(merged.take())
+	if err != nil {
+		return fmt.Errorf("merge spool file %s: %w", olderPath, err)
+	}
+
+	return s.write(newerPath, report)
+}
+
+func (s *spool) list() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("read spool dir %s: %w", s.dir, err)
+	}
+
+	names := make([]string, 0, len(entries))
+
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasSuffix(entry.Name(), spoolExt) {
+			names = append(names, entry.Name())
+		}
+	}
+
+	slices.Sort(names)
+
+	return names, nil
+}
@@ -0,0 +1,51 @@
+package producer
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	"metrics/config"
+	"metrics/internal/certs"
+)
+
+// newTransport returns nil, the default transport, unless the agent talks https. The server
+// is verified against the system roots or the configured CA bundle; the client certificate
+// for mTLS is reloaded on change like the server one.
+func newTransport(cfg config.Producer) (http.RoundTripper, error) {
+	if !cfg.TLS {
+		return nil, nil //nolint:nilnil // default transport
+	}
+
+	tlsConfig := &tls.Config{ //nolint:exhaustruct // defaults are fine
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if cfg.TLSCA != "" {
+		rootCAs, err := certs.LoadCertPool(cfg.TLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("load ca: %w", err)
+		}
+
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	if cfg.TLSCert != "" {
+		reloader, err := certs.NewReloader(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+
+		tlsConfig.GetClientCertificate = reloader.GetClientCertificate
+	}
+
+	transport, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("default transport is %T: %w", http.DefaultTransport, ErrUnexpectedTransport)
+	}
+
+	transport = transport.Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return transport, nil
+}
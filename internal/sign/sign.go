@@ -0,0 +1,30 @@
+// Package sign computes and checks the HMAC-SHA256 signatures exchanged between the agent and the server.
+package sign
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Header carries the hex encoded signature of the uncompressed body.
+const Header = "HashSHA256"
+
+func Sign(key string, data []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	_, _ = mac.Write(data) // always returns nil error
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func Verify(key string, data []byte, signature string) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(key))
+	_, _ = mac.Write(data) // always returns nil error
+
+	return hmac.Equal(mac.Sum(nil), expected)
+}
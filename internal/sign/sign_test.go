@@ -0,0 +1,38 @@
+package sign_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"metrics/internal/sign"
+)
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	data := []byte(`[{"id":"Alloc","type":"gauge","value":1}]`)
+	signature := sign.Sign("secret", data)
+
+	testCases := []struct {
+		name      string
+		key       string
+		data      []byte
+		signature string
+		want      bool
+	}{
+		{name: "valid signature", key: "secret", data: data, signature: signature, want: true},
+		{name: "wrong key", key: "other", data: data, signature: signature, want: false},
+		{name: "changed data", key: "secret", data: []byte(`[]`), signature: signature, want: false},
+		{name: "not hex signature", key: "secret", data: data, signature: "zz", want: false},
+		{name: "empty signature", key: "secret", data: data, signature: "", want: false},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.want, sign.Verify(tt.key, tt.data, tt.signature))
+		})
+	}
+}